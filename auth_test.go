@@ -0,0 +1,1028 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/handlers"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuth(t *testing.T) {
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("s3cret-pass"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user_id", "tenant_id", "email", "password_hash", "created_at"}).
+			AddRow(testUUID, testTenantID, "jane@example.com", string(passwordHash), time.Now())
+	}
+
+	roleRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"role_name", "scopes"}).
+			AddRow("editor", []byte(`["company:read","company:write"]`)).
+			AddRow("viewer", []byte(`["company:read"]`))
+	}
+
+	t.Run("Login", func(t *testing.T) {
+
+		t.Run("Invalid credentials", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getUserByEmailQuery).
+				WithArgs("jane@example.com").
+				WillReturnRows(userRows())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/auth/login", strings.NewReader(`{"email":"jane@example.com","password":"wrong"}`))
+			r.Header.Set("Content-Type", "application/json")
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"invalid email or password"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Unknown user", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getUserByEmailQuery).
+				WithArgs("john@example.com").
+				WillReturnError(sql.ErrNoRows)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/auth/login", strings.NewReader(`{"email":"john@example.com","password":"s3cret-pass"}`))
+			r.Header.Set("Content-Type", "application/json")
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"invalid email or password"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getUserByEmailQuery).
+				WithArgs("jane@example.com").
+				WillReturnRows(userRows())
+
+			mockDB.ExpectQuery(getUserRolesQuery).
+				WithArgs(testUUID).
+				WillReturnRows(roleRows())
+
+			mockDB.ExpectExec(insertRefreshTokenQuery).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), testUUID, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/auth/login", strings.NewReader(`{"email":"jane@example.com","password":"s3cret-pass"}`))
+			r.Header.Set("Content-Type", "application/json")
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			var tokens models.TokenResp
+			require.NoError(json.NewDecoder(resp.Body).Decode(&tokens))
+
+			assert.Equal(200, resp.StatusCode)
+			assert.Equal("Bearer", tokens.TokenType)
+			assert.Equal(int64(3600), tokens.ExpiresIn)
+
+			claims, err := helpers.ValidateAccessToken(tokens.AccessToken, cfg)
+			require.NoError(err)
+			assert.Equal(testUUID, claims.UserID)
+			assert.Equal(testTenantID, claims.TenantID)
+			assert.Equal([]string{"editor", "viewer"}, claims.Roles)
+			assert.Equal([]string{"company:read", "company:write"}, claims.Scopes)
+
+			_, err = helpers.ValidateAccessToken(tokens.RefreshToken, cfg)
+			assert.Error(err, "a refresh token must not be accepted as an access token")
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+
+		t.Run("Access token rejected", func(t *testing.T) {
+			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/auth/refresh", strings.NewReader(fmt.Sprintf(`{"refreshToken":"%s"}`, accessToken)))
+			r.Header.Set("Content-Type", "application/json")
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"token is invalid"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+		})
+
+		t.Run("Reused", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			refreshToken, err := helpers.GenerateRefreshToken(cfg, testUUID, "5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b")
+			require.NoError(err)
+
+			mockDB.ExpectQuery(getUserByIDQuery).
+				WithArgs(testUUID).
+				WillReturnRows(userRows())
+
+			mockDB.ExpectQuery(getUserRolesQuery).
+				WithArgs(testUUID).
+				WillReturnRows(roleRows())
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(lockRefreshTokenQuery).
+				WithArgs("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b").
+				WillReturnRows(
+					sqlmock.NewRows([]string{"family_id", "user_id", "expired", "used_at", "revoked_at"}).
+						AddRow("d2c1b0a9-8f7e-4d6c-9b5a-4f3e2d1c0b9a", testUUID, false, time.Now(), nil),
+				)
+
+			mockDB.ExpectExec(`
+				UPDATE refresh_tokens
+				SET
+					revoked_at = CURRENT_TIMESTAMP
+				WHERE
+					family_id = $1
+					AND revoked_at IS NULL
+			`).
+				WithArgs("d2c1b0a9-8f7e-4d6c-9b5a-4f3e2d1c0b9a").
+				WillReturnResult(sqlmock.NewResult(0, 2))
+
+			mockDB.ExpectCommit()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/auth/refresh", strings.NewReader(fmt.Sprintf(`{"refreshToken":"%s"}`, refreshToken)))
+			r.Header.Set("Content-Type", "application/json")
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"token is invalid"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			refreshToken, err := helpers.GenerateRefreshToken(cfg, testUUID, "5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b")
+			require.NoError(err)
+
+			mockDB.ExpectQuery(getUserByIDQuery).
+				WithArgs(testUUID).
+				WillReturnRows(userRows())
+
+			mockDB.ExpectQuery(getUserRolesQuery).
+				WithArgs(testUUID).
+				WillReturnRows(roleRows())
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(lockRefreshTokenQuery).
+				WithArgs("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b").
+				WillReturnRows(
+					sqlmock.NewRows([]string{"family_id", "user_id", "expired", "used_at", "revoked_at"}).
+						AddRow("d2c1b0a9-8f7e-4d6c-9b5a-4f3e2d1c0b9a", testUUID, false, nil, nil),
+				)
+
+			mockDB.ExpectExec(`
+				UPDATE refresh_tokens
+				SET
+					used_at = CURRENT_TIMESTAMP
+				WHERE
+					token_id = $1
+			`).
+				WithArgs("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mockDB.ExpectExec(insertRefreshTokenQuery).
+				WithArgs(sqlmock.AnyArg(), "d2c1b0a9-8f7e-4d6c-9b5a-4f3e2d1c0b9a", testUUID, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectCommit()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/auth/refresh", strings.NewReader(fmt.Sprintf(`{"refreshToken":"%s"}`, refreshToken)))
+			r.Header.Set("Content-Type", "application/json")
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			var tokens models.TokenResp
+			require.NoError(json.NewDecoder(resp.Body).Decode(&tokens))
+
+			assert.Equal(200, resp.StatusCode)
+
+			claims, err := helpers.ValidateRefreshToken(tokens.RefreshToken, cfg)
+			require.NoError(err)
+			assert.NotEqual("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", claims.ID)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Revoked token rejected", func(t *testing.T) {
+		revocations := newTestRevocations()
+		assert, require, _, testHTTPHandler := newTestHTTPHandlerWithRevocations(t, revocations)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		claims, err := helpers.ValidateAccessToken(accessToken, cfg)
+		require.NoError(err)
+		require.NotEmpty(claims.ID)
+		revocations.tokens[claims.ID] = true
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("DELETE", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"error":"unauthorized"}`, string(body))
+		}
+
+		assert.Equal(401, resp.StatusCode)
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		revocations := newTestRevocations()
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandlerWithRevocations(t, revocations)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		refreshToken, err := helpers.GenerateRefreshToken(cfg, testUUID, "5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b")
+		require.NoError(err)
+
+		mockDB.ExpectExec(`
+			UPDATE refresh_tokens
+			SET
+				revoked_at = CURRENT_TIMESTAMP
+			WHERE
+				family_id = (SELECT family_id FROM refresh_tokens WHERE token_id = $1)
+				AND revoked_at IS NULL
+		`).
+			WithArgs("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http:/api/v1/auth/logout", strings.NewReader(fmt.Sprintf(`{"refreshToken":"%s"}`, refreshToken)))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(204, w.Result().StatusCode)
+
+		claims, err := helpers.ValidateAccessToken(accessToken, cfg)
+		require.NoError(err)
+		assert.True(revocations.tokens[claims.ID])
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("POST", "http:/api/v1/auth/logout", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(401, w.Result().StatusCode)
+	})
+
+	t.Run("Revoke user tokens", func(t *testing.T) {
+
+		t.Run("Forbidden", func(t *testing.T) {
+			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/admin/users/"+testUUID+"/revoke-tokens", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			assert.Equal(403, w.Result().StatusCode)
+		})
+
+		t.Run("success", func(t *testing.T) {
+			revocations := newTestRevocations()
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandlerWithRevocations(t, revocations)
+
+			mockDB.ExpectQuery(getUserByIDQuery).
+				WithArgs(testUUID).
+				WillReturnRows(userRows())
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/admin/users/"+testUUID+"/revoke-tokens", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			assert.Equal(204, w.Result().StatusCode)
+			assert.True(revocations.users[testUUID])
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("User of another tenant", func(t *testing.T) {
+			revocations := newTestRevocations()
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandlerWithRevocations(t, revocations)
+
+			mockDB.ExpectQuery(getUserByIDQuery).
+				WithArgs(testUUID).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "tenant_id", "email", "password_hash", "created_at"}).
+					AddRow(testUUID, "globex", "jane@example.com", string(passwordHash), time.Now()))
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/admin/users/"+testUUID+"/revoke-tokens", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			assert.Equal(404, w.Result().StatusCode)
+			assert.False(revocations.users[testUUID])
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Create user", func(t *testing.T) {
+
+		const insertUserQuery = `
+			INSERT INTO users (tenant_id, email, password_hash)
+			VALUES ($1, $2, $3)
+			RETURNING user_id, tenant_id, email, password_hash, created_at
+		`
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(insertUserQuery).
+				WithArgs(testTenantID, "jane@example.com", sqlmock.AnyArg()).
+				WillReturnRows(userRows())
+
+			mockDB.ExpectExec(`
+				INSERT INTO user_roles (user_id, role_name)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING
+			`).
+				WithArgs(testUUID, "editor").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mockDB.ExpectCommit()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/admin/users", strings.NewReader(`{"email":"jane@example.com","password":"s3cret-pass-phrase","roles":["editor"]}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			var user models.User
+			require.NoError(json.NewDecoder(resp.Body).Decode(&user))
+			assert.Equal(testUUID, user.ID)
+			assert.Equal(testTenantID, user.TenantID)
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Email taken", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(insertUserQuery).
+				WithArgs(testTenantID, "jane@example.com", sqlmock.AnyArg()).
+				WillReturnError(&pgconn.PgError{Code: "23505"})
+
+			mockDB.ExpectRollback()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/admin/users", strings.NewReader(`{"email":"jane@example.com","password":"s3cret-pass-phrase"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"duplicate record"}`, string(body))
+			}
+
+			assert.Equal(409, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Password too short", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/admin/users", strings.NewReader(`{"email":"jane@example.com","password":"short"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			assert.Equal(422, w.Result().StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+
+		t.Run("List", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					role_name, scopes
+				FROM roles
+				ORDER BY role_name
+			`).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"role_name", "scopes"}).
+						AddRow("editor", []byte(`["company:read","company:write"]`)).
+						AddRow("viewer", []byte(`["company:read"]`)),
+				)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/admin/roles", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"data":[{"name":"editor","scopes":["company:read","company:write"]},{"name":"viewer","scopes":["company:read"]}]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Unknown role", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(`
+				SELECT EXISTS (SELECT 1 FROM users WHERE user_id::text = $1 AND tenant_id = $2)
+			`).
+				WithArgs(testUUID, testTenantID).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+			mockDB.ExpectExec(`
+				DELETE FROM user_roles
+				WHERE
+					user_id::text = $1
+			`).
+				WithArgs(testUUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mockDB.ExpectExec(`
+				INSERT INTO user_roles (user_id, role_name)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING
+			`).
+				WithArgs(testUUID, "auditor").
+				WillReturnError(&pgconn.PgError{Code: "23503"})
+
+			mockDB.ExpectRollback()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PUT", "http:/api/v1/admin/users/"+testUUID+"/roles", strings.NewReader(`{"roles":["auditor"]}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/admin/users/af7c1fe6-d669-414e-b066-e9733f0de7a8/roles","errors":[{"field":"roles","rule":"exists","message":"roles must name existing roles"}]}`, string(body))
+			}
+
+			assert.Equal(422, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Roles of a user of another tenant", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(`
+				SELECT EXISTS (SELECT 1 FROM users WHERE user_id::text = $1 AND tenant_id = $2)
+			`).
+				WithArgs(testUUID, testTenantID).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+			mockDB.ExpectRollback()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PUT", "http:/api/v1/admin/users/"+testUUID+"/roles", strings.NewReader(`{"roles":["admin"]}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			assert.Equal(404, w.Result().StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Only platform admins edit roles", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			tenantAdmin := testAdminIdentity
+			tenantAdmin.UserID = testUUID
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, tenantAdmin)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PUT", "http:/api/v1/admin/roles/editor", strings.NewReader(`{"scopes":["admin"]}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"insufficient scope"}`, string(body))
+			}
+
+			assert.Equal(403, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("API keys", func(t *testing.T) {
+
+		apiKeyColumns := []string{
+			"key_id", "prefix", "name", "owner_id", "tenant_id", "scopes",
+			"expires_at", "last_used_at", "revoked_at", "created_at",
+		}
+
+		const getAPIKeyByPrefixQuery = `
+			SELECT
+				key_id, prefix, name, owner_id, tenant_id, scopes, expires_at, last_used_at, revoked_at, created_at, key_hash
+			FROM api_keys
+			WHERE prefix = $1
+		`
+
+		t.Run("Create", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				INSERT INTO api_keys (prefix, key_hash, name, owner_id, tenant_id, scopes, expires_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				RETURNING key_id, prefix, name, owner_id, tenant_id, scopes, expires_at, last_used_at, revoked_at, created_at
+			`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "etl", testUUID, testTenantID, []byte(`["company:write"]`), nil).
+				WillReturnRows(
+					sqlmock.NewRows(apiKeyColumns).
+						AddRow("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", "0a1b2c3d", "etl", testUUID, testTenantID, []byte(`["company:write"]`), nil, nil, nil, time.Now()),
+				)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/api-keys", strings.NewReader(`{"name":"etl","scopes":["company:write"]}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			var key models.NewAPIKeyResp
+			require.NoError(json.NewDecoder(resp.Body).Decode(&key))
+
+			assert.Equal(200, resp.StatusCode)
+			assert.Equal("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", key.ID)
+			assert.Equal([]string{"company:write"}, key.Scopes)
+			_, ok := helpers.ParseAPIKey(key.Key)
+			assert.True(ok)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Scope not granted", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/api-keys", strings.NewReader(`{"name":"etl","scopes":["admin"]}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"insufficient scope"}`, string(body))
+			}
+
+			assert.Equal(403, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Authenticates", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			key, prefix, hash, err := helpers.GenerateAPIKey()
+			require.NoError(err)
+
+			mockDB.ExpectQuery(getAPIKeyByPrefixQuery).
+				WithArgs(prefix).
+				WillReturnRows(
+					sqlmock.NewRows(append(apiKeyColumns, "key_hash")).
+						AddRow("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", prefix, "etl", testUUID, testTenantID, []byte(`["company:read"]`), nil, nil, nil, time.Now(), hash),
+				)
+
+			mockDB.ExpectExec(`
+				UPDATE api_keys
+				SET
+					last_used_at = CURRENT_TIMESTAMP
+				WHERE
+					key_id::text = $1
+					AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+			`).
+				WithArgs("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", float64(60)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mockDB.ExpectQuery(getUserRolesQuery).
+				WithArgs(testUUID).
+				WillReturnRows(roleRows())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`{"name":"example"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-API-Key", key)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			// the key is accepted but only grants company:read
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"insufficient scope"}`, string(body))
+			}
+
+			assert.Equal(403, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Owner lost the scopes of the key", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			key, prefix, hash, err := helpers.GenerateAPIKey()
+			require.NoError(err)
+
+			mockDB.ExpectQuery(getAPIKeyByPrefixQuery).
+				WithArgs(prefix).
+				WillReturnRows(
+					sqlmock.NewRows(append(apiKeyColumns, "key_hash")).
+						AddRow("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", prefix, "etl", testUUID, testTenantID, []byte(`["company:read","company:delete"]`), nil, nil, nil, time.Now(), hash),
+				)
+
+			mockDB.ExpectExec(`
+				UPDATE api_keys
+				SET
+					last_used_at = CURRENT_TIMESTAMP
+				WHERE
+					key_id::text = $1
+					AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+			`).
+				WithArgs("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", float64(60)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mockDB.ExpectQuery(getUserRolesQuery).
+				WithArgs(testUUID).
+				WillReturnRows(roleRows())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("DELETE", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Set("X-API-Key", key)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"insufficient scope"}`, string(body))
+			}
+
+			assert.Equal(403, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Tokens of the owner revoked", func(t *testing.T) {
+			revocations := newTestRevocations()
+			revocations.users[testUUID] = true
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandlerWithRevocations(t, revocations)
+
+			key, prefix, hash, err := helpers.GenerateAPIKey()
+			require.NoError(err)
+
+			mockDB.ExpectQuery(getAPIKeyByPrefixQuery).
+				WithArgs(prefix).
+				WillReturnRows(
+					sqlmock.NewRows(append(apiKeyColumns, "key_hash")).
+						AddRow("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", prefix, "etl", testUUID, testTenantID, []byte(`["company:write"]`), nil, nil, nil, time.Now(), hash),
+				)
+
+			mockDB.ExpectExec(`
+				UPDATE api_keys
+				SET
+					last_used_at = CURRENT_TIMESTAMP
+				WHERE
+					key_id::text = $1
+					AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+			`).
+				WithArgs("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", float64(60)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mockDB.ExpectQuery(getUserRolesQuery).
+				WithArgs(testUUID).
+				WillReturnRows(roleRows())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`{"name":"example"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-API-Key", key)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"unauthorized"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Revoked", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			key, prefix, hash, err := helpers.GenerateAPIKey()
+			require.NoError(err)
+
+			mockDB.ExpectQuery(getAPIKeyByPrefixQuery).
+				WithArgs(prefix).
+				WillReturnRows(
+					sqlmock.NewRows(append(apiKeyColumns, "key_hash")).
+						AddRow("5b0e4a8f-9f0c-4a51-8d8b-1c2d3e4f5a6b", prefix, "etl", testUUID, testTenantID, []byte(`["company:write"]`), nil, nil, time.Now(), time.Now(), hash),
+				)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`{"name":"example"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-API-Key", key)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"unauthorized"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Asymmetric signing", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		previous, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+
+		current, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(err)
+
+		previousCfg := cfg
+		previousCfg.JWTSigningMethod = config.SigningMethodES256
+		previousCfg.JWTSigningKey = previous
+		previousCfg.JWTKeyID = "2023-01"
+
+		rotatedCfg := cfg
+		rotatedCfg.JWTSecretKey = ""
+		rotatedCfg.JWTSigningMethod = config.SigningMethodRS256
+		rotatedCfg.JWTSigningKey = current
+		rotatedCfg.JWTKeyID = "2023-02"
+		rotatedCfg.JWTVerificationKeys = map[string]crypto.PublicKey{
+			"2023-01": previous.Public(),
+			"2023-02": current.Public(),
+		}
+
+		oldToken, err := helpers.GenerateAccessToken(previousCfg, testIdentity)
+		require.NoError(err)
+
+		newToken, err := helpers.GenerateAccessToken(rotatedCfg, testIdentity)
+		require.NoError(err)
+
+		for _, token := range []string{oldToken, newToken} {
+			claims, err := helpers.ValidateAccessToken(token, rotatedCfg)
+			if assert.NoError(err) {
+				assert.Equal(testUUID, claims.UserID)
+			}
+		}
+
+		hmacToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+		_, err = helpers.ValidateAccessToken(hmacToken, rotatedCfg)
+		assert.Error(err, "HMAC tokens must be rejected once the secret is removed")
+
+		rotatedCfg.JWTSecretKey = cfg.JWTSecretKey
+		_, err = helpers.ValidateAccessToken(hmacToken, rotatedCfg)
+		assert.Error(err, "HMAC tokens must be rejected with an asymmetric method even if the secret is set")
+
+		rotatedCfg.JWTLegacyHS256Until = time.Now().Add(time.Hour)
+		_, err = helpers.ValidateAccessToken(hmacToken, rotatedCfg)
+		assert.NoError(err, "HMAC tokens are accepted until the legacy deadline")
+
+		rotatedCfg.JWTLegacyHS256Until = time.Now().Add(-time.Hour)
+		_, err = helpers.ValidateAccessToken(hmacToken, rotatedCfg)
+		assert.Error(err, "HMAC tokens must be rejected after the legacy deadline")
+		rotatedCfg.JWTSecretKey = ""
+
+		delete(rotatedCfg.JWTVerificationKeys, "2023-01")
+		_, err = helpers.ValidateAccessToken(oldToken, rotatedCfg)
+		assert.Error(err, "tokens of a retired key must be rejected")
+
+		validator, err := helpers.NewValidation()
+		require.NoError(err)
+
+		testHandler := handlers.NewHandler(nil, validator, newTestRevocations(), rotatedCfg)
+		testHTTPHandler := newHTTPHandler(testHandler, nil, validator, newTestRevocations(), rotatedCfg)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/.well-known/jwks.json", nil)
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+		assert.Equal(200, resp.StatusCode)
+
+		var jwks helpers.JWKS
+		require.NoError(json.NewDecoder(resp.Body).Decode(&jwks))
+		if assert.Len(jwks.Keys, 1) {
+			assert.Equal("2023-02", jwks.Keys[0].Kid)
+			assert.Equal("RSA", jwks.Keys[0].Kty)
+			assert.Equal("RS256", jwks.Keys[0].Alg)
+			assert.Equal("AQAB", jwks.Keys[0].E)
+		}
+	})
+
+	t.Run("Test token disabled outside dev mode", func(t *testing.T) {
+		assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/token", nil)
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(404, w.Result().StatusCode)
+	})
+
+	t.Run("Test token only reads and writes companies", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		devCfg := cfg
+		devCfg.DevMode = true
+
+		db, _ := dbtest.New(t)
+		r := repo.NewRepository(db)
+		validator, err := helpers.NewValidation()
+		require.NoError(err)
+		revocations := newTestRevocations()
+		testHTTPHandler := newHTTPHandler(handlers.NewHandler(r, validator, revocations, devCfg), r, validator, revocations, devCfg)
+
+		w := httptest.NewRecorder()
+		testHTTPHandler.ServeHTTP(w, httptest.NewRequest("GET", "http:/api/v1/token", nil))
+
+		resp := w.Result()
+		require.Equal(200, resp.StatusCode)
+
+		var body struct{ Token string }
+		require.NoError(json.NewDecoder(resp.Body).Decode(&body))
+
+		claims, err := helpers.ValidateAccessToken(body.Token, devCfg)
+		require.NoError(err)
+		assert.Equal([]string{helpers.ScopeCompanyRead, helpers.ScopeCompanyWrite}, claims.Scopes)
+		assert.NotContains(claims.Roles, helpers.RoleAdmin)
+	})
+}
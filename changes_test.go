@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/helpers"
+)
+
+func TestCompanyChanges(t *testing.T) {
+
+	t.Run("Resume after the last event with a filter", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		changedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+		mockDB.ExpectQuery(listCompanyChangesQuery).
+			WithArgs(testTenantID, 41, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"history_id", "version", "operation", "company_id", "company_name", "description", "amount_of_employees", "is_registered", "company_type", "diff", "changed_by", "changed_at"}).
+				AddRow(42, 2, "updated", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "XM", "broker", 50, true, "Corporations", []byte(`{"amountOfEmployees":{"from":40,"to":50}}`), testUUID, changedAt).
+				AddRow(43, 1, "created", "f2b1c0de-0000-4000-8000-000000000043", "Co-op", "", 5, false, "Cooperative", []byte(`{}`), testUUID, changedAt))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/company/changes?companyType=Corporations", nil).WithContext(ctx)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		r.Header.Set("Last-Event-ID", "41")
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(err)
+		assert.Equal("id:42\n"+
+			"event:updated\n"+
+			`data:{"historyId":42,"version":2,"operation":"updated","company":{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"XM","description":"broker","amountOfEmployees":50,"registered":true,"companyType":"Corporations"},"diff":{"amountOfEmployees":{"from":40,"to":50}},"changedBy":"`+testUUID+`","changedAt":"2023-01-02T03:04:05Z"}`+"\n\n",
+			string(body))
+
+		assert.Equal(200, resp.StatusCode)
+		assert.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Wait for an open transaction before streaming its change", func(t *testing.T) {
+		pollCfg := cfg
+		pollCfg.WatchPollInterval = 10 * time.Millisecond
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandlerWithConfig(t, newTestRevocations(), pollCfg)
+
+		// the change is held back while the transaction writing it, or any older one, is
+		// still running, and streamed by a later poll once it has finished
+		changedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+		mockDB.ExpectQuery(listCompanyChangesQuery).
+			WithArgs(testTenantID, 41, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"history_id", "version", "operation", "company_id", "company_name", "description", "amount_of_employees", "is_registered", "company_type", "diff", "changed_by", "changed_at"}))
+		mockDB.ExpectQuery(listCompanyChangesQuery).
+			WithArgs(testTenantID, 41, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"history_id", "version", "operation", "company_id", "company_name", "description", "amount_of_employees", "is_registered", "company_type", "diff", "changed_by", "changed_at"}).
+				AddRow(42, 1, "created", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "XM", "broker", 50, true, "Corporations", []byte(`{}`), testUUID, changedAt))
+		// ends the feed
+		mockDB.ExpectQuery(listCompanyChangesQuery).
+			WithArgs(testTenantID, 42, 100).
+			WillReturnError(sql.ErrConnDone)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/company/changes", nil).WithContext(ctx)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		r.Header.Set("Last-Event-ID", "41")
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		body, err := io.ReadAll(w.Result().Body)
+		require.NoError(err)
+		assert.Equal("id:42\n"+
+			"event:created\n"+
+			`data:{"historyId":42,"version":1,"operation":"created","company":{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"XM","description":"broker","amountOfEmployees":50,"registered":true,"companyType":"Corporations"},"diff":{},"changedBy":"`+testUUID+`","changedAt":"2023-01-02T03:04:05Z"}`+"\n\n",
+			string(body))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/company/changes", nil)
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(401, w.Result().StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Missing scope", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, helpers.Identity{
+			UserID:   testUUID,
+			TenantID: testTenantID,
+			Roles:    []string{"writer"},
+			Scopes:   []string{helpers.ScopeCompanyWrite},
+		})
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/company/changes", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(403, w.Result().StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid Last-Event-ID", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/company/changes", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		r.Header.Set("Last-Event-ID", "latest")
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(422, w.Result().StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid company IDs", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/company/changes?companyIds=ae17b2e2-6b87-4c5b-9c94-3623dacf113b&companyIds=42", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(422, w.Result().StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
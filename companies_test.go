@@ -0,0 +1,2064 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/models"
+)
+
+func TestCompany(t *testing.T) {
+
+	t.Run("Create Company", func(t *testing.T) {
+		t.Run("Invalid Token", func(t *testing.T) {
+			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			const invalidAccessToken = "invalid token"
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
+				{
+					"name": "example",
+					"description": "company description",
+					"amountOfEmployees": 2,
+					"registered": false,
+					"companyType": "Non Profit"
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", invalidAccessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"unauthorized"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+
+		})
+
+		t.Run("Missing scope", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, helpers.Identity{
+				UserID: testUUID,
+				Roles:  []string{"viewer"},
+				Scopes: []string{helpers.ScopeCompanyRead},
+			})
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
+				{
+					"name": "example",
+					"description": "company description",
+					"amountOfEmployees": 2,
+					"registered": false,
+					"companyType": "Non Profit"
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"insufficient scope"}`, string(body))
+			}
+
+			assert.Equal(403, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Invalid Parameter", func(t *testing.T) {
+			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
+				{
+					"name": "example",
+					"description": "company description",
+					"amountOfEmployees": 2,
+					"registered": false,
+					"companyType": "invalid type"
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company","errors":[{"field":"companyType","rule":"eq","allowed":["Corporations","Non Profit","Cooperative","Sole Proprietorship"],"message":"companyType must be one of: Corporations, Non Profit, Cooperative, Sole Proprietorship"}]}`, string(body))
+			}
+
+			assert.Equal("application/problem+json", resp.Header.Get("Content-Type"))
+
+			assert.Equal(422, resp.StatusCode)
+
+		})
+
+		t.Run("Invalid JSON type", func(t *testing.T) {
+			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
+				{
+					"name": "example",
+					"amountOfEmployees": "two",
+					"companyType": "Non Profit"
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company","errors":[{"field":"amountOfEmployees","rule":"type","param":"int64","message":"amountOfEmployees must be an integer"}]}`, string(body))
+			}
+
+			assert.Equal(422, resp.StatusCode)
+		})
+
+		t.Run("Duplicate company", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type
+				FROM companies
+				WHERE company_name = $1
+					AND tenant_id = $2
+					AND deleted_at IS NULL
+			`).
+				WithArgs("example", testTenantID).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type",
+						},
+					).
+						FromCSVString("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3,example,company description,2,false,Non Profit"),
+				)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
+				{
+					"name": "example",
+					"description": "company description",
+					"amountOfEmployees": 2,
+					"registered": false,
+					"companyType": "Non Profit"
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"duplicate record"}`, string(body))
+			}
+
+			assert.Equal(409, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type
+				FROM companies
+				WHERE company_name = $1
+					AND tenant_id = $2
+					AND deleted_at IS NULL
+			`).
+				WithArgs("example12", testTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(`
+				INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				RETURNING company_id
+			`).
+				WithArgs(testTenantID, "example12", "company description", 2, false, "Non Profit").
+				WillReturnRows(
+					sqlmock.NewRows([]string{"company_id"}).
+						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b"),
+				)
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WithArgs(
+					testTenantID, "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", 1, "created",
+					"example12", "company description", 2, false, "Non Profit",
+					sqlmock.AnyArg(), testUUID,
+				).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WithArgs(sqlmock.AnyArg(), "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "company.created", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectCommit()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
+				{
+					"name": "example12",
+					"description": "company description",
+					"amountOfEmployees": 2,
+					"registered": false,
+					"companyType": "Non Profit"
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				log.Println(string(body))
+				// assert.Equal(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example12","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit"}`, string(body))
+			}
+			log.Println(resp.StatusCode)
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+		t.Run("Idempotency key stored", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(reserveIdempotencyKeyQuery).
+				WithArgs(testUUID, "create-example12", sqlmock.AnyArg(), float64(3600), float64(300)).
+				WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type
+				FROM companies
+				WHERE company_name = $1
+					AND tenant_id = $2
+					AND deleted_at IS NULL
+			`).
+				WithArgs("example12", testTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(`
+				INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				RETURNING company_id
+			`).
+				WithArgs(testTenantID, "example12", "company description", 2, false, "Non Profit").
+				WillReturnRows(
+					sqlmock.NewRows([]string{"company_id"}).
+						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b"),
+				)
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectCommit()
+
+			mockDB.ExpectExec(`
+				UPDATE idempotency_keys
+				SET
+					status_code = $3,
+					content_type = $4,
+					response_body = $5
+				WHERE
+					user_id = $1
+					AND idempotency_key = $2
+			`).
+				WithArgs(
+					testUUID, "create-example12", 200, "application/json; charset=utf-8",
+					[]byte(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example12","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit"}`),
+				).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`{"name":"example12","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Idempotency-Key", "create-example12")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			_, err = io.ReadAll(resp.Body)
+			assert.NoError(err)
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("outbox failure rolls back", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type
+				FROM companies
+				WHERE company_name = $1
+					AND tenant_id = $2
+					AND deleted_at IS NULL
+			`).
+				WithArgs("example12", testTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(`
+				INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				RETURNING company_id
+			`).
+				WithArgs(testTenantID, "example12", "company description", 2, false, "Non Profit").
+				WillReturnRows(
+					sqlmock.NewRows([]string{"company_id"}).
+						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b"),
+				)
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WillReturnError(ErrDBConnection)
+
+			mockDB.ExpectRollback()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
+				{
+					"name": "example12",
+					"description": "company description",
+					"amountOfEmployees": 2,
+					"registered": false,
+					"companyType": "Non Profit"
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"request could not be processed"}`, string(body))
+			}
+
+			assert.Equal(500, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Update Company", func(t *testing.T) {
+
+		t.Run("Invalid Token", func(t *testing.T) {
+			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			const invalidAccessToken = "invalid token"
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PATCH", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", strings.NewReader(`
+				{
+					"description": "company description",
+					"amountOfEmployees": 2
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", invalidAccessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"unauthorized"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+
+		})
+
+		t.Run("Invalid Parameter", func(t *testing.T) {
+			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PATCH", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", strings.NewReader(`
+				{
+					"amountOfEmployees": -1
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b","errors":[{"field":"amountOfEmployees","rule":"gte","param":"0","message":"amountOfEmployees must be at least 0"}]}`, string(body))
+			}
+
+			assert.Equal(422, resp.StatusCode)
+
+		})
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getCompanyByIDQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,3"))
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(lockCompanyQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,3"))
+
+			mockDB.ExpectExec(`
+				UPDATE companies 
+				SET 
+					company_name = coalesce($2, company_name), 
+					description = coalesce($3, description), 
+					amount_of_employees = coalesce($4, amount_of_employees), 
+					is_registered = coalesce($5, is_registered), 
+					company_type = coalesce($6, company_type),
+					modified_at = now(),
+					version = version + 1
+				WHERE
+					company_id = $1
+					AND tenant_id = $7
+					AND deleted_at IS NULL
+			`).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WithArgs(
+					testTenantID, "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", 4, "updated",
+					"example", "company description", 2, false, "Non Profit",
+					[]byte(`{"amountOfEmployees":{"from":3,"to":2}}`), testUUID,
+				).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WithArgs(sqlmock.AnyArg(), "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "company.updated", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectCommit()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PATCH", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", strings.NewReader(`
+				{
+					"amountOfEmployees": 2
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			_, err = io.ReadAll(resp.Body)
+			assert.NoError(err)
+
+			assert.Equal(200, resp.StatusCode)
+			assert.Equal(`"4"`, resp.Header.Get("ETag"))
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("No record", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getCompanyByIDQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PATCH", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", strings.NewReader(`
+				{
+					"amountOfEmployees": 2
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"no record found"}`, string(body))
+			}
+
+			assert.Equal(404, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Version mismatch", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getCompanyByIDQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,3"))
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(lockCompanyQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,3"))
+
+			mockDB.ExpectRollback()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PATCH", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", strings.NewReader(`
+				{
+					"amountOfEmployees": 2
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("If-Match", `"2"`)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"precondition failed"}`, string(body))
+			}
+
+			assert.Equal(412, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Delete Company", func(t *testing.T) {
+
+		t.Run("Invalid Token", func(t *testing.T) {
+			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			const invalidAccessToken = "invalid token"
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("DELETE", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", invalidAccessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"unauthorized"}`, string(body))
+			}
+
+			assert.Equal(401, resp.StatusCode)
+
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(lockCompanyQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,3"))
+
+			mockDB.ExpectExec(`
+				UPDATE companies 
+				SET 
+					deleted_at = now(),
+					version = version + 1
+				WHERE
+					company_id = $1
+					AND tenant_id = $2
+					AND deleted_at IS NULL
+			`).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WithArgs(
+					testTenantID, "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", 4, "deleted",
+					"example", "company description", 3, false, "Non Profit",
+					[]byte(`{}`), testUUID,
+				).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WithArgs(sqlmock.AnyArg(), "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "company.deleted", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectCommit()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("DELETE", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			_, err = io.ReadAll(resp.Body)
+			assert.NoError(err)
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("No record", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(lockCompanyQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			mockDB.ExpectRollback()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("DELETE", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"no record found"}`, string(body))
+			}
+
+			assert.Equal(404, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Batch Companies", func(t *testing.T) {
+
+		t.Run("Unknown method", func(t *testing.T) {
+			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company:merge", strings.NewReader(`{}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"Resource not found"}`, string(body))
+			}
+
+			assert.Equal(404, resp.StatusCode)
+		})
+
+		t.Run("Invalid operation", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company:batch", strings.NewReader(`
+				{
+					"operations": [
+						{"op": "create", "data": {"name": "alpha", "companyType": "Non Profit"}},
+						{"op": "create", "data": {"companyType": "Non Profit"}},
+						{"op": "update"}
+					]
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"mode":"atomic","results":[`+
+					`{"index":0,"status":424,"error":"not applied, another operation of the batch failed"},`+
+					`{"index":1,"status":422,"error":"invalid parameters","errors":[{"field":"data.name","rule":"required","message":"name is required"}]},`+
+					`{"index":2,"status":422,"error":"invalid parameters","errors":[{"field":"id","rule":"required","message":"id is required"}]}`+
+					`]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Delete without scope", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, helpers.Identity{
+				UserID:   testUUID,
+				TenantID: testTenantID,
+				Scopes:   []string{helpers.ScopeCompanyRead, helpers.ScopeCompanyWrite},
+			})
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company:batch", strings.NewReader(`
+				{
+					"mode": "bestEffort",
+					"operations": [
+						{"op": "delete", "id": "ae17b2e2-6b87-4c5b-9c94-3623dacf113b"}
+					]
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"mode":"bestEffort","results":[{"index":0,"status":403,"error":"insufficient scope"}]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Atomic rolled back", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(batchInsertCompaniesQuery).
+				WithArgs(testTenantID, "alpha", "", 0, false, "Non Profit").
+				WillReturnRows(
+					sqlmock.NewRows([]string{"company_id", "company_name"}).
+						AddRow("0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", "alpha"),
+				)
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WithArgs(
+					testTenantID, "0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", 1, "created",
+					"alpha", "", 0, false, "Non Profit",
+					sqlmock.AnyArg(), testUUID,
+				).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WithArgs(sqlmock.AnyArg(), "0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", "company.created", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectQuery(lockCompanyQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			mockDB.ExpectRollback()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company:batch", strings.NewReader(`
+				{
+					"mode": "atomic",
+					"operations": [
+						{"op": "create", "data": {"name": "alpha", "companyType": "Non Profit"}},
+						{"op": "update", "id": "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "data": {"amountOfEmployees": 2}},
+						{"op": "delete", "id": "ae17b2e2-6b87-4c5b-9c94-3623dacf113c"}
+					]
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"mode":"atomic","results":[`+
+					`{"index":0,"status":424,"error":"not applied, another operation of the batch failed"},`+
+					`{"index":1,"status":404,"error":"no record found"},`+
+					`{"index":2,"status":424,"error":"not applied, another operation of the batch failed"}`+
+					`]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Best effort", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectExec(`SAVEPOINT batch_change`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectQuery(`
+				INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type)
+				VALUES ($1, $2, $3, $4, $5, $6), ($7, $8, $9, $10, $11, $12)
+				ON CONFLICT (tenant_id, company_name) WHERE deleted_at IS NULL DO NOTHING
+				RETURNING company_id, company_name
+			`).
+				WithArgs(
+					testTenantID, "alpha", "", 0, false, "Non Profit",
+					testTenantID, "example", "", 0, false, "Cooperative",
+				).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"company_id", "company_name"}).
+						AddRow("0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", "alpha"),
+				)
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WithArgs(
+					testTenantID, "0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", 1, "created",
+					"alpha", "", 0, false, "Non Profit",
+					sqlmock.AnyArg(), testUUID,
+				).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WithArgs(sqlmock.AnyArg(), "0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", "company.created", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(`RELEASE SAVEPOINT batch_change`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectExec(`SAVEPOINT batch_change`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectQuery(lockCompanyQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,3"))
+
+			mockDB.ExpectExec(`ROLLBACK TO SAVEPOINT batch_change`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectCommit()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company:batch", strings.NewReader(`
+				{
+					"mode": "bestEffort",
+					"operations": [
+						{"op": "create", "data": {"name": "alpha", "companyType": "Non Profit"}},
+						{"op": "create", "data": {"name": "example", "companyType": "Cooperative"}},
+						{"op": "update", "id": "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "version": 2, "data": {"amountOfEmployees": 2}}
+					]
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"mode":"bestEffort","results":[`+
+					`{"index":0,"status":200,"id":"0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10","version":1},`+
+					`{"index":1,"status":409,"error":"duplicate record"},`+
+					`{"index":2,"status":412,"error":"precondition failed"}`+
+					`]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Delete missing company", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectExec(`SAVEPOINT batch_change`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectQuery(lockCompanyQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113c", testTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			mockDB.ExpectExec(`ROLLBACK TO SAVEPOINT batch_change`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			mockDB.ExpectCommit()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company:batch", strings.NewReader(`
+				{
+					"mode": "bestEffort",
+					"operations": [
+						{"op": "delete", "id": "ae17b2e2-6b87-4c5b-9c94-3623dacf113c"}
+					]
+				}
+			`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"mode":"bestEffort","results":[`+
+					`{"index":0,"status":404,"error":"no record found"}`+
+					`]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Export Companies", func(t *testing.T) {
+
+		t.Run("Invalid Parameter", func(t *testing.T) {
+			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/export?format=xlsx", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company/export","errors":[{"field":"format","rule":"oneof","allowed":["csv","ndjson"],"message":"format must be one of: csv, ndjson"}]}`, string(body))
+			}
+
+			assert.Equal(422, resp.StatusCode)
+		})
+
+		t.Run("csv", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(exportCompaniesQuery).
+				WithArgs(testTenantID).
+				WillReturnRows(companyRows(
+					"ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,3",
+					"0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10,@alpha,=1+2,12,true,Cooperative,1",
+				))
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/export?format=csv", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal("id,name,description,amountOfEmployees,registered,companyType\n"+
+					"ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit\n"+
+					"0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10,'@alpha,'=1+2,12,true,Cooperative\n", string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+			assert.Equal("text/csv", resp.Header.Get("Content-Type"))
+			assert.Equal(`attachment; filename="companies.csv"`, resp.Header.Get("Content-Disposition"))
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("ndjson", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(exportCompaniesQuery).
+				WithArgs(testTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,3"))
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/export?format=ndjson", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example","description":"company description","amountOfEmployees":3,"registered":false,"companyType":"Non Profit"}`+"\n", string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+			assert.Equal("application/x-ndjson", resp.Header.Get("Content-Type"))
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Query failure", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(exportCompaniesQuery).
+				WithArgs(testTenantID).
+				WillReturnError(ErrDBConnection)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/export?format=csv", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"request could not be processed"}`, string(body))
+			}
+
+			assert.Equal(500, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Import Companies", func(t *testing.T) {
+
+		t.Run("Unknown column", func(t *testing.T) {
+			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company/import", strings.NewReader("name,country\nalpha,GH\n"))
+			r.Header.Set("Content-Type", "text/csv")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company/import","errors":[{"field":"file","rule":"columns","allowed":["id","name","description","amountOfEmployees","registered","companyType"],"message":"unknown column \"country\""}]}`, string(body))
+			}
+
+			assert.Equal(422, resp.StatusCode)
+		})
+
+		t.Run("Dry run", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(`
+				INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type)
+				VALUES ($1, $2, $3, $4, $5, $6), ($7, $8, $9, $10, $11, $12)
+				ON CONFLICT (tenant_id, company_name) WHERE deleted_at IS NULL DO NOTHING
+				RETURNING company_id, company_name
+			`).
+				WithArgs(
+					testTenantID, "alpha", "=first", 12, true, "Cooperative",
+					testTenantID, "example", "", 0, false, "Non Profit",
+				).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"company_id", "company_name"}).
+						AddRow("0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", "alpha"),
+				)
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WithArgs(
+					testTenantID, "0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", 1, "created",
+					"alpha", "=first", 12, true, "Cooperative",
+					sqlmock.AnyArg(), testUUID,
+				).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WithArgs(sqlmock.AnyArg(), "0d1f9a3e-4c7b-4f4e-8e55-6a2b8f1c9d10", "company.created", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectRollback()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			var buf bytes.Buffer
+			form := multipart.NewWriter(&buf)
+			part, err := form.CreateFormFile("file", "companies.csv")
+			require.NoError(err)
+			_, err = io.WriteString(part, "Name,Description,Amount of employees,Registered,Company Type\n"+
+				"alpha,'=first,12,true,Cooperative\n"+
+				",second,3,false,Non Profit\n"+
+				"beta,third,many,false,Non Profit\n"+
+				"example,,,,Non Profit\n")
+			require.NoError(err)
+			require.NoError(form.Close())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company/import?dryRun=true", &buf)
+			r.Header.Set("Content-Type", form.FormDataContentType())
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"dryRun":true,"total":4,"imported":1,"rejected":3,"errors":[`+
+					`{"row":3,"error":"invalid parameters","errors":[{"field":"name","rule":"required","message":"name is required"}]},`+
+					`{"row":4,"error":"invalid parameters","errors":[{"field":"amountOfEmployees","rule":"type","param":"int64","message":"amountOfEmployees must be an integer"}]},`+
+					`{"row":5,"error":"invalid parameters","errors":[{"field":"name","rule":"unique","message":"name is already used by another company"}]}`+
+					`]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Error report", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company/import?format=ndjson&report=csv", strings.NewReader(
+				`{"name":"alpha","amountOfEmployees":"12","companyType":"Cooperative"}`+"\n"+
+					"\n"+
+					`{"name":"beta","companyType":"Partnership"}`+"\n",
+			))
+			r.Header.Set("Content-Type", "application/x-ndjson")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal("row,field,rule,message\n"+
+					"1,amountOfEmployees,type,amountOfEmployees must be an integer\n"+
+					"3,companyType,eq,\"companyType must be one of: Corporations, Non Profit, Cooperative, Sole Proprietorship\"\n", string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+			assert.Equal(`attachment; filename="import-errors.csv"`, resp.Header.Get("Content-Disposition"))
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Get Company", func(t *testing.T) {
+
+		t.Run("No record", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getCompanyByIDQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", cfg.DefaultTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Set("Content-Type", "application/json")
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			_, err := io.ReadAll(resp.Body)
+
+			require.NoError(err)
+
+			assert.Equal(404, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getCompanyByIDQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", cfg.DefaultTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,2,false,Non Profit,2"))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Set("Content-Type", "application/json")
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit"}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+			assert.Equal(`"2"`, resp.Header.Get("ETag"))
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Other tenant", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getCompanyByIDQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "globex").
+				WillReturnError(sql.ErrNoRows)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, helpers.Identity{
+				UserID:   testUUID,
+				TenantID: "globex",
+				Scopes:   []string{helpers.ScopeCompanyRead},
+			})
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"no record found"}`, string(body))
+			}
+
+			assert.Equal(404, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Missing scope", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, helpers.Identity{
+				UserID:   testUUID,
+				TenantID: testTenantID,
+				Scopes:   []string{helpers.ScopeCompanyWrite},
+			})
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"insufficient scope"}`, string(body))
+			}
+
+			assert.Equal(403, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Not modified", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(getCompanyByIDQuery).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", cfg.DefaultTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,2,false,Non Profit,2"))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
+			r.Header.Set("If-None-Match", `"2"`)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Empty(body)
+			}
+
+			assert.Equal(304, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("List Companies", func(t *testing.T) {
+
+		t.Run("Invalid Parameter", func(t *testing.T) {
+			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company?sort=deleted_at", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company","errors":[{"field":"sort","rule":"oneof","allowed":["name","-name","description","-description","amountOfEmployees","-amountOfEmployees","registered","-registered","companyType","-companyType","createdAt","-createdAt","modifiedAt","-modifiedAt"],"message":"sort must be one of: name, -name, description, -description, amountOfEmployees, -amountOfEmployees, registered, -registered, companyType, -companyType, createdAt, -createdAt, modifiedAt, -modifiedAt"}]}`, string(body))
+			}
+
+			assert.Equal(422, resp.StatusCode)
+		})
+
+		t.Run("Invalid Cursor", func(t *testing.T) {
+			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company?cursor=not-a-cursor", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company","errors":[{"field":"cursor","rule":"cursor","message":"cursor is not a page token of this listing"}]}`, string(body))
+			}
+
+			assert.Equal(422, resp.StatusCode)
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type, (coalesce(amount_of_employees, 0))::text
+				FROM companies
+				WHERE tenant_id = $1
+					AND deleted_at IS NULL
+					AND company_type = $2
+					AND amount_of_employees >= $3
+					AND company_name ILIKE $4 ESCAPE '\'
+				ORDER BY coalesce(amount_of_employees, 0) DESC, company_id DESC
+				LIMIT $5
+			`).
+				WithArgs(cfg.DefaultTenantID, "Non Profit", 2, `ex\_%`, 2).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type", "sort_key",
+						},
+					).
+						FromCSVString("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,ex_1,company description,5,false,Non Profit,5\n" +
+							"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3,ex_2,company description,3,false,Non Profit,3"),
+				)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company?companyType=Non%20Profit&minEmployees=2&namePrefix=ex_&sort=-amountOfEmployees&limit=1", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(err)
+
+			var list models.CompanyList
+			require.NoError(json.Unmarshal(body, &list))
+
+			assert.Equal(200, resp.StatusCode)
+			if assert.Len(list.Data, 1) {
+				assert.Equal("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", list.Data[0].ID)
+			}
+			assert.NotEmpty(list.NextPageToken)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type, (coalesce(amount_of_employees, 0))::text
+				FROM companies
+				WHERE tenant_id = $1
+					AND deleted_at IS NULL
+					AND (coalesce(amount_of_employees, 0), company_id) < ($2::int, $3::uuid)
+				ORDER BY coalesce(amount_of_employees, 0) DESC, company_id DESC
+				LIMIT $4
+			`).
+				WithArgs(cfg.DefaultTenantID, "5", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", 2).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type", "sort_key",
+						},
+					).
+						FromCSVString("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3,ex_2,company description,3,false,Non Profit,3"),
+				)
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest("GET", "http:/api/v1/company?sort=-amountOfEmployees&limit=1&cursor="+list.NextPageToken, nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp = w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"data":[{"id":"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3","name":"ex_2","description":"company description","amountOfEmployees":3,"registered":false,"companyType":"Non Profit"}]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("Modified range includes companies never modified", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type, (coalesce(modified_at, created_at))::text
+				FROM companies
+				WHERE tenant_id = $1
+					AND deleted_at IS NULL
+					AND coalesce(modified_at, created_at) >= $2
+					AND coalesce(modified_at, created_at) < $3
+				ORDER BY coalesce(modified_at, created_at) ASC, company_id ASC
+				LIMIT $4
+			`).
+				WithArgs(
+					cfg.DefaultTenantID,
+					time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+					time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC),
+					21,
+				).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type", "sort_key",
+						},
+					).
+						FromCSVString("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3,ex_2,company description,3,false,Non Profit,2023-03-02 10:00:00"),
+				)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company?modifiedFrom=2023-03-01T00:00:00Z&modifiedTo=2023-04-01T00:00:00Z&sort=modifiedAt", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"data":[{"id":"2899bacc-7107-4cd4-9364-6a6fc4fc2fd3","name":"ex_2","description":"company description","amountOfEmployees":3,"registered":false,"companyType":"Non Profit"}]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Search Companies", func(t *testing.T) {
+
+		t.Run("Invalid Parameter", func(t *testing.T) {
+			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/search", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company/search","errors":[{"field":"q","rule":"required","message":"q is required"}]}`, string(body))
+			}
+
+			assert.Equal(422, resp.StatusCode)
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type,
+					ts_rank(search_vector, q) + similarity(company_name, $1) AS score,
+					similarity(company_name, $1) AS similarity,
+					ts_headline('simple', company_name || ' ' || coalesce(description, ''), q, 'StartSel=' || $5 || ', StopSel=' || $6 || ', MaxFragments=2')
+				FROM companies, to_tsquery('simple', $2) q
+				WHERE tenant_id = $4
+					AND deleted_at IS NULL
+					AND (search_vector @@ q OR company_name % $1)
+				ORDER BY score DESC, company_id
+				LIMIT $3
+			`).
+				WithArgs("acme corp", "acme:* & corp:*", 20, cfg.DefaultTenantID, "\uE000", "\uE001").
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type",
+							"score", "similarity", "ts_headline",
+						},
+					).
+						AddRow(
+							"ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "ACME Corporation",
+							"<b>anvils</b>", 2, false, "Corporations",
+							0.85, 0.5, "\uE000ACME\uE001 \uE000Corporation\uE001 <b>anvils</b>",
+						),
+				)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/search?q=acme%20corp", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"data":[{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"ACME Corporation","description":"\u003cb\u003eanvils\u003c/b\u003e","amountOfEmployees":2,"registered":false,"companyType":"Corporations","score":0.85,"similarity":0.5,"snippet":"\u003cmark\u003eACME\u003c/mark\u003e \u003cmark\u003eCorporation\u003c/mark\u003e \u0026lt;b\u0026gt;anvils\u0026lt;/b\u0026gt;"}]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Restore Company", func(t *testing.T) {
+
+		t.Run("Name reused", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type, deleted_at, version
+				FROM companies
+				WHERE company_id = $1
+					AND tenant_id = $2
+			`).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type", "deleted_at", "version",
+						},
+					).
+						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "example", "company description", 2, false, "Non Profit", time.Now(), 1),
+				)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type
+				FROM companies
+				WHERE company_name = $1
+					AND tenant_id = $2
+					AND deleted_at IS NULL
+			`).
+				WithArgs("example", testTenantID).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type",
+						},
+					).
+						FromCSVString("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3,example,company description,2,false,Non Profit"),
+				)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b/restore", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"duplicate record"}`, string(body))
+			}
+
+			assert.Equal(409, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type, deleted_at, version
+				FROM companies
+				WHERE company_id = $1
+					AND tenant_id = $2
+			`).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type", "deleted_at", "version",
+						},
+					).
+						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "example", "company description", 2, false, "Non Profit", time.Now(), 1),
+				)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type
+				FROM companies
+				WHERE company_name = $1
+					AND tenant_id = $2
+					AND deleted_at IS NULL
+			`).
+				WithArgs("example", testTenantID).
+				WillReturnError(sql.ErrNoRows)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+				FROM companies
+				WHERE company_id = $1
+					AND tenant_id = $2
+					AND deleted_at IS NOT NULL
+				FOR UPDATE
+			`).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(companyRows("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,2,false,Non Profit,1"))
+
+			mockDB.ExpectExec(`
+				UPDATE companies 
+				SET 
+					deleted_at = NULL,
+					modified_at = now(),
+					version = version + 1
+				WHERE
+					company_id = $1
+					AND tenant_id = $2
+					AND deleted_at IS NOT NULL
+			`).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertHistoryQuery).
+				WithArgs(
+					testTenantID, "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", 2, "restored",
+					"example", "company description", 2, false, "Non Profit",
+					[]byte(`{}`), testUUID,
+				).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WithArgs(sqlmock.AnyArg(), "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "company.restored", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectCommit()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b/restore", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit"}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Purge Company", func(t *testing.T) {
+
+		t.Run("Forbidden", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b/purge", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"insufficient scope"}`, string(body))
+			}
+
+			assert.Equal(403, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectBegin()
+
+			mockDB.ExpectExec(`
+				DELETE FROM companies
+				WHERE
+					company_id = $1
+					AND tenant_id = $2
+			`).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectExec(`
+				DELETE FROM companies_history
+				WHERE
+					company_id = $1
+			`).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b").
+				WillReturnResult(sqlmock.NewResult(0, 3))
+
+			mockDB.ExpectExec(insertOutboxEventQuery).
+				WithArgs(sqlmock.AnyArg(), "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "company.purged", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mockDB.ExpectCommit()
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b/purge", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			_, err = io.ReadAll(resp.Body)
+			assert.NoError(err)
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Get Deleted Company", func(t *testing.T) {
+
+		t.Run("Forbidden", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b?includeDeleted=true", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"error":"insufficient scope"}`, string(body))
+			}
+
+			assert.Equal(403, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			deletedAt := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					company_id, company_name, description, amount_of_employees, is_registered, company_type, deleted_at, version
+				FROM companies
+				WHERE company_id = $1
+					AND tenant_id = $2
+			`).
+				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", testTenantID).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type", "deleted_at", "version",
+						},
+					).
+						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "example", "company description", 2, false, "Non Profit", deletedAt, 2),
+				)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b?includeDeleted=true", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit","deletedAt":"2023-01-02T15:04:05Z"}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("Company History", func(t *testing.T) {
+
+		t.Run("Anonymous", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b/history", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			assert.Equal(401, w.Result().StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("No record", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					history_id, version, operation, company_id, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by, changed_at
+				FROM companies_history
+				WHERE tenant_id = $1
+					AND company_id = $2
+				ORDER BY history_id
+			`).
+				WithArgs(testTenantID, "ae17b2e2-6b87-4c5b-9c94-3623dacf113b").
+				WillReturnRows(sqlmock.NewRows([]string{"history_id"}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b/history", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			_, err = io.ReadAll(resp.Body)
+			assert.NoError(err)
+
+			assert.Equal(404, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("success", func(t *testing.T) {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+			require.NoError(err)
+
+			changedAt := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					history_id, version, operation, company_id, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by, changed_at
+				FROM companies_history
+				WHERE tenant_id = $1
+					AND company_id = $2
+				ORDER BY history_id
+			`).
+				WithArgs(testTenantID, "ae17b2e2-6b87-4c5b-9c94-3623dacf113b").
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"history_id", "version", "operation", "company_id", "company_name",
+							"description", "amount_of_employees", "is_registered", "company_type",
+							"diff", "changed_by", "changed_at",
+						},
+					).
+						AddRow(7, 4, "updated", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "example", "company description", 2, false, "Non Profit",
+							[]byte(`{"amountOfEmployees":{"from":3,"to":2}}`), testUUID, changedAt),
+				)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b/history", nil)
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"data":[{"historyId":7,"version":4,"operation":"updated","company":{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit"},"diff":{"amountOfEmployees":{"from":3,"to":2}},"changedBy":"af7c1fe6-d669-414e-b066-e9733f0de7a8","changedAt":"2023-01-02T15:04:05Z"}]}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+
+		t.Run("as of", func(t *testing.T) {
+			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			mockDB.ExpectQuery(`
+				SELECT
+					operation, company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+				FROM companies_history
+				WHERE tenant_id = $1
+					AND company_id = $2
+					AND changed_at <= $3
+				ORDER BY history_id DESC
+				LIMIT 1
+			`).
+				WithArgs(cfg.DefaultTenantID, "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", time.Date(2023, 1, 2, 13, 0, 0, 0, time.UTC)).
+				WillReturnRows(
+					sqlmock.NewRows(
+						[]string{
+							"operation", "company_id", "company_name",
+							"description", "amount_of_employees",
+							"is_registered", "company_type", "version",
+						},
+					).
+						FromCSVString("created,ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit,1"),
+				)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b?asOf=2023-01-02T15:00:00%2B02:00", nil)
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+				assert.Equal(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example","description":"company description","amountOfEmployees":3,"registered":false,"companyType":"Non Profit"}`, string(body))
+			}
+
+			assert.Equal(200, resp.StatusCode)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		})
+	})
+
+}
@@ -1,19 +1,62 @@
 package config
 
 import (
+	"crypto"
+	"errors"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Configurations struct {
-	DBName              string
-	DBUser              string
-	DBPass              string
-	DBHost              string
-	AccessTokenDuration time.Duration
-	JWTSecretKey        string
-	HTTPPort            string
-	KafkaURL            string
+	DBName               string
+	DBUser               string
+	DBPass               string
+	DBHost               string
+	AccessTokenDuration  time.Duration
+	JWTSecretKey         string
+	HTTPPort             string
+	HTTPReadTimeout      time.Duration
+	HTTPHeaderTimeout    time.Duration
+	HTTPWriteTimeout     time.Duration
+	HTTPIdleTimeout      time.Duration
+	ShutdownTimeout      time.Duration
+	KafkaURL             string
+	KafkaCompanyTopic    string
+	KafkaIngestTopic     string
+	KafkaIngestGroup     string
+	KafkaDeadLetterTopic string
+	OutboxPollInterval   time.Duration
+	OutboxBatchSize      int
+	AdminUserIDs         []string
+	BootstrapAdminEmail  string
+	BootstrapAdminPass   string
+	RetentionDays        int
+	RetentionInterval    time.Duration
+	IdempotencyKeyTTL    time.Duration
+	RefreshTokenDuration time.Duration
+	DevMode              bool
+	RevocationCacheTTL   time.Duration
+	JWTSigningMethod     string
+	JWTSigningKey        crypto.Signer
+	JWTKeyID             string
+	JWTVerificationKeys  map[string]crypto.PublicKey
+	JWTLegacyHS256Until  time.Time
+	DefaultTenantID      string
+	JobWorkers           int
+	JobPollInterval      time.Duration
+	JobLease             time.Duration
+	JobRetention         time.Duration
+	GRPCPort             string
+	WatchPollInterval    time.Duration
+	WebhookPollInterval  time.Duration
+	WebhookTimeout       time.Duration
+	WebhookMaxAttempts   int
+	WebhookBackoff       time.Duration
+	WebhookMaxBackoff    time.Duration
+	WebhookDisableAfter  int
+	WebhookRetention     time.Duration
 }
 
 func NewConfigurations() (configs Configurations, err error) {
@@ -24,16 +67,242 @@ func NewConfigurations() (configs Configurations, err error) {
 		return configs, err
 	}
 
+	hrt, err := durationEnv("HTTP_READ_TIMEOUT", time.Minute)
+	if err != nil {
+		return configs, err
+	}
+
+	hht, err := durationEnv("HTTP_HEADER_TIMEOUT", 5*time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	// zero, the change feed and the exports stream for longer than any write timeout
+	hwt, err := durationEnv("HTTP_WRITE_TIMEOUT", 0)
+	if err != nil {
+		return configs, err
+	}
+
+	hit, err := durationEnv("HTTP_IDLE_TIMEOUT", 2*time.Minute)
+	if err != nil {
+		return configs, err
+	}
+
+	st, err := durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	opi, err := durationEnv("OUTBOX_POLL_INTERVAL", time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	obs, err := intEnv("OUTBOX_BATCH_SIZE", 100)
+	if err != nil {
+		return configs, err
+	}
+
+	rd, err := intEnv("DELETED_RETENTION_DAYS", 0)
+	if err != nil {
+		return configs, err
+	}
+
+	ri, err := durationEnv("RETENTION_INTERVAL", time.Hour)
+	if err != nil {
+		return configs, err
+	}
+
+	ikt, err := durationEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+	if err != nil {
+		return configs, err
+	}
+
+	rtd, err := durationEnv("REFRESH_TOKEN_DURATION", 30*24*time.Hour)
+	if err != nil {
+		return configs, err
+	}
+
+	dev, err := boolEnv("DEV_MODE", false)
+	if err != nil {
+		return configs, err
+	}
+
+	rct, err := durationEnv("REVOCATION_CACHE_TTL", 30*time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	jw, err := intEnv("JOB_WORKERS", 2)
+	if err != nil {
+		return configs, err
+	}
+
+	jpi, err := durationEnv("JOB_POLL_INTERVAL", time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	jl, err := durationEnv("JOB_LEASE", 30*time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	jr, err := durationEnv("JOB_RETENTION", 7*24*time.Hour)
+	if err != nil {
+		return configs, err
+	}
+
+	wpi, err := durationEnv("WATCH_POLL_INTERVAL", time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	whpi, err := durationEnv("WEBHOOK_POLL_INTERVAL", time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	wht, err := durationEnv("WEBHOOK_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	whma, err := intEnv("WEBHOOK_MAX_ATTEMPTS", 8)
+	if err != nil {
+		return configs, err
+	}
+
+	whb, err := durationEnv("WEBHOOK_BACKOFF", 30*time.Second)
+	if err != nil {
+		return configs, err
+	}
+
+	whmb, err := durationEnv("WEBHOOK_MAX_BACKOFF", time.Hour)
+	if err != nil {
+		return configs, err
+	}
+
+	whda, err := intEnv("WEBHOOK_DISABLE_AFTER", 20)
+	if err != nil {
+		return configs, err
+	}
+
+	whr, err := durationEnv("WEBHOOK_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		return configs, err
+	}
+
 	configs = Configurations{
-		DBName:              os.Getenv("POSTGRES_DB_NAME"),
-		DBUser:              os.Getenv("POSTGRES_DB_USER"),
-		DBPass:              os.Getenv("POSTGRES_DB_PASSWORD"),
-		DBHost:              os.Getenv("POSTGRES_DB_HOST"),
-		AccessTokenDuration: atd,
-		JWTSecretKey:        os.Getenv("JWT_SECRET_KEY"),
-		HTTPPort:            os.Getenv("HTTP_PORT"),
-		KafkaURL:            os.Getenv("KAFKA_URL"),
+		DBName:               os.Getenv("POSTGRES_DB_NAME"),
+		DBUser:               os.Getenv("POSTGRES_DB_USER"),
+		DBPass:               os.Getenv("POSTGRES_DB_PASSWORD"),
+		DBHost:               os.Getenv("POSTGRES_DB_HOST"),
+		AccessTokenDuration:  atd,
+		JWTSecretKey:         os.Getenv("JWT_SECRET_KEY"),
+		HTTPPort:             os.Getenv("HTTP_PORT"),
+		HTTPReadTimeout:      hrt,
+		HTTPHeaderTimeout:    hht,
+		HTTPWriteTimeout:     hwt,
+		HTTPIdleTimeout:      hit,
+		ShutdownTimeout:      st,
+		KafkaURL:             os.Getenv("KAFKA_URL"),
+		KafkaCompanyTopic:    os.Getenv("KAFKA_COMPANY_TOPIC"),
+		KafkaIngestTopic:     os.Getenv("KAFKA_INGEST_TOPIC"),
+		KafkaIngestGroup:     os.Getenv("KAFKA_INGEST_GROUP"),
+		KafkaDeadLetterTopic: os.Getenv("KAFKA_DEAD_LETTER_TOPIC"),
+		OutboxPollInterval:   opi,
+		OutboxBatchSize:      obs,
+		AdminUserIDs:         listEnv("ADMIN_USER_IDS"),
+		BootstrapAdminEmail:  os.Getenv("BOOTSTRAP_ADMIN_EMAIL"),
+		BootstrapAdminPass:   os.Getenv("BOOTSTRAP_ADMIN_PASSWORD"),
+		RetentionDays:        rd,
+		RetentionInterval:    ri,
+		IdempotencyKeyTTL:    ikt,
+		RefreshTokenDuration: rtd,
+		DevMode:              dev,
+		RevocationCacheTTL:   rct,
+		JWTSigningMethod:     os.Getenv("JWT_SIGNING_METHOD"),
+		DefaultTenantID:      os.Getenv("DEFAULT_TENANT_ID"),
+		JobWorkers:           jw,
+		JobPollInterval:      jpi,
+		JobLease:             jl,
+		JobRetention:         jr,
+		GRPCPort:             os.Getenv("GRPC_PORT"),
+		WatchPollInterval:    wpi,
+		WebhookPollInterval:  whpi,
+		WebhookTimeout:       wht,
+		WebhookMaxAttempts:   whma,
+		WebhookBackoff:       whb,
+		WebhookMaxBackoff:    whmb,
+		WebhookDisableAfter:  whda,
+		WebhookRetention:     whr,
+	}
+
+	// companies created before multi-tenancy belong to the "default" tenant
+	if configs.DefaultTenantID == "" {
+		configs.DefaultTenantID = "default"
+	}
+
+	if configs.GRPCPort == "" {
+		configs.GRPCPort = "9090"
+	}
+
+	if configs.KafkaIngestGroup == "" {
+		configs.KafkaIngestGroup = "go-xm-ingest"
+	}
+
+	// messages the ingest consumer cannot apply are parked next to its topic
+	if configs.KafkaDeadLetterTopic == "" && configs.KafkaIngestTopic != "" {
+		configs.KafkaDeadLetterTopic = configs.KafkaIngestTopic + ".dlq"
+	}
+
+	// the same rule as the passwords of users created through the API
+	if configs.BootstrapAdminEmail != "" && (len(configs.BootstrapAdminPass) < 12 || len(configs.BootstrapAdminPass) > 72) {
+		return configs, errors.New("BOOTSTRAP_ADMIN_PASSWORD must have 12 to 72 characters")
 	}
 
+	if configs.JWTSigningMethod == "" {
+		configs.JWTSigningMethod = SigningMethodHS256
+	}
+	err = loadJWTKeys(&configs)
+
+	return
+}
+
+// durationEnv parses the environment variable key as a duration, falling back when it is unset
+func durationEnv(key string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// intEnv parses the environment variable key as an int, falling back when it is unset
+func intEnv(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// boolEnv parses the environment variable key as a bool, falling back when it is unset
+func boolEnv(key string, fallback bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// listEnv splits the comma separated environment variable key
+func listEnv(key string) (list []string) {
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
 	return
 }
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	SigningMethodHS256 = "HS256"
+	SigningMethodRS256 = "RS256"
+	SigningMethodES256 = "ES256"
+)
+
+// loadJWTKeys reads the signing key and the verification keys of asymmetric signing methods.
+// The signing key is read from JWT_SIGNING_KEY_FILE and its public key is always accepted.
+// JWT_VERIFICATION_KEY_FILES lists kid=path pairs of keys that are still accepted, such as the
+// previous signing key while tokens it signed have not expired. JWT_LEGACY_HS256_UNTIL keeps
+// accepting HS256 tokens signed with JWT_SECRET_KEY until an RFC 3339 time
+func loadJWTKeys(configs *Configurations) (err error) {
+	switch configs.JWTSigningMethod {
+	case SigningMethodHS256:
+		if configs.JWTSecretKey == "" {
+			return errors.New("JWT_SECRET_KEY is required with HS256")
+		}
+		return
+	case SigningMethodRS256, SigningMethodES256:
+	default:
+		return fmt.Errorf("unsupported JWT_SIGNING_METHOD %q", configs.JWTSigningMethod)
+	}
+
+	key, err := readPrivateKey(os.Getenv("JWT_SIGNING_KEY_FILE"))
+	if err != nil {
+		return
+	}
+	if err = checkKeyMethod(key.Public(), configs.JWTSigningMethod); err != nil {
+		return
+	}
+
+	configs.JWTSigningKey = key
+	configs.JWTKeyID = os.Getenv("JWT_SIGNING_KEY_ID")
+	if configs.JWTKeyID == "" {
+		if configs.JWTKeyID, err = KeyID(key.Public()); err != nil {
+			return
+		}
+	}
+
+	configs.JWTVerificationKeys = map[string]crypto.PublicKey{
+		configs.JWTKeyID: key.Public(),
+	}
+	for _, pair := range listEnv("JWT_VERIFICATION_KEY_FILES") {
+		kid, path, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("JWT_VERIFICATION_KEY_FILES entry %q is not kid=path", pair)
+		}
+
+		pub, err := readPublicKey(path)
+		if err != nil {
+			return err
+		}
+		configs.JWTVerificationKeys[kid] = pub
+	}
+
+	// HS256 tokens signed before switching methods are only accepted until an explicit deadline
+	if until := os.Getenv("JWT_LEGACY_HS256_UNTIL"); until != "" {
+		if configs.JWTLegacyHS256Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return fmt.Errorf("JWT_LEGACY_HS256_UNTIL: %w", err)
+		}
+		if configs.JWTSecretKey == "" {
+			return errors.New("JWT_SECRET_KEY is required with JWT_LEGACY_HS256_UNTIL")
+		}
+	}
+
+	return
+}
+
+// KeyID derives a key ID from the SHA-256 of the DER encoding of pub
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+func checkKeyMethod(pub crypto.PublicKey, method string) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if method == SigningMethodRS256 {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if method == SigningMethodES256 && k.Curve == elliptic.P256() {
+			return nil
+		}
+	}
+	return fmt.Errorf("signing key does not match JWT_SIGNING_METHOD %s", method)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+// readPrivateKey reads a PKCS #8, PKCS #1 or SEC 1 PEM encoded private key
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported private key", path)
+	}
+	return signer, nil
+}
+
+// readPublicKey reads a PEM encoded public key, or the public key of a private key
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type != "PUBLIC KEY" {
+		key, err := readPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public(), nil
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pub, nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+
+	t.Run("HS256 requires a secret", func(t *testing.T) {
+		assert.Error(t, loadJWTKeys(&Configurations{JWTSigningMethod: SigningMethodHS256}))
+	})
+
+	t.Run("ES256 with rotation", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		current, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+		der, err := x509.MarshalECPrivateKey(current)
+		require.NoError(err)
+
+		previous, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+		pubDER, err := x509.MarshalPKIXPublicKey(&previous.PublicKey)
+		require.NoError(err)
+
+		t.Setenv("JWT_SIGNING_KEY_FILE", writePEM(t, "current.pem", "EC PRIVATE KEY", der))
+		t.Setenv("JWT_SIGNING_KEY_ID", "2023-02")
+		t.Setenv("JWT_VERIFICATION_KEY_FILES", "2023-01="+writePEM(t, "previous.pem", "PUBLIC KEY", pubDER))
+
+		configs := Configurations{JWTSigningMethod: SigningMethodES256}
+		require.NoError(loadJWTKeys(&configs))
+
+		assert.Equal("2023-02", configs.JWTKeyID)
+		assert.True(current.Equal(configs.JWTSigningKey))
+		assert.Len(configs.JWTVerificationKeys, 2)
+		assert.True(previous.PublicKey.Equal(configs.JWTVerificationKeys["2023-01"]))
+	})
+
+	t.Run("Key does not match the method", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		t.Setenv("JWT_SIGNING_KEY_FILE", writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)))
+
+		assert.Error(t, loadJWTKeys(&Configurations{JWTSigningMethod: SigningMethodES256}))
+	})
+}
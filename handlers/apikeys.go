@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateAPIKey issues an API key to the authenticated user within their tenant. A key can only
+// be granted scopes the token creating it has, the key itself is only returned in this response
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.APIKeyReq)
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		abortWithValidationError(c, helpers.NewValidationError(helpers.FieldError{
+			Field:   "expiresAt",
+			Rule:    "future",
+			Message: "expiresAt must be in the future",
+		}))
+		return
+	}
+
+	claims := c.MustGet(consts.TOKEN_CLAIMS).(*helpers.JWTClaims)
+	for _, scope := range request.Scopes {
+		if !claims.HasScope(scope) {
+			err := helpers.ErrInsufficientScope
+			c.AbortWithStatusJSON(
+				helpers.GetHttpStatusByErr(err),
+				gin.H{"error": err.Error()},
+			)
+			return
+		}
+	}
+
+	key, prefix, hash, err := helpers.GenerateAPIKey()
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	created, err := h.r.CreateAPIKey(
+		c.Request.Context(),
+		prefix,
+		hash,
+		request.Name,
+		c.GetString(consts.USER_ID),
+		c.GetString(consts.TENANT_ID),
+		request.Scopes,
+		request.ExpiresAt,
+	)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.NewAPIKeyResp{APIKey: created, Key: key})
+}
+
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+
+	keys, err := h.r.ListAPIKeys(c.Request.Context(), c.GetString(consts.USER_ID))
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// RevokeAPIKey revokes an API key of the authenticated user, requests made with it are
+// rejected from then on
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+
+	err := h.r.RevokeAPIKey(c.Request.Context(), c.GetString(consts.USER_ID), c.Param("key-id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
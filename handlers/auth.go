@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/gin-gonic/gin"
+)
+
+// identity returns the tenant and the roles of a user and the scopes they grant. The users of
+// ADMIN_USER_IDS are administrators whatever their roles
+func (h *Handler) identity(ctx context.Context, user models.User) (identity helpers.Identity, err error) {
+	roles, scopes, err := h.r.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		return
+	}
+
+	if helpers.SliceContains(h.cfg.AdminUserIDs, user.ID) {
+		if !helpers.SliceContains(roles, helpers.RoleAdmin) {
+			roles = append(roles, helpers.RoleAdmin)
+		}
+		for _, scope := range helpers.Scopes {
+			if !helpers.SliceContains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	identity = helpers.Identity{UserID: user.ID, TenantID: user.TenantID, Roles: roles, Scopes: scopes}
+	return
+}
+
+// issueTokens returns a new access token and a refresh token. The access token grants the
+// current roles of the user, the refresh token is recorded by record, which starts or
+// continues its token family
+func (h *Handler) issueTokens(
+	ctx context.Context,
+	user models.User,
+	record func(tokenID string, expiresAt time.Time) error,
+) (resp models.TokenResp, err error) {
+
+	identity, err := h.identity(ctx, user)
+	if err != nil {
+		return
+	}
+
+	tokenID := helpers.NewUUID()
+	if err = record(tokenID, time.Now().Add(h.cfg.RefreshTokenDuration)); err != nil {
+		return
+	}
+
+	resp.AccessToken, err = helpers.GenerateAccessToken(h.cfg, identity)
+	if err != nil {
+		return
+	}
+
+	resp.RefreshToken, err = helpers.GenerateRefreshToken(h.cfg, user.ID, tokenID)
+	if err != nil {
+		return
+	}
+
+	resp.TokenType = "Bearer"
+	resp.ExpiresIn = int64(h.cfg.AccessTokenDuration / time.Second)
+	return
+}
+
+func (h *Handler) Login(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.LoginReq)
+
+	user, err := h.r.GetUserByEmail(c.Request.Context(), request.Email)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	if !helpers.CheckPassword(user.PasswordHash, request.Password) {
+		err = helpers.ErrInvalidCredentials
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	familyID := helpers.NewUUID()
+	resp, err := h.issueTokens(c.Request.Context(), user, func(tokenID string, expiresAt time.Time) error {
+		return h.r.CreateRefreshToken(c.Request.Context(), tokenID, familyID, user.ID, expiresAt)
+	})
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) RefreshToken(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.RefreshReq)
+
+	claims, err := helpers.ValidateRefreshToken(request.RefreshToken, h.cfg)
+	if err != nil {
+		err = helpers.ErrInvalidToken
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	// the user may have been deleted or moved to another tenant since the token was issued
+	user, err := h.r.GetUserByID(c.Request.Context(), claims.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrInvalidToken
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	resp, err := h.issueTokens(c.Request.Context(), user, func(tokenID string, expiresAt time.Time) error {
+		return h.rotateRefreshToken(c.Request.Context(), claims, tokenID, expiresAt)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows || err == repo.ErrRefreshTokenRevoked || err == repo.ErrRefreshTokenReused {
+			err = helpers.ErrInvalidToken
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// rotateRefreshToken exchanges the refresh token of claims for tokenID
+func (h *Handler) rotateRefreshToken(
+	ctx context.Context,
+	claims *helpers.JWTClaims,
+	tokenID string,
+	expiresAt time.Time,
+) (err error) {
+
+	_, err = h.r.RotateRefreshToken(ctx, claims.ID, tokenID, expiresAt)
+	if err == repo.ErrRefreshTokenReused {
+		log.Printf("auth: refresh token %s of user %s reused, token family revoked", claims.ID, claims.UserID)
+	}
+	return
+}
+
+func (h *Handler) Logout(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.LogoutReq)
+
+	claims := c.MustGet(consts.TOKEN_CLAIMS).(*helpers.JWTClaims)
+
+	if request.RefreshToken != "" {
+		refreshClaims, err := helpers.ValidateRefreshToken(request.RefreshToken, h.cfg)
+		if err != nil || refreshClaims.UserID != claims.UserID {
+			err = helpers.ErrInvalidToken
+			c.AbortWithStatusJSON(
+				helpers.GetHttpStatusByErr(err),
+				gin.H{"error": err.Error()},
+			)
+			return
+		}
+
+		if err = h.r.RevokeRefreshTokenFamily(c.Request.Context(), refreshClaims.ID); err != nil {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+			c.AbortWithStatusJSON(
+				helpers.GetHttpStatusByErr(err),
+				gin.H{"error": err.Error()},
+			)
+			return
+		}
+	}
+
+	if err := h.rs.RevokeToken(c.Request.Context(), claims); err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeUserTokens revokes every access and refresh token issued to a user of the tenant so far
+func (h *Handler) RevokeUserTokens(c *gin.Context) {
+
+	userIDParam := c.Param("user-id")
+
+	if !h.tenantUser(c, userIDParam) {
+		return
+	}
+
+	if err := h.rs.RevokeUserTokens(c.Request.Context(), userIDParam); err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	log.Printf("auth: tokens of user %s revoked by %s", userIDParam, c.GetString(consts.USER_ID))
+
+	c.Status(http.StatusNoContent)
+}
+
+// JWKS publishes the public keys that verify the tokens issued by this service
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, helpers.NewJWKS(h.cfg))
+}
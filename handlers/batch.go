@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/middleware"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/gin-gonic/gin"
+)
+
+// BatchCompanies creates, updates and deletes companies in one request and reports the outcome
+// of every operation. Nothing is applied when an operation of an atomic batch fails, the other
+// operations then report helpers.ErrBatchAborted
+func (h *Handler) BatchCompanies(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.CompanyBatchReq)
+
+	if request.Mode == "" {
+		request.Mode = models.BatchModeAtomic
+	}
+	atomic := request.Mode == models.BatchModeAtomic
+
+	results := make([]models.CompanyBatchResult, len(request.Operations))
+	changes := make([]models.CompanyChange, 0, len(request.Operations))
+	indexes := make([]int, 0, len(request.Operations))
+	rejected := false
+	for i, op := range request.Operations {
+		results[i].Index = i
+
+		change, err := h.batchChange(op)
+		if err == nil && op.Op == models.BatchOpDelete && !middleware.HasScope(c, helpers.ScopeCompanyDelete) {
+			err = helpers.ErrInsufficientScope
+		}
+		if err != nil {
+			setBatchResultError(&results[i], err)
+			rejected = true
+			continue
+		}
+
+		changes = append(changes, change)
+		indexes = append(indexes, i)
+	}
+
+	aborted := rejected && atomic
+	if !aborted && len(changes) > 0 {
+		applied, err := h.r.BatchCompanies(
+			c.Request.Context(),
+			c.GetString(consts.TENANT_ID),
+			c.GetString(consts.USER_ID),
+			changes,
+			atomic,
+		)
+		if err != nil && err != repo.ErrBatchAborted {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+			c.AbortWithStatusJSON(
+				helpers.GetHttpStatusByErr(err),
+				gin.H{"error": err.Error()},
+			)
+			return
+		}
+		aborted = err == repo.ErrBatchAborted
+
+		for j, result := range applied {
+			i := indexes[j]
+			if result.Err != nil {
+				setBatchResultError(&results[i], batchChangeError(result.Err))
+				continue
+			}
+			results[i].Status = http.StatusOK
+			results[i].ID = result.CompanyID
+			results[i].Version = result.Version
+		}
+	}
+
+	if aborted {
+		for i := range results {
+			if results[i].Status == 0 || results[i].Status == http.StatusOK {
+				results[i] = models.CompanyBatchResult{Index: i}
+				setBatchResultError(&results[i], helpers.ErrBatchAborted)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mode":    request.Mode,
+		"results": results,
+	})
+}
+
+// batchChange validates an operation of a batch and returns the change it describes. The
+// fields of its data are reported prefixed with "data."
+func (h *Handler) batchChange(op models.CompanyBatchOp) (change models.CompanyChange, err error) {
+
+	if err = h.v.ValidateForm(op); err != nil {
+		return
+	}
+
+	change = models.CompanyChange{
+		Op:              op.Op,
+		CompanyID:       op.ID,
+		ExpectedVersion: op.Version,
+	}
+
+	var data interface{}
+	switch op.Op {
+	case models.BatchOpCreate:
+		data = &change.Company
+	case models.BatchOpUpdate:
+		data = &change.Update
+	default:
+		return
+	}
+
+	if len(op.Data) == 0 || string(op.Data) == "null" {
+		err = helpers.NewValidationError(helpers.FieldError{
+			Field:   "data",
+			Rule:    "required",
+			Message: "data is required",
+		})
+		return
+	}
+
+	if err = json.Unmarshal(op.Data, data); err != nil {
+		err = helpers.BindingError(err)
+	} else {
+		err = h.v.ValidateForm(data)
+	}
+
+	var verr *helpers.ValidationError
+	if errors.As(err, &verr) {
+		for i := range verr.Errors {
+			if verr.Errors[i].Field == "" {
+				verr.Errors[i].Field = "data"
+			} else {
+				verr.Errors[i].Field = "data." + verr.Errors[i].Field
+			}
+		}
+	}
+	return
+}
+
+// batchChangeError maps the error of a change applied by the repository to the error
+// its operation reports
+func batchChangeError(err error) error {
+	switch err {
+	case repo.ErrDuplicateName:
+		return helpers.ErrDuplicateRecord
+	case repo.ErrVersionMismatch:
+		return helpers.ErrPreconditionFailed
+	case sql.ErrNoRows:
+		return helpers.ErrNoRecordFound
+	default:
+		log.Println(err)
+		return helpers.ErrProcessingFailed
+	}
+}
+
+// setBatchResultError reports err as the outcome of an operation
+func setBatchResultError(result *models.CompanyBatchResult, err error) {
+	var verr *helpers.ValidationError
+	if errors.As(err, &verr) {
+		result.Status = http.StatusUnprocessableEntity
+		result.Error = helpers.ErrInvalidParameters.Error()
+		result.Errors = verr.Errors
+		return
+	}
+
+	result.Status = helpers.GetHttpStatusByErr(err)
+	result.Error = err.Error()
+}
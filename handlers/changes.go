@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// changesBatchSize is the most changes the feed reads per query
+	changesBatchSize = 100
+	// changesKeepAlive is how often an idle feed writes a comment so that proxies keep it open
+	changesKeepAlive = 15 * time.Second
+)
+
+// matchesChangeFilter reports whether a change is one of the companies the feed is filtered to
+func matchesChangeFilter(filter models.CompanyChangesReq, change models.CompanyVersion) bool {
+	if filter.CompanyType != nil && *filter.CompanyType != change.Company.CompanyType {
+		return false
+	}
+	if len(filter.CompanyIDs) > 0 && !helpers.SliceContains(filter.CompanyIDs, change.Company.ID) {
+		return false
+	}
+	return true
+}
+
+// StreamCompanyChanges streams the changes made to the companies of the tenant as server-sent
+// events, read from the change history every WATCH_POLL_INTERVAL so that the changes made
+// through every replica are seen. The id of an event is its history id, ids do not increase
+// since changes are streamed in the order of the transactions making them. The feed resumes
+// after the Last-Event-ID header or starts at the current change without it
+func (h *Handler) StreamCompanyChanges(c *gin.Context) {
+
+	filter := c.MustGet(consts.REQUEST_QUERY).(models.CompanyChangesReq)
+	tenantID := c.GetString(consts.TENANT_ID)
+	ctx := c.Request.Context()
+
+	var (
+		afterID int64
+		err     error
+	)
+	if lastEventID := c.GetHeader("Last-Event-ID"); lastEventID != "" {
+		// validated as numeric
+		afterID, _ = strconv.ParseInt(lastEventID, 10, 64)
+	} else {
+		afterID, err = h.r.GetLatestCompanyChangeID(ctx, tenantID)
+	}
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	poll := time.NewTicker(h.cfg.WatchPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(changesKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		for {
+			changes, err := h.r.ListCompanyChanges(ctx, tenantID, afterID, changesBatchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println(err)
+				}
+				return
+			}
+
+			for _, change := range changes {
+				afterID = change.HistoryID
+				if !matchesChangeFilter(filter, change) {
+					continue
+				}
+
+				change.Company.Version = change.Version
+				err = sse.Encode(c.Writer, sse.Event{
+					Id:    strconv.FormatInt(change.HistoryID, 10),
+					Event: change.Operation,
+					Data:  change,
+				})
+				if err != nil {
+					return
+				}
+			}
+			c.Writer.Flush()
+
+			if len(changes) < changesBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.stopping:
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-poll.C:
+		}
+	}
+}
@@ -3,28 +3,92 @@ package handlers
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/danielboakye/go-xm/config"
 	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/middleware"
 	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/revocation"
 	"github.com/danielboakye/go-xm/repo"
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	r   repo.IRepository
-	v   *helpers.Validation
-	cfg config.Configurations
+	r        repo.IRepository
+	v        *helpers.Validation
+	rs       revocation.IStore
+	cfg      config.Configurations
+	stopping chan struct{}
+	stopOnce sync.Once
 }
 
-func NewHandler(r repo.IRepository, v *helpers.Validation, c config.Configurations) *Handler {
-	return &Handler{r: r, v: v, cfg: c}
+func NewHandler(r repo.IRepository, v *helpers.Validation, rs revocation.IStore, c config.Configurations) *Handler {
+	return &Handler{r: r, v: v, rs: rs, cfg: c, stopping: make(chan struct{})}
 }
 
-func (h *Handler) companyExists(ctx context.Context, companyName string) (exists bool, err error) {
-	_, err = h.r.GetCompanyByName(ctx, companyName)
+// Stop ends the change feeds being streamed, which would otherwise keep the server from
+// draining its connections on shutdown. Clients reconnect to another replica and resume
+func (h *Handler) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopping)
+	})
+}
+
+// abortWithValidationError answers with the problem details of a *helpers.ValidationError
+func abortWithValidationError(c *gin.Context, err error) {
+	var verr *helpers.ValidationError
+	if !errors.As(err, &verr) {
+		verr = helpers.NewValidationError()
+	}
+
+	c.Header("Content-Type", helpers.ProblemContentType)
+	c.AbortWithStatusJSON(
+		http.StatusUnprocessableEntity,
+		helpers.NewValidationProblem(verr, c.Request.URL.Path),
+	)
+}
+
+// etag formats a company version as a strong entity tag
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// matchesETag reports whether an If-None-Match header matches version
+func matchesETag(header string, version int64) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion returns the version required by the If-Match header of the request,
+// nil when any version is accepted
+func ifMatchVersion(c *gin.Context) (version *int64, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return
+	}
+
+	v, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || !strings.HasPrefix(header, `"`) {
+		return nil, helpers.ErrPreconditionFailed
+	}
+	return &v, nil
+}
+
+func (h *Handler) companyExists(ctx context.Context, tenantID string, companyName string) (exists bool, err error) {
+	_, err = h.r.GetCompanyByName(ctx, tenantID, companyName)
 	if err == sql.ErrNoRows {
 		err = nil
 		return
@@ -36,26 +100,9 @@ func (h *Handler) companyExists(ctx context.Context, companyName string) (exists
 
 func (h *Handler) CreateCompany(c *gin.Context) {
 
-	var request models.Company
-	if err := c.ShouldBind(&request); err != nil {
-		err = helpers.ErrInvalidParameters
-		c.AbortWithStatusJSON(
-			helpers.GetHttpStatusByErr(err),
-			gin.H{"error": err.Error()},
-		)
-		return
-	}
-
-	if err := h.v.ValidateForm(request); err != nil {
-		err = helpers.ErrInvalidParameters
-		c.AbortWithStatusJSON(
-			helpers.GetHttpStatusByErr(err),
-			gin.H{"error": err.Error()},
-		)
-		return
-	}
+	request := c.MustGet(consts.REQUEST_BODY).(models.Company)
 
-	exists, err := h.companyExists(c.Request.Context(), request.Name)
+	exists, err := h.companyExists(c.Request.Context(), c.GetString(consts.TENANT_ID), request.Name)
 	if err != nil {
 		log.Println(err)
 		err = helpers.ErrProcessingFailed
@@ -77,6 +124,8 @@ func (h *Handler) CreateCompany(c *gin.Context) {
 
 	companyID, err := h.r.CreateCompany(
 		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
 		request.Name,
 		request.Description,
 		request.AmountOfEmployees,
@@ -85,7 +134,11 @@ func (h *Handler) CreateCompany(c *gin.Context) {
 	)
 
 	if err != nil {
-		err = helpers.ErrProcessingFailed
+		if err == repo.ErrDuplicateName {
+			err = helpers.ErrDuplicateRecord
+		} else {
+			err = helpers.ErrProcessingFailed
+		}
 		c.AbortWithStatusJSON(
 			helpers.GetHttpStatusByErr(err),
 			gin.H{"error": err.Error()},
@@ -100,19 +153,10 @@ func (h *Handler) CreateCompany(c *gin.Context) {
 
 func (h *Handler) UpdateCompany(c *gin.Context) {
 
-	var request models.CompanyUpdateReq
-	if err := c.ShouldBind(&request); err != nil {
-		err = helpers.ErrInvalidParameters
-		c.AbortWithStatusJSON(
-			helpers.GetHttpStatusByErr(err),
-			gin.H{"error": err.Error()},
-		)
-		return
-	}
+	request := c.MustGet(consts.REQUEST_BODY).(models.CompanyUpdateReq)
 
-	if err := h.v.ValidateForm(request); err != nil {
-		log.Println(err)
-		err = helpers.ErrInvalidParameters
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
 		c.AbortWithStatusJSON(
 			helpers.GetHttpStatusByErr(err),
 			gin.H{"error": err.Error()},
@@ -124,12 +168,17 @@ func (h *Handler) UpdateCompany(c *gin.Context) {
 
 	data, err := h.r.GetCompanyByID(
 		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
 		companyIDParam,
 	)
 
 	if err != nil {
-		log.Println(err)
-		err = helpers.ErrProcessingFailed
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
 		c.AbortWithStatusJSON(
 			helpers.GetHttpStatusByErr(err),
 			gin.H{"error": err.Error()},
@@ -139,7 +188,7 @@ func (h *Handler) UpdateCompany(c *gin.Context) {
 
 	if request.Name != nil {
 		if *request.Name != data.Name {
-			exists, err := h.companyExists(c.Request.Context(), *request.Name)
+			exists, err := h.companyExists(c.Request.Context(), c.GetString(consts.TENANT_ID), *request.Name)
 			if err != nil {
 				err = helpers.ErrProcessingFailed
 				c.AbortWithStatusJSON(
@@ -160,9 +209,12 @@ func (h *Handler) UpdateCompany(c *gin.Context) {
 		}
 	}
 
-	err = h.r.UpdateCompany(
+	version, err := h.r.UpdateCompany(
 		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
 		companyIDParam,
+		expectedVersion,
 		request.Name,
 		request.Description,
 		request.AmountOfEmployees,
@@ -171,8 +223,16 @@ func (h *Handler) UpdateCompany(c *gin.Context) {
 	)
 
 	if err != nil {
-		log.Println(err)
-		err = helpers.ErrProcessingFailed
+		if err == repo.ErrDuplicateName {
+			err = helpers.ErrDuplicateRecord
+		} else if err == repo.ErrVersionMismatch {
+			err = helpers.ErrPreconditionFailed
+		} else if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
 		c.AbortWithStatusJSON(
 			helpers.GetHttpStatusByErr(err),
 			gin.H{"error": err.Error()},
@@ -180,20 +240,40 @@ func (h *Handler) UpdateCompany(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag(version))
 	c.Status(http.StatusOK)
 }
 
 func (h *Handler) DeleteCompany(c *gin.Context) {
 
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
 	companyIDParam := c.Param("company-id")
 
-	err := h.r.DeleteCompany(
+	err = h.r.DeleteCompany(
 		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
 		companyIDParam,
+		expectedVersion,
 	)
 
 	if err != nil {
-		err = helpers.ErrProcessingFailed
+		if err == repo.ErrVersionMismatch {
+			err = helpers.ErrPreconditionFailed
+		} else if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
 		c.AbortWithStatusJSON(
 			helpers.GetHttpStatusByErr(err),
 			gin.H{"error": err.Error()},
@@ -208,8 +288,121 @@ func (h *Handler) GetCompany(c *gin.Context) {
 
 	companyIDParam := c.Param("company-id")
 
-	data, err := h.r.GetCompanyByID(
+	request := c.MustGet(consts.REQUEST_QUERY).(models.CompanyGetReq)
+
+	getCompany := h.r.GetCompanyByID
+	if request.IncludeDeleted {
+		if !middleware.HasScope(c, helpers.ScopeAdmin) {
+			err := helpers.ErrInsufficientScope
+			c.AbortWithStatusJSON(
+				helpers.GetHttpStatusByErr(err),
+				gin.H{"error": err.Error()},
+			)
+			return
+		}
+		getCompany = h.r.GetCompanyByIDWithDeleted
+	}
+
+	var (
+		data models.Company
+		err  error
+	)
+	if request.AsOf != "" {
+		// validated as RFC 3339
+		asOf, _ := time.Parse(time.RFC3339, request.AsOf)
+		data, err = h.r.GetCompanyAsOf(c.Request.Context(), c.GetString(consts.TENANT_ID), companyIDParam, asOf)
+	} else {
+		data, err = getCompany(c.Request.Context(), c.GetString(consts.TENANT_ID), companyIDParam)
+	}
+
+	if err != nil {
+
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			err = helpers.ErrProcessingFailed
+		}
+
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.Header("ETag", etag(data.Version))
+
+	if inm := c.GetHeader("If-None-Match"); inm != "" && matchesETag(inm, data.Version) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+func (h *Handler) ListCompanies(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_QUERY).(models.CompanyListReq)
+
+	companies, nextCursor, err := h.r.ListCompanies(c.Request.Context(), c.GetString(consts.TENANT_ID), request)
+	if err != nil {
+		switch err {
+		case repo.ErrInvalidCursor:
+			abortWithValidationError(c, helpers.NewValidationError(helpers.FieldError{
+				Field:   "cursor",
+				Rule:    "cursor",
+				Message: "cursor is not a page token of this listing",
+			}))
+			return
+		case repo.ErrInvalidSort:
+			abortWithValidationError(c, helpers.NewValidationError(helpers.FieldError{
+				Field:   "sort",
+				Rule:    "oneof",
+				Message: "sort is not a sortable field",
+			}))
+			return
+		}
+
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.CompanyList{
+		Data:          companies,
+		NextPageToken: nextCursor,
+	})
+}
+
+func (h *Handler) SearchCompanies(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_QUERY).(models.CompanySearchReq)
+
+	results, err := h.r.SearchCompanies(c.Request.Context(), c.GetString(consts.TENANT_ID), request.Query, request.Limit)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+func (h *Handler) RestoreCompany(c *gin.Context) {
+
+	companyIDParam := c.Param("company-id")
+
+	data, err := h.r.GetCompanyByIDWithDeleted(
 		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
 		companyIDParam,
 	)
 
@@ -218,6 +411,7 @@ func (h *Handler) GetCompany(c *gin.Context) {
 		if err == sql.ErrNoRows {
 			err = helpers.ErrNoRecordFound
 		} else {
+			log.Println(err)
 			err = helpers.ErrProcessingFailed
 		}
 
@@ -228,5 +422,118 @@ func (h *Handler) GetCompany(c *gin.Context) {
 		return
 	}
 
+	if data.DeletedAt == nil {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+
+	// the name may have been given to another company since the delete
+	exists, err := h.companyExists(c.Request.Context(), c.GetString(consts.TENANT_ID), data.Name)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	if exists {
+		err = helpers.ErrDuplicateRecord
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	err = h.r.RestoreCompany(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
+		companyIDParam,
+	)
+
+	if err != nil {
+
+		if err == repo.ErrDuplicateName {
+			err = helpers.ErrDuplicateRecord
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	data.DeletedAt = nil
+
 	c.JSON(http.StatusOK, data)
 }
+
+func (h *Handler) PurgeCompany(c *gin.Context) {
+
+	companyIDParam := c.Param("company-id")
+
+	err := h.r.PurgeCompany(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
+		companyIDParam,
+	)
+
+	if err != nil {
+
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) GetCompanyHistory(c *gin.Context) {
+
+	companyIDParam := c.Param("company-id")
+
+	versions, err := h.r.GetCompanyHistory(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		companyIDParam,
+	)
+
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	if len(versions) == 0 {
+		err = helpers.ErrNoRecordFound
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": versions})
+}
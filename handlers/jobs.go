@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/jobs"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/gin-gonic/gin"
+)
+
+const purgeJobBatchSize = 500
+
+// NewJobRunners returns the runners of the jobs started through h
+func NewJobRunners(h *Handler) jobs.Runners {
+	return jobs.Runners{
+		models.JobTypeImport: h.runImportJob,
+		models.JobTypeExport: h.runExportJob,
+		models.JobTypePurge:  h.runPurgeJob,
+	}
+}
+
+// jobURL is the location of a job, the file it produced is at jobURL/result
+func jobURL(jobID string) string {
+	return "/api/v1/jobs/" + jobID
+}
+
+// jobResponse sets the result URL of a job that produced a file
+func jobResponse(job models.Job) models.Job {
+	if job.HasOutput {
+		job.ResultURL = jobURL(job.ID) + "/result"
+	}
+	return job
+}
+
+// createJob queues a job for the authenticated user and answers 202 Accepted with it
+func (h *Handler) createJob(c *gin.Context, jobType string, params interface{}, input []byte) {
+
+	payload, err := json.Marshal(params)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	job, err := h.r.CreateJob(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
+		jobType,
+		payload,
+		input,
+	)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.Header("Location", jobURL(job.ID))
+	c.JSON(http.StatusAccepted, jobResponse(job))
+}
+
+// CreateExportJob starts exporting the companies of the tenant in the background, the file is
+// downloaded from the result URL of the job
+func (h *Handler) CreateExportJob(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_QUERY).(models.CompanyExportReq)
+
+	h.createJob(c, models.JobTypeExport, models.ExportJobParams{Format: request.Format}, nil)
+}
+
+// CreateImportJob starts importing an uploaded file in the background like ImportCompanies.
+// The result of the job is the import report, the rejected rows are downloaded as a CSV file
+// from its result URL
+func (h *Handler) CreateImportJob(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_QUERY).(models.CompanyImportReq)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	file, filename, err := importFile(c)
+	if err != nil {
+		abortWithValidationError(c, importFileError(err))
+		return
+	}
+	defer file.Close()
+
+	input, err := io.ReadAll(file)
+	if err != nil {
+		abortWithValidationError(c, importFileError(err))
+		return
+	}
+
+	params := models.ImportJobParams{Format: request.Format, DryRun: request.DryRun}
+	if params.Format == "" {
+		params.Format = importFormat(filename, c.ContentType())
+	}
+
+	h.createJob(c, models.JobTypeImport, params, input)
+}
+
+// CreatePurgeJob starts permanently deleting the companies of the tenant soft-deleted before a
+// given time in the background
+func (h *Handler) CreatePurgeJob(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.PurgeJobReq)
+
+	h.createJob(c, models.JobTypePurge, request, nil)
+}
+
+func (h *Handler) GetJob(c *gin.Context) {
+
+	job, err := h.r.GetJob(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
+		c.Param("job-id"),
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, jobResponse(job))
+}
+
+// GetJobResult downloads the file produced by a job, a chunk at a time
+func (h *Handler) GetJobResult(c *gin.Context) {
+
+	jobID := c.Param("job-id")
+
+	contentType, err := h.r.GetJobOutputType(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
+		jobID,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	ext := "csv"
+	if contentType == exportContentType("ndjson") {
+		ext = "ndjson"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+jobID+`.`+ext+`"`)
+	c.Status(http.StatusOK)
+
+	err = h.r.ReadJobOutput(c.Request.Context(), jobID, func(chunk []byte) (err error) {
+		if _, err = c.Writer.Write(chunk); err == nil {
+			c.Writer.Flush()
+		}
+		return
+	})
+	if err != nil {
+		// the status is sent already, the client gets a truncated file
+		log.Println(err)
+	}
+}
+
+// CancelJob cancels a queued or running job. A running job keeps the running status until its
+// worker stops it
+func (h *Handler) CancelJob(c *gin.Context) {
+
+	job, err := h.r.CancelJob(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
+		c.Param("job-id"),
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else if err == repo.ErrJobFinished {
+			err = helpers.ErrJobFinished
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, jobResponse(job))
+}
+
+// runExportJob writes the companies of the tenant of the job to its output
+func (h *Handler) runExportJob(ctx context.Context, job models.Job, progress jobs.Progress, output io.Writer) (
+	outcome jobs.Outcome, err error,
+) {
+	var params models.ExportJobParams
+	if err = json.Unmarshal(job.Params, &params); err != nil {
+		return
+	}
+
+	write, flush := companyWriter(params.Format, output)
+	rows := 0
+	err = h.r.ExportCompanies(ctx, job.TenantID, func(company models.Company) (err error) {
+		rows++
+		if rows%exportFlushRows == 0 {
+			progress(rows, 0)
+		}
+		return write(company)
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return outcome, jobError(ctx, err)
+	}
+
+	progress(rows, rows)
+	outcome = jobs.Outcome{
+		Result:      gin.H{"exported": rows},
+		ContentType: exportContentType(params.Format),
+	}
+	return
+}
+
+// runImportJob imports the file of the job, the rejected rows make up its output
+func (h *Handler) runImportJob(ctx context.Context, job models.Job, progress jobs.Progress, output io.Writer) (
+	outcome jobs.Outcome, err error,
+) {
+	var params models.ImportJobParams
+	if err = json.Unmarshal(job.Params, &params); err != nil {
+		return
+	}
+
+	response, err := h.importCompanies(
+		ctx,
+		job.TenantID,
+		job.OwnerID,
+		bytes.NewReader(job.Input),
+		params.Format,
+		params.DryRun,
+	)
+	var verr *helpers.ValidationError
+	if errors.As(err, &verr) {
+		messages := make([]string, len(verr.Errors))
+		for i, fe := range verr.Errors {
+			messages[i] = fe.Message
+		}
+		return outcome, errors.New(strings.Join(messages, "; "))
+	}
+	if err != nil {
+		return outcome, jobError(ctx, err)
+	}
+
+	progress(response.Total, response.Total)
+	outcome.Result = response
+	if response.Rejected > 0 {
+		if err = writeImportReport(output, response.Errors); err != nil {
+			return outcome, jobError(ctx, err)
+		}
+		outcome.ContentType = "text/csv"
+	}
+	return
+}
+
+// runPurgeJob permanently deletes the companies of the tenant of the job soft-deleted before its
+// time, in batches so that it can be cancelled between them
+func (h *Handler) runPurgeJob(ctx context.Context, job models.Job, progress jobs.Progress, output io.Writer) (
+	outcome jobs.Outcome, err error,
+) {
+	var params models.PurgeJobReq
+	if err = json.Unmarshal(job.Params, &params); err != nil {
+		return
+	}
+	if job.TenantID == "" {
+		// an empty tenant would purge every tenant
+		return outcome, errors.New("job has no tenant")
+	}
+
+	purged := 0
+	for {
+		n, err := h.r.PurgeDeletedCompanies(ctx, job.TenantID, params.DeletedBefore, purgeJobBatchSize)
+		if err != nil {
+			return outcome, jobError(ctx, err)
+		}
+		purged += n
+		progress(purged, 0)
+
+		if n < purgeJobBatchSize {
+			break
+		}
+	}
+
+	progress(purged, purged)
+	outcome.Result = gin.H{"purged": purged}
+	return
+}
+
+// jobError hides the cause of a failed job from its caller unless the job was cancelled
+func jobError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	log.Println(err)
+	return helpers.ErrProcessingFailed
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const purgeDeletedCompaniesQuery = `
+	DELETE FROM companies
+	WHERE company_id IN (
+		SELECT company_id
+		FROM companies
+		WHERE deleted_at < $1
+			AND ($3 = '' OR tenant_id = $3)
+		ORDER BY deleted_at
+		LIMIT $2
+	)
+	RETURNING tenant_id, company_id
+`
+
+func newTestHandler(t *testing.T) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, *Handler) {
+	db, mockDB := dbtest.New(t)
+	return assert.New(t), require.New(t), mockDB, NewHandler(repo.NewRepository(db), nil, nil, config.Configurations{})
+}
+
+func TestRunPurgeJob(t *testing.T) {
+
+	t.Run("only purges the tenant of the job", func(t *testing.T) {
+		assert, require, mockDB, h := newTestHandler(t)
+
+		deletedBefore := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		// the query is scoped to acme, the deleted companies of globex stay
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(purgeDeletedCompaniesQuery).
+			WithArgs(deletedBefore, purgeJobBatchSize, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "company_id"}))
+		mockDB.ExpectCommit()
+
+		outcome, err := h.runPurgeJob(context.Background(), models.Job{
+			TenantID: "acme",
+			Params:   []byte(`{"deletedBefore":"2023-01-01T00:00:00Z"}`),
+		}, func(done, total int) {}, nil)
+		require.NoError(err)
+		assert.Equal(gin.H{"purged": 0}, outcome.Result)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("refuses a job without a tenant", func(t *testing.T) {
+		assert, _, mockDB, h := newTestHandler(t)
+
+		_, err := h.runPurgeJob(context.Background(), models.Job{
+			Params: []byte(`{"deletedBefore":"2023-01-01T00:00:00Z"}`),
+		}, func(done, total int) {}, nil)
+		assert.Error(err)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) ListRoles(c *gin.Context) {
+
+	roles, err := h.r.ListRoles(c.Request.Context())
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": roles})
+}
+
+// UpsertRole creates a role or replaces its scopes. Roles are shared by every tenant, only
+// platform administrators may edit them. Tokens already issued keep the scopes
+// they were issued with until they are refreshed
+func (h *Handler) UpsertRole(c *gin.Context) {
+
+	roleParam := c.Param("role")
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.RoleReq)
+
+	if err := h.r.UpsertRole(c.Request.Context(), roleParam, request.Scopes); err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Role{Name: roleParam, Scopes: request.Scopes})
+}
+
+func (h *Handler) DeleteRole(c *gin.Context) {
+
+	err := h.r.DeleteRole(c.Request.Context(), c.Param("role"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUser creates a user of the tenant with the password and the roles of the request
+func (h *Handler) CreateUser(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.UserReq)
+
+	passwordHash, err := helpers.HashPassword(request.Password)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	user, err := h.r.CreateUser(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		request.Email,
+		passwordHash,
+		request.Roles,
+	)
+	if err == repo.ErrUnknownRole {
+		abortWithValidationError(c, helpers.NewValidationError(helpers.FieldError{
+			Field:   "roles",
+			Rule:    "exists",
+			Message: "roles must name existing roles",
+		}))
+		return
+	}
+	if err != nil {
+		if err == repo.ErrDuplicateEmail {
+			err = helpers.ErrDuplicateRecord
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// BootstrapAdmin creates the user BOOTSTRAP_ADMIN_EMAIL with the admin role in the default
+// tenant, so that a fresh deployment has someone to log in as and create the other users. It
+// does nothing when the email is unset or already taken, by an earlier start or by another
+// replica starting at the same time
+func (h *Handler) BootstrapAdmin(ctx context.Context) error {
+	if h.cfg.BootstrapAdminEmail == "" {
+		return nil
+	}
+
+	_, err := h.r.GetUserByEmail(ctx, h.cfg.BootstrapAdminEmail)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	passwordHash, err := helpers.HashPassword(h.cfg.BootstrapAdminPass)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.r.CreateUser(ctx, h.cfg.DefaultTenantID, h.cfg.BootstrapAdminEmail, passwordHash, []string{helpers.RoleAdmin})
+	if err == repo.ErrDuplicateEmail {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("created admin %s of tenant %s with id %s, list it in ADMIN_USER_IDS to manage roles", user.Email, user.TenantID, user.ID)
+	return nil
+}
+
+// tenantUser looks up the user of the request within the tenant of the caller, it aborts the
+// request and reports false when the user does not belong to it
+func (h *Handler) tenantUser(c *gin.Context, userID string) bool {
+
+	user, err := h.r.GetUserByID(c.Request.Context(), userID)
+	if err == nil && user.TenantID != c.GetString(consts.TENANT_ID) {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return false
+	}
+
+	return true
+}
+
+func (h *Handler) GetUserRoles(c *gin.Context) {
+
+	userIDParam := c.Param("user-id")
+
+	if !h.tenantUser(c, userIDParam) {
+		return
+	}
+
+	h.userRoles(c, userIDParam)
+}
+
+// userRoles responds with the roles of a user and the scopes they grant
+func (h *Handler) userRoles(c *gin.Context, userID string) {
+
+	roles, scopes, err := h.r.GetUserRoles(c.Request.Context(), userID)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.UserRoles{UserID: userID, Roles: roles, Scopes: scopes})
+}
+
+// SetUserRoles replaces the roles of a user. They apply to the tokens issued from then on
+func (h *Handler) SetUserRoles(c *gin.Context) {
+
+	userIDParam := c.Param("user-id")
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.UserRolesReq)
+
+	err := h.r.SetUserRoles(c.Request.Context(), c.GetString(consts.TENANT_ID), userIDParam, request.Roles)
+	if err == repo.ErrUnknownRole {
+		abortWithValidationError(c, helpers.NewValidationError(helpers.FieldError{
+			Field:   "roles",
+			Rule:    "exists",
+			Message: "roles must name existing roles",
+		}))
+		return
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	h.userRoles(c, userIDParam)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/helpers"
+)
+
+const getUserByEmailQuery = `
+	SELECT
+		user_id, tenant_id, email, password_hash, created_at
+	FROM users
+	WHERE lower(email) = lower($1)
+`
+
+func TestBootstrapAdmin(t *testing.T) {
+
+	t.Run("does nothing without an email", func(t *testing.T) {
+		assert, require, mockDB, h := newTestHandler(t)
+
+		require.NoError(h.BootstrapAdmin(context.Background()))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("creates the admin of the default tenant", func(t *testing.T) {
+		assert, require, mockDB, h := newTestHandler(t)
+		h.cfg.BootstrapAdminEmail = "admin@example.com"
+		h.cfg.BootstrapAdminPass = "correct horse battery"
+		h.cfg.DefaultTenantID = "default"
+
+		mockDB.ExpectQuery(getUserByEmailQuery).
+			WithArgs("admin@example.com").
+			WillReturnError(sql.ErrNoRows)
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(`
+			INSERT INTO users (tenant_id, email, password_hash)
+			VALUES ($1, $2, $3)
+			RETURNING user_id, tenant_id, email, password_hash, created_at
+		`).
+			WithArgs("default", "admin@example.com", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "tenant_id", "email", "password_hash", "created_at"}).
+				AddRow("af7c1fe6-d669-414e-b066-e9733f0de7a8", "default", "admin@example.com", "hash", time.Now()))
+		mockDB.ExpectExec(`
+			INSERT INTO user_roles (user_id, role_name)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`).
+			WithArgs("af7c1fe6-d669-414e-b066-e9733f0de7a8", helpers.RoleAdmin).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectCommit()
+
+		require.NoError(h.BootstrapAdmin(context.Background()))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("leaves an existing user alone", func(t *testing.T) {
+		assert, require, mockDB, h := newTestHandler(t)
+		h.cfg.BootstrapAdminEmail = "admin@example.com"
+		h.cfg.BootstrapAdminPass = "correct horse battery"
+
+		mockDB.ExpectQuery(getUserByEmailQuery).
+			WithArgs("admin@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "tenant_id", "email", "password_hash", "created_at"}).
+				AddRow("af7c1fe6-d669-414e-b066-e9733f0de7a8", "default", "admin@example.com", "hash", time.Now()))
+
+		require.NoError(h.BootstrapAdmin(context.Background()))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,559 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// exportFlushRows is how many rows an export writes between flushes to the client
+	exportFlushRows = 100
+	maxImportRows   = 10000
+	maxImportBytes  = 10 << 20
+	// maxImportLine bounds a line of an NDJSON import
+	maxImportLine = 64 << 10
+)
+
+// companyColumns are the columns of a company CSV file, named like the json fields
+var companyColumns = []string{"id", "name", "description", "amountOfEmployees", "registered", "companyType"}
+
+// importRow is a parsed row of an import, Err holds why it cannot be imported
+type importRow struct {
+	Row     int
+	Company models.Company
+	Err     error
+}
+
+// columnKey normalizes a column name so that "Amount of employees" and "amount_of_employees"
+// both map to amountOfEmployees
+func columnKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+func companyRecord(company models.Company) []string {
+	return []string{
+		company.ID,
+		csvCell(company.Name),
+		csvCell(company.Description),
+		strconv.FormatInt(company.AmountOfEmployees, 10),
+		strconv.FormatBool(company.Registered),
+		csvCell(company.CompanyType),
+	}
+}
+
+// csvCell prefixes text a spreadsheet would read as a formula with a quote, so that it is
+// shown as text. parseCSVCell undoes it
+func csvCell(s string) string {
+	if isFormula(s) {
+		return "'" + s
+	}
+	return s
+}
+
+// parseCSVCell drops the quote csvCell puts before a formula, so exported files import as
+// they were
+func parseCSVCell(s string) string {
+	if strings.HasPrefix(s, "'") && isFormula(s[1:]) {
+		return s[1:]
+	}
+	return s
+}
+
+// isFormula reports whether s starts like a formula. A leading tab or carriage return is
+// dropped by some spreadsheets before they look for one
+func isFormula(s string) bool {
+	return s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0]))
+}
+
+// companyWriter writes companies to w as a CSV or an NDJSON file, flush writes out what is
+// buffered. A CSV file starts with a header row even when it has no company
+func companyWriter(format string, w io.Writer) (write func(models.Company) error, flush func() error) {
+	if format == "ndjson" {
+		encoder := json.NewEncoder(w)
+		write = func(company models.Company) error {
+			return encoder.Encode(company)
+		}
+		flush = func() error {
+			return nil
+		}
+		return
+	}
+
+	csvWriter := csv.NewWriter(w)
+	header := false
+	writeHeader := func() error {
+		if header {
+			return nil
+		}
+		header = true
+		return csvWriter.Write(companyColumns)
+	}
+
+	write = func(company models.Company) error {
+		if err := writeHeader(); err != nil {
+			return err
+		}
+		return csvWriter.Write(companyRecord(company))
+	}
+	flush = func() error {
+		if err := writeHeader(); err != nil {
+			return err
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return
+}
+
+// exportContentType is the media type of an export in format
+func exportContentType(format string) string {
+	if format == "csv" {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// ExportCompanies streams the companies of the tenant as a CSV or an NDJSON file
+func (h *Handler) ExportCompanies(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_QUERY).(models.CompanyExportReq)
+
+	// the response starts with the first company so that a failed query still gets an error status
+	start := func() {
+		c.Header("Content-Type", exportContentType(request.Format))
+		c.Header("Content-Disposition", `attachment; filename="companies.`+request.Format+`"`)
+		c.Status(http.StatusOK)
+	}
+
+	write, flush := companyWriter(request.Format, c.Writer)
+	rows := 0
+	err := h.r.ExportCompanies(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		func(company models.Company) (err error) {
+			if rows == 0 {
+				start()
+			}
+			rows++
+
+			if err = write(company); err != nil {
+				return
+			}
+
+			if rows%exportFlushRows == 0 {
+				if err = flush(); err == nil {
+					c.Writer.Flush()
+				}
+			}
+			return
+		},
+	)
+	if err != nil && rows == 0 {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+	if err != nil {
+		// the status is sent already, the client gets a truncated file
+		log.Println(err)
+		return
+	}
+
+	if rows == 0 {
+		start()
+	}
+	if err = flush(); err != nil {
+		log.Println(err)
+	}
+}
+
+// ImportCompanies creates the companies of an uploaded CSV or NDJSON file, sent as the "file"
+// field of a multipart form or as the request body. Every valid row is imported, the rejected
+// rows are reported along with why
+func (h *Handler) ImportCompanies(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_QUERY).(models.CompanyImportReq)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	file, filename, err := importFile(c)
+	if err != nil {
+		abortWithValidationError(c, importFileError(err))
+		return
+	}
+	defer file.Close()
+
+	format := request.Format
+	if format == "" {
+		format = importFormat(filename, c.ContentType())
+	}
+
+	response, err := h.importCompanies(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
+		file,
+		format,
+		request.DryRun,
+	)
+	var verr *helpers.ValidationError
+	if errors.As(err, &verr) {
+		abortWithValidationError(c, err)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	if request.Report == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="import-errors.csv"`)
+		c.Status(http.StatusOK)
+		if err = writeImportReport(c.Writer, response.Errors); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// importCompanies imports the companies of a CSV or an NDJSON file and reports every rejected
+// row. It fails with a *helpers.ValidationError when the file itself is invalid
+func (h *Handler) importCompanies(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	file io.Reader,
+	format string,
+	dryRun bool,
+) (response models.CompanyImportResult, err error) {
+
+	var rows []importRow
+	if format == "ndjson" {
+		rows, err = parseNDJSON(file)
+	} else {
+		rows, err = parseCSV(file)
+	}
+	if err == nil && len(rows) > maxImportRows {
+		err = helpers.NewValidationError(helpers.FieldError{
+			Field:   "file",
+			Rule:    "max",
+			Param:   strconv.Itoa(maxImportRows),
+			Message: fmt.Sprintf("file must have at most %d rows", maxImportRows),
+		})
+	}
+	if err != nil {
+		err = importFileError(err)
+		return
+	}
+
+	companies := make([]models.Company, 0, len(rows))
+	valid := make([]int, 0, len(rows))
+	for i := range rows {
+		if rows[i].Err == nil {
+			rows[i].Err = h.v.ValidateForm(rows[i].Company)
+		}
+		if rows[i].Err == nil {
+			companies = append(companies, rows[i].Company)
+			valid = append(valid, i)
+		}
+	}
+
+	var results []models.CompanyChangeResult
+	if len(companies) > 0 {
+		results, err = h.r.ImportCompanies(ctx, tenantID, userID, companies, dryRun)
+		if err != nil {
+			return
+		}
+	}
+
+	for j, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		row := &rows[valid[j]]
+		if result.Err == repo.ErrDuplicateName {
+			row.Err = helpers.NewValidationError(helpers.FieldError{
+				Field:   "name",
+				Rule:    "unique",
+				Message: "name is already used by another company",
+			})
+		} else {
+			log.Println(result.Err)
+			row.Err = helpers.ErrProcessingFailed
+		}
+	}
+
+	response = models.CompanyImportResult{
+		DryRun: dryRun,
+		Total:  len(rows),
+		Errors: []models.CompanyImportError{},
+	}
+	for _, row := range rows {
+		if row.Err == nil {
+			response.Imported++
+			continue
+		}
+
+		importErr := models.CompanyImportError{Row: row.Row, Error: row.Err.Error()}
+		var verr *helpers.ValidationError
+		if errors.As(row.Err, &verr) {
+			importErr.Errors = verr.Errors
+		}
+		response.Errors = append(response.Errors, importErr)
+	}
+	response.Rejected = len(response.Errors)
+	return
+}
+
+// importFileError reports an uploaded file exceeding maxImportBytes as invalid
+func importFileError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return helpers.NewValidationError(helpers.FieldError{
+			Field:   "file",
+			Rule:    "max",
+			Param:   strconv.Itoa(maxImportBytes),
+			Message: fmt.Sprintf("file must be at most %d bytes", maxImportBytes),
+		})
+	}
+	return err
+}
+
+// importFile returns the uploaded file of an import and its name, which is empty when the
+// file is the request body
+func importFile(c *gin.Context) (file io.ReadCloser, filename string, err error) {
+	if c.ContentType() != "multipart/form-data" {
+		return c.Request.Body, "", nil
+	}
+
+	var header *multipart.FileHeader
+	header, err = c.FormFile("file")
+	if err == http.ErrMissingFile {
+		err = helpers.NewValidationError(helpers.FieldError{
+			Field:   "file",
+			Rule:    "required",
+			Message: "file is required",
+		})
+	}
+	if err != nil {
+		return
+	}
+
+	file, err = header.Open()
+	filename = header.Filename
+	return
+}
+
+// importFormat guesses the format of an uploaded file, defaulting to csv
+func importFormat(filename string, contentType string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".csv":
+		return "csv"
+	}
+
+	if contentType == "application/x-ndjson" {
+		return "ndjson"
+	}
+	return "csv"
+}
+
+// parseCSV reads the rows of a CSV file whose header row names the column of each field
+func parseCSV(file io.Reader) (rows []importRow, err error) {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		err = helpers.NewValidationError(helpers.FieldError{
+			Field:   "file",
+			Rule:    "required",
+			Message: "file must start with a header row",
+		})
+	}
+	if err != nil {
+		return
+	}
+
+	fields := make(map[string]string, len(companyColumns))
+	for _, column := range companyColumns {
+		fields[columnKey(column)] = column
+	}
+
+	columns := make([]string, len(header))
+	var errs []helpers.FieldError
+	for i, name := range header {
+		column, ok := fields[columnKey(name)]
+		if !ok {
+			errs = append(errs, helpers.FieldError{
+				Field:   "file",
+				Rule:    "columns",
+				Allowed: companyColumns,
+				Message: fmt.Sprintf("unknown column %q", name),
+			})
+		}
+		columns[i] = column
+	}
+	if len(errs) > 0 {
+		err = helpers.NewValidationError(errs...)
+		return
+	}
+
+	for {
+		record, readErr := reader.Read()
+		if readErr == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(readErr, &parseErr) {
+			rows = append(rows, importRow{
+				Row: parseErr.StartLine,
+				Err: helpers.NewValidationError(helpers.FieldError{
+					Rule:    "csv",
+					Message: parseErr.Err.Error(),
+				}),
+			})
+			continue
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		line, _ := reader.FieldPos(0)
+		company, rowErr := parseCompanyRecord(columns, record)
+		rows = append(rows, importRow{Row: line, Company: company, Err: rowErr})
+	}
+	return
+}
+
+// parseCompanyRecord maps the cells of a CSV record to the fields of a company. The id
+// column is ignored, imported companies get a new one
+func parseCompanyRecord(columns []string, record []string) (company models.Company, err error) {
+	var errs []helpers.FieldError
+	for i, value := range record {
+		switch columns[i] {
+		case "name":
+			company.Name = parseCSVCell(value)
+		case "description":
+			company.Description = parseCSVCell(value)
+		case "amountOfEmployees":
+			if value == "" {
+				continue
+			}
+			n, parseErr := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if parseErr != nil {
+				errs = append(errs, helpers.FieldError{
+					Field:   "amountOfEmployees",
+					Rule:    "type",
+					Param:   "int64",
+					Message: "amountOfEmployees must be an integer",
+				})
+			}
+			company.AmountOfEmployees = n
+		case "registered":
+			if value == "" {
+				continue
+			}
+			b, parseErr := strconv.ParseBool(strings.TrimSpace(value))
+			if parseErr != nil {
+				errs = append(errs, helpers.FieldError{
+					Field:   "registered",
+					Rule:    "type",
+					Param:   "bool",
+					Message: "registered must be a boolean",
+				})
+			}
+			company.Registered = b
+		case "companyType":
+			company.CompanyType = parseCSVCell(value)
+		}
+	}
+
+	if len(errs) > 0 {
+		err = helpers.NewValidationError(errs...)
+	}
+	return
+}
+
+// parseNDJSON reads the rows of a file holding a company JSON object per line, blank lines
+// are skipped
+func parseNDJSON(file io.Reader) (rows []importRow, err error) {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLine)
+
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		row := importRow{Row: line}
+		if jsonErr := json.Unmarshal(scanner.Bytes(), &row.Company); jsonErr != nil {
+			row.Err = helpers.BindingError(jsonErr)
+		}
+		rows = append(rows, row)
+	}
+
+	err = scanner.Err()
+	if err == bufio.ErrTooLong {
+		err = helpers.NewValidationError(helpers.FieldError{
+			Field:   "file",
+			Rule:    "max",
+			Param:   strconv.Itoa(maxImportLine),
+			Message: fmt.Sprintf("lines must be at most %d bytes", maxImportLine),
+		})
+	}
+	return
+}
+
+// writeImportReport writes a CSV file listing every error of the rejected rows of an import
+func writeImportReport(w io.Writer, errs []models.CompanyImportError) error {
+	records := [][]string{{"row", "field", "rule", "message"}}
+	for _, e := range errs {
+		row := strconv.Itoa(e.Row)
+		if len(e.Errors) == 0 {
+			records = append(records, []string{row, "", "", csvCell(e.Error)})
+		}
+		for _, fe := range e.Errors {
+			records = append(records, []string{row, csvCell(fe.Field), fe.Rule, csvCell(fe.Message)})
+		}
+	}
+
+	return csv.NewWriter(w).WriteAll(records)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVCell(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		cell  string
+	}{
+		{"", ""},
+		{"example", "example"},
+		{"a=b", "a=b"},
+		{"'quoted", "'quoted"},
+		{"=1+2", "'=1+2"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@alpha", "'@alpha"},
+		{"\t=1+2", "'\t=1+2"},
+		{"\r=1+2", "'\r=1+2"},
+	} {
+		assert.Equal(t, tc.cell, csvCell(tc.value), "csvCell(%q)", tc.value)
+		assert.Equal(t, tc.value, parseCSVCell(tc.cell), "parseCSVCell(%q)", tc.cell)
+	}
+}
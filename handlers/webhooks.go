@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDeliveriesLimit is how many deliveries are listed when no limit is given
+const defaultDeliveriesLimit = 20
+
+// invalidWebhookURL returns the validation error of a webhook URL that is not https, nil for
+// a valid one. Deliveries carry company data so they are never sent in the clear
+func invalidWebhookURL(rawURL string) *helpers.ValidationError {
+	u, err := url.Parse(rawURL)
+	if err == nil && u.Scheme == "https" && u.Host != "" {
+		return nil
+	}
+	return helpers.NewValidationError(helpers.FieldError{
+		Field:   "url",
+		Rule:    "url",
+		Message: "url must be an https URL",
+	})
+}
+
+// CreateWebhook registers a webhook of the tenant. The secret signing its deliveries is only
+// returned in this response
+func (h *Handler) CreateWebhook(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.WebhookReq)
+
+	if verr := invalidWebhookURL(request.URL); verr != nil {
+		abortWithValidationError(c, verr)
+		return
+	}
+
+	secret, err := helpers.GenerateWebhookSecret()
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	webhook, err := h.r.CreateWebhook(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.GetString(consts.USER_ID),
+		request.URL,
+		request.Description,
+		request.EventTypes,
+		secret,
+	)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.NewWebhookResp{Webhook: webhook, Secret: secret})
+}
+
+func (h *Handler) ListWebhooks(c *gin.Context) {
+
+	webhooks, err := h.r.ListWebhooks(c.Request.Context(), c.GetString(consts.TENANT_ID))
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": webhooks})
+}
+
+func (h *Handler) GetWebhook(c *gin.Context) {
+
+	webhook, err := h.r.GetWebhook(c.Request.Context(), c.GetString(consts.TENANT_ID), c.Param("webhook-id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// UpdateWebhook changes a webhook of the tenant. A disabled webhook is enabled again with
+// "enabled": true, deliveries left pending while it was disabled are then sent
+func (h *Handler) UpdateWebhook(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_BODY).(models.WebhookUpdateReq)
+
+	if request.URL != nil {
+		if verr := invalidWebhookURL(*request.URL); verr != nil {
+			abortWithValidationError(c, verr)
+			return
+		}
+	}
+
+	webhook, err := h.r.UpdateWebhook(c.Request.Context(), c.GetString(consts.TENANT_ID), c.Param("webhook-id"), request)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+
+	err := h.r.DeleteWebhook(c.Request.Context(), c.GetString(consts.TENANT_ID), c.Param("webhook-id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries returns the delivery log of a webhook of the tenant, newest first
+func (h *Handler) ListWebhookDeliveries(c *gin.Context) {
+
+	request := c.MustGet(consts.REQUEST_QUERY).(models.WebhookDeliveriesReq)
+	if request.Limit == 0 {
+		request.Limit = defaultDeliveriesLimit
+	}
+
+	ctx := c.Request.Context()
+	tenantID := c.GetString(consts.TENANT_ID)
+	webhookID := c.Param("webhook-id")
+
+	_, err := h.r.GetWebhook(ctx, tenantID, webhookID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	deliveries, err := h.r.ListWebhookDeliveries(ctx, tenantID, webhookID, request.Status, request.Limit)
+	if err != nil {
+		log.Println(err)
+		err = helpers.ErrProcessingFailed
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": deliveries})
+}
+
+// ReplayWebhookDelivery sends the event of a delivery again as a new delivery, whatever the
+// outcome of the original one
+func (h *Handler) ReplayWebhookDelivery(c *gin.Context) {
+
+	delivery, err := h.r.ReplayWebhookDelivery(
+		c.Request.Context(),
+		c.GetString(consts.TENANT_ID),
+		c.Param("webhook-id"),
+		c.Param("delivery-id"),
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		c.AbortWithStatusJSON(
+			helpers.GetHttpStatusByErr(err),
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
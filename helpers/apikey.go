@@ -0,0 +1,51 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// apiKeyPrefix starts every API key so that leaked keys are easy to spot
+const apiKeyPrefix = "xm"
+
+// APIKeyType is the KeyType of the claims of a request authenticated with an API key
+const APIKeyType = "api_key"
+
+// GenerateAPIKey returns a new API key of the form xm_<prefix>_<secret>. The prefix identifies
+// the key and may be shown to its owner, only the SHA-256 hash of the whole key is stored.
+// The secret is random so a fast hash is enough to protect it
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 30)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+
+	prefix = hex.EncodeToString(b[:4])
+	key = apiKeyPrefix + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(b[4:])
+	hash = HashAPIKey(key)
+	return
+}
+
+// ParseAPIKey returns the prefix of key, ok is false when key is not an API key
+func ParseAPIKey(key string) (prefix string, ok bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || len(parts[1]) != 8 || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// HashAPIKey returns the hex encoded SHA-256 of key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckAPIKey reports whether key matches hash
+func CheckAPIKey(hash, key string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashAPIKey(key))) == 1
+}
@@ -1,3 +1,21 @@
 package consts
 
 const USER_ID = "UserID"
+
+// TENANT_ID holds the tenant whose companies the request reads and writes
+const TENANT_ID = "TenantID"
+
+// TOKEN_CLAIMS holds the *helpers.JWTClaims of the authenticated request
+const TOKEN_CLAIMS = "TokenClaims"
+
+// SYSTEM_USER is the acting user of changes made by the service itself
+const SYSTEM_USER = "system"
+
+// INGEST_USER is the acting user of changes ingested from the master data topic
+const INGEST_USER = "ingest"
+
+// REQUEST_QUERY holds the validated query parameters of the request
+const REQUEST_QUERY = "RequestQuery"
+
+// REQUEST_BODY holds the validated body of the request
+const REQUEST_BODY = "RequestBody"
@@ -3,32 +3,58 @@ package helpers
 import (
 	"errors"
 	"net/http"
+
+	"google.golang.org/grpc/codes"
 )
 
 var (
-	ErrUnauthorized      = errors.New("unauthorized")
-	ErrInvalidParameters = errors.New("invalid parameters")
-	ErrNoRecordFound     = errors.New("no record found")
-	ErrDuplicateRecord   = errors.New("duplicate record")
-	ErrProcessingFailed  = errors.New("request could not be processed")
-	ErrInvalidToken      = errors.New("token is invalid")
-	ErrExpiredToken      = errors.New("token is expired")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrInsufficientScope  = errors.New("insufficient scope")
+	ErrInvalidParameters  = errors.New("invalid parameters")
+	ErrNoRecordFound      = errors.New("no record found")
+	ErrDuplicateRecord    = errors.New("duplicate record")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrIdempotencyKeyUsed = errors.New("idempotency key already used for a different request")
+	ErrRequestInProgress  = errors.New("a request with this idempotency key is in progress")
+	ErrProcessingFailed   = errors.New("request could not be processed")
+	ErrInvalidToken       = errors.New("token is invalid")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrExpiredToken       = errors.New("token is expired")
+	ErrBatchAborted       = errors.New("not applied, another operation of the batch failed")
+	ErrJobFinished        = errors.New("job already finished")
 )
 
 func GetHttpStatusByErr(err error) (status int) {
 	switch err {
 	case ErrUnauthorized:
 		status = http.StatusUnauthorized
+	case ErrForbidden:
+		status = http.StatusForbidden
+	case ErrInsufficientScope:
+		status = http.StatusForbidden
 	case ErrInvalidToken:
 		status = http.StatusUnauthorized
 	case ErrExpiredToken:
 		status = http.StatusUnauthorized
+	case ErrInvalidCredentials:
+		status = http.StatusUnauthorized
 	case ErrInvalidParameters:
 		status = http.StatusUnprocessableEntity
 	case ErrNoRecordFound:
 		status = http.StatusNotFound
 	case ErrDuplicateRecord:
 		status = http.StatusConflict
+	case ErrPreconditionFailed:
+		status = http.StatusPreconditionFailed
+	case ErrIdempotencyKeyUsed:
+		status = http.StatusUnprocessableEntity
+	case ErrRequestInProgress:
+		status = http.StatusConflict
+	case ErrJobFinished:
+		status = http.StatusConflict
+	case ErrBatchAborted:
+		status = http.StatusFailedDependency
 	case ErrProcessingFailed:
 		status = http.StatusInternalServerError
 	default:
@@ -36,3 +62,43 @@ func GetHttpStatusByErr(err error) (status int) {
 	}
 	return
 }
+
+// GetGrpcCodeByErr maps errors to gRPC status codes like GetHttpStatusByErr maps them to HTTP
+// statuses
+func GetGrpcCodeByErr(err error) (code codes.Code) {
+	switch err {
+	case ErrUnauthorized:
+		code = codes.Unauthenticated
+	case ErrForbidden:
+		code = codes.PermissionDenied
+	case ErrInsufficientScope:
+		code = codes.PermissionDenied
+	case ErrInvalidToken:
+		code = codes.Unauthenticated
+	case ErrExpiredToken:
+		code = codes.Unauthenticated
+	case ErrInvalidCredentials:
+		code = codes.Unauthenticated
+	case ErrInvalidParameters:
+		code = codes.InvalidArgument
+	case ErrNoRecordFound:
+		code = codes.NotFound
+	case ErrDuplicateRecord:
+		code = codes.AlreadyExists
+	case ErrPreconditionFailed:
+		code = codes.Aborted
+	case ErrIdempotencyKeyUsed:
+		code = codes.InvalidArgument
+	case ErrRequestInProgress:
+		code = codes.Aborted
+	case ErrJobFinished:
+		code = codes.FailedPrecondition
+	case ErrBatchAborted:
+		code = codes.Aborted
+	case ErrProcessingFailed:
+		code = codes.Internal
+	default:
+		code = codes.Internal
+	}
+	return
+}
@@ -0,0 +1,65 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+
+	"github.com/danielboakye/go-xm/config"
+)
+
+// JWK is an RFC 7517 JSON web key holding an RSA or P-256 public key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWKS returns the public verification keys of cfg. It is empty when tokens are signed
+// with a shared secret
+func NewJWKS(cfg config.Configurations) JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for kid, key := range cfg.JWTVerificationKeys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Use: "sig",
+				Alg: config.SigningMethodRS256,
+				Kid: kid,
+				N:   base64URL(k.N.Bytes()),
+				E:   base64URL(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "EC",
+				Use: "sig",
+				Alg: config.SigningMethodES256,
+				Kid: kid,
+				Crv: k.Curve.Params().Name,
+				X:   base64URL(k.X.FillBytes(make([]byte, size))),
+				Y:   base64URL(k.Y.FillBytes(make([]byte, size))),
+			})
+		}
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
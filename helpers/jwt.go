@@ -1,6 +1,8 @@
 package helpers
 
 import (
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"time"
 
 	"github.com/danielboakye/go-xm/config"
@@ -8,43 +10,100 @@ import (
 )
 
 const (
-	accessKeyType = "access"
+	accessKeyType  = "access"
+	refreshKeyType = "refresh"
 )
 
+// TokenTimePrecision is the precision of the issue and expiry times of tokens, the precision
+// postgres stores revocation times with. Finer than a second, it lets the revocation of the
+// tokens of a user reject those issued earlier in the same second and accept those issued
+// right after
+const TokenTimePrecision = time.Microsecond
+
+func init() {
+	jwt.TimePrecision = TokenTimePrecision
+}
+
 type JWTClaims struct {
-	UserID  string
-	KeyType string
+	UserID   string
+	TenantID string `json:",omitempty"`
+	KeyType  string
+	Roles    []string `json:",omitempty"`
+	Scopes   []string `json:",omitempty"`
 	jwt.RegisteredClaims
 }
 
+// GenerateAccessToken signs an access token granting the roles and scopes of identity within
+// its tenant. It gets a new ID so that it can be revoked
 func GenerateAccessToken(
 	cfg config.Configurations,
-	userID string,
+	identity Identity,
 ) (token string, err error) {
+	return generateToken(cfg, JWTClaims{
+		UserID:   identity.UserID,
+		TenantID: identity.TenantID,
+		KeyType:  accessKeyType,
+		Roles:    identity.Roles,
+		Scopes:   identity.Scopes,
+	}, NewUUID(), cfg.AccessTokenDuration)
+}
 
-	claims := &JWTClaims{
+// GenerateRefreshToken signs a refresh token identified by tokenID. The ID is recorded so
+// that every refresh token can be used only once
+func GenerateRefreshToken(
+	cfg config.Configurations,
+	userID string,
+	tokenID string,
+) (token string, err error) {
+	return generateToken(cfg, JWTClaims{
 		UserID:  userID,
-		KeyType: accessKeyType,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(
-				time.Now().Local().Add(cfg.AccessTokenDuration),
-			),
-		},
+		KeyType: refreshKeyType,
+	}, tokenID, cfg.RefreshTokenDuration)
+}
+
+func generateToken(
+	cfg config.Configurations,
+	claims JWTClaims,
+	tokenID string,
+	duration time.Duration,
+) (token string, err error) {
+
+	now := time.Now().Local()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:       tokenID,
+		IssuedAt: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(
+			now.Add(duration),
+		),
 	}
 
-	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.JWTSecretKey))
+	if cfg.JWTSigningMethod == "" || cfg.JWTSigningMethod == config.SigningMethodHS256 {
+		token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString([]byte(cfg.JWTSecretKey))
+		return
+	}
+
+	t := jwt.NewWithClaims(jwt.GetSigningMethod(cfg.JWTSigningMethod), &claims)
+	t.Header["kid"] = cfg.JWTKeyID
+	token, err = t.SignedString(cfg.JWTSigningKey)
 	return
 }
+
 func ValidateAccessToken(signedToken string, cfg config.Configurations) (claims *JWTClaims, err error) {
+	return validateToken(signedToken, cfg, accessKeyType)
+}
+
+// ValidateRefreshToken is ValidateAccessToken for refresh tokens
+func ValidateRefreshToken(signedToken string, cfg config.Configurations) (claims *JWTClaims, err error) {
+	return validateToken(signedToken, cfg, refreshKeyType)
+}
+
+func validateToken(signedToken string, cfg config.Configurations, keyType string) (claims *JWTClaims, err error) {
 
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&JWTClaims{},
 		func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, ErrInvalidToken
-			}
-			return []byte(cfg.JWTSecretKey), nil
+			return verificationKey(token, cfg)
 		},
 	)
 
@@ -54,10 +113,41 @@ func ValidateAccessToken(signedToken string, cfg config.Configurations) (claims
 	}
 
 	claims, ok := token.Claims.(*JWTClaims)
-	if !ok || !token.Valid || claims.UserID == "" || claims.KeyType != accessKeyType {
+	if !ok || !token.Valid || claims.UserID == "" || claims.ID == "" || claims.KeyType != keyType {
 		err = ErrUnauthorized
 		return
 	}
 
 	return
 }
+
+// verificationKey returns the key that must have signed token. HMAC tokens are only accepted
+// with HS256, or after switching to an asymmetric method until JWT_LEGACY_HS256_UNTIL, other
+// tokens must name one of the verification keys in their kid header
+func verificationKey(token *jwt.Token, cfg config.Configurations) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		legacy := time.Now().Before(cfg.JWTLegacyHS256Until)
+		if cfg.JWTSecretKey == "" || (cfg.JWTSigningMethod != config.SigningMethodHS256 && !legacy) {
+			return nil, ErrInvalidToken
+		}
+		return []byte(cfg.JWTSecretKey), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := cfg.JWTVerificationKeys[kid]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,194 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// FieldError describes why a single field of a request is invalid. Field is the json
+// name of the field, Rule the violated validation tag and Param its argument
+type FieldError struct {
+	Field   string   `json:"field"`
+	Rule    string   `json:"rule"`
+	Param   string   `json:"param,omitempty"`
+	Allowed []string `json:"allowed,omitempty"`
+	Message string   `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return ErrInvalidParameters.Error()
+}
+
+// NewValidationError returns a ValidationError of the given field errors
+func NewValidationError(errs ...FieldError) *ValidationError {
+	return &ValidationError{Errors: errs}
+}
+
+// Problem is an RFC 7807 problem details body extended with the invalid fields of the request
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// NewValidationProblem returns the problem details reporting err for the request to instance
+func NewValidationProblem(err *ValidationError, instance string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(http.StatusUnprocessableEntity),
+		Status:   http.StatusUnprocessableEntity,
+		Detail:   ErrInvalidParameters.Error(),
+		Instance: instance,
+		Errors:   err.Errors,
+	}
+}
+
+// NewNotFoundProblem returns the problem details reporting path parameters of the request to
+// instance that no resource can have
+func NewNotFoundProblem(err *ValidationError, instance string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(http.StatusNotFound),
+		Status:   http.StatusNotFound,
+		Detail:   ErrNoRecordFound.Error(),
+		Instance: instance,
+		Errors:   err.Errors,
+	}
+}
+
+// BindingError converts an error returned while binding a request into a ValidationError
+func BindingError(err error) *ValidationError {
+	var (
+		verrs     validator.ValidationErrors
+		typeErr   *json.UnmarshalTypeError
+		syntaxErr *json.SyntaxError
+	)
+
+	switch {
+	case errors.As(err, &verrs):
+		return newFieldErrors(verrs)
+	case errors.As(err, &typeErr):
+		return NewValidationError(FieldError{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+		})
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return NewValidationError(FieldError{
+			Rule:    "json",
+			Message: "request body must be valid JSON",
+		})
+	default:
+		return NewValidationError(FieldError{
+			Rule:    "format",
+			Message: err.Error(),
+		})
+	}
+}
+
+// newFieldErrors describes every failed validation of verrs
+func newFieldErrors(verrs validator.ValidationErrors) *ValidationError {
+	errs := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		e := FieldError{
+			Field: fe.Field(),
+			Rule:  fe.Tag(),
+			Param: fe.Param(),
+		}
+
+		switch {
+		case strings.Contains(e.Rule, "|"):
+			// an OR of tags such as eq=A|eq=B lists the allowed values
+			var rule string
+			for _, alt := range strings.Split(e.Rule, "|") {
+				tag := strings.SplitN(alt, "=", 2)
+				rule = tag[0]
+				if len(tag) == 2 {
+					e.Allowed = append(e.Allowed, tag[1])
+				}
+			}
+			e.Rule, e.Param = rule, ""
+		case e.Rule == "oneof":
+			e.Allowed, e.Param = strings.Fields(e.Param), ""
+		case strings.HasPrefix(e.Rule, "required_"):
+			// conditional rules name the go field they depend on
+			e.Rule, e.Param = "required", ""
+		}
+
+		e.Message = fieldErrorMessage(e, fe.Kind())
+		errs = append(errs, e)
+	}
+	return NewValidationError(errs...)
+}
+
+// fieldErrorMessage returns a human readable description of e
+func fieldErrorMessage(e FieldError, kind reflect.Kind) string {
+	unit := ""
+	if kind == reflect.String {
+		unit = " characters"
+	}
+
+	switch {
+	case len(e.Allowed) > 0:
+		return fmt.Sprintf("%s must be one of: %s", e.Field, strings.Join(e.Allowed, ", "))
+	case e.Rule == "required":
+		return fmt.Sprintf("%s is required", e.Field)
+	case e.Rule == "max" || e.Rule == "lte":
+		return fmt.Sprintf("%s must be at most %s%s", e.Field, e.Param, unit)
+	case e.Rule == "min" || e.Rule == "gte":
+		return fmt.Sprintf("%s must be at least %s%s", e.Field, e.Param, unit)
+	case e.Rule == "eq":
+		return fmt.Sprintf("%s must be %s", e.Field, e.Param)
+	case e.Rule == "uuid":
+		return fmt.Sprintf("%s must be a UUID", e.Field)
+	case e.Rule == "datetime" && e.Param == time.RFC3339:
+		return fmt.Sprintf("%s must be an RFC 3339 timestamp", e.Field)
+	case e.Rule == "datetime":
+		return fmt.Sprintf("%s must be formatted as %s", e.Field, e.Param)
+	default:
+		return fmt.Sprintf("%s is invalid", e.Field)
+	}
+}
+
+// jsonTypeName names a go type the way a json client sees it
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,27 @@
+package helpers
+
+const (
+	ScopeCompanyRead   = "company:read"
+	ScopeCompanyWrite  = "company:write"
+	ScopeCompanyDelete = "company:delete"
+	ScopeAdmin         = "admin"
+)
+
+// RoleAdmin is the role granted to the users of ADMIN_USER_IDS on top of their own
+const RoleAdmin = "admin"
+
+// Scopes lists every scope a token can be granted
+var Scopes = []string{ScopeCompanyRead, ScopeCompanyWrite, ScopeCompanyDelete, ScopeAdmin}
+
+// Identity is the user an access token is issued to along with what it may do
+type Identity struct {
+	UserID   string
+	TenantID string
+	Roles    []string
+	Scopes   []string
+}
+
+// HasScope reports whether the token grants scope
+func (c *JWTClaims) HasScope(scope string) bool {
+	return SliceContains(c.Scopes, scope)
+}
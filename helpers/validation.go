@@ -1,7 +1,6 @@
 package helpers
 
 import (
-	"errors"
 	"reflect"
 	"strings"
 
@@ -28,6 +27,7 @@ func NewValidation() (*Validation, error) {
 }
 
 // ValidateForm function return all validation errors in the associated form fields and struct
+// as a *ValidationError
 func (v *Validation) ValidateForm(i interface{}) error {
 
 	err := v.validate.Struct(i)
@@ -36,7 +36,7 @@ func (v *Validation) ValidateForm(i interface{}) error {
 	}
 
 	if e, ok := err.(validator.ValidationErrors); ok {
-		return errors.New(e[0].Field())
+		return newFieldErrors(e)
 	}
 
 	return nil
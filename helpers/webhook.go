@@ -0,0 +1,39 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// webhookSecretPrefix starts every webhook secret so that leaked secrets are easy to spot
+const webhookSecretPrefix = "whsec_"
+
+// WebhookSignatureHeader signs the body of a webhook delivery
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+// GenerateWebhookSecret returns a new random secret signing the deliveries of a webhook
+func GenerateWebhookSecret() (secret string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	return webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SignWebhook returns the WebhookSignatureHeader of body sent at timestamp, of the form
+// t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>" keyed with secret>.
+// Receivers recompute it and reject old timestamps to prevent replays
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
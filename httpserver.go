@@ -1,13 +1,28 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/danielboakye/go-xm/config"
 	"github.com/danielboakye/go-xm/handlers"
 	"github.com/danielboakye/go-xm/helpers"
 	"github.com/danielboakye/go-xm/middleware"
+	"github.com/danielboakye/go-xm/pkg/companyrpc"
+	"github.com/danielboakye/go-xm/pkg/ingest"
+	"github.com/danielboakye/go-xm/pkg/jobs"
+	"github.com/danielboakye/go-xm/pkg/kfkp"
+	"github.com/danielboakye/go-xm/pkg/retention"
+	"github.com/danielboakye/go-xm/pkg/revocation"
+	"github.com/danielboakye/go-xm/pkg/webhook"
+	"github.com/danielboakye/go-xm/repo"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -16,15 +31,28 @@ const testUUID = "af7c1fe6-d669-414e-b066-e9733f0de7a8"
 
 type HTTPServer struct {
 	handler IHTTPHandler
+	h       *handlers.Handler
 	cfg     config.Configurations
+	workers []Worker
+	closers []io.Closer
+}
+
+// Worker is a background process running alongside the HTTP server, such as the gRPC server
+type Worker interface {
+	Run(ctx context.Context) error
 }
 
 type IHTTPHandler interface {
 	http.Handler
-	Run(addr ...string) error
 }
 
-func newHTTPHandler(handler *handlers.Handler, cfg config.Configurations) IHTTPHandler {
+func newHTTPHandler(
+	handler *handlers.Handler,
+	r repo.IRepository,
+	v *helpers.Validation,
+	revocations revocation.IStore,
+	cfg config.Configurations,
+) IHTTPHandler {
 	//  Creates a handler without any middleware by default
 	engine := gin.New()
 
@@ -39,34 +67,109 @@ func newHTTPHandler(handler *handlers.Handler, cfg config.Configurations) IHTTPH
 	engine.Use(cors.New(cors.Config{
 		// AllowOrigins:     []string{"http://localhost:8080"},
 		AllowAllOrigins:  true,
-		AllowMethods:     []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Content-Type", "Accept", "Authorization", "X-API-Key", "If-Match", "If-None-Match", "Idempotency-Key", "Last-Event-ID"},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           300 * time.Second,
 	}))
 
-	// Generate token for test
-	engine.GET("/api/v1/token", func(ctx *gin.Context) {
-		accessToken, err := helpers.GenerateAccessToken(cfg, testUUID)
-		if err != nil {
-			ctx.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+	// Generate token for test, only in dev mode. It reads and writes companies, nothing more
+	if cfg.DevMode {
+		engine.GET("/api/v1/token", func(ctx *gin.Context) {
+			accessToken, err := helpers.GenerateAccessToken(cfg, helpers.Identity{
+				UserID:   testUUID,
+				TenantID: cfg.DefaultTenantID,
+				Roles:    []string{"editor"},
+				Scopes:   []string{helpers.ScopeCompanyRead, helpers.ScopeCompanyWrite},
+			})
+			if err != nil {
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
 
-		ctx.JSON(http.StatusOK, gin.H{
-			"token": accessToken,
+			ctx.JSON(http.StatusOK, gin.H{
+				"token": accessToken,
+			})
 		})
-	})
+	}
+
+	engine.GET("/.well-known/jwks.json", handler.JWKS)
 
-	public := engine.Group("/api/v1/company")
+	// Validates requests against apiEndpoints right before their handler, after authentication
+	// and idempotency which replays the response of the first request with a key
+	validate := middleware.NewRequestValidator(v, apiEndpoints)
+
+	auth := engine.Group("/api/v1/auth")
+	auth.POST("/login", validate, handler.Login)
+	auth.POST("/refresh", validate, handler.RefreshToken)
+	auth.POST("/logout", middleware.NewRouteFilter(cfg, revocations, nil), validate, handler.Logout)
+
+	// API keys are managed with access tokens only, a key cannot create another one
+	apiKeys := engine.Group("/api/v1/api-keys", middleware.NewRouteFilter(cfg, revocations, nil), validate)
+	apiKeys.POST("", handler.CreateAPIKey)
+	apiKeys.GET("", handler.ListAPIKeys)
+	apiKeys.DELETE("/:key-id", handler.RevokeAPIKey)
+
+	// Anonymous requests read the default tenant, an authenticated caller needs company:read
+	public := engine.Group("/api/v1/company",
+		middleware.NewOptionalRouteFilter(cfg, revocations, r),
+		middleware.RequireScopeIfAuthenticated(helpers.ScopeCompanyRead),
+		validate,
+	)
+	public.GET("", handler.ListCompanies)
+	public.GET("/search", handler.SearchCompanies)
 	public.GET("/:company-id", handler.GetCompany)
 
 	// Mount protected handlers
-	private := engine.Group("/api/v1/company", middleware.NewRouteFilter(cfg))
-	private.POST("", handler.CreateCompany)
-	private.PATCH("/:company-id", handler.UpdateCompany)
-	private.DELETE("/:company-id", handler.DeleteCompany)
+	private := engine.Group("/api/v1/company", middleware.NewRouteFilter(cfg, revocations, r))
+	private.POST("", middleware.RequireScope(helpers.ScopeCompanyWrite), middleware.NewIdempotencyFilter(r, cfg), validate, handler.CreateCompany)
+	private.GET("/export", middleware.RequireScope(helpers.ScopeCompanyRead), validate, handler.ExportCompanies)
+	private.GET("/changes", middleware.RequireScope(helpers.ScopeCompanyRead), validate, handler.StreamCompanyChanges)
+	private.GET("/:company-id/history", middleware.RequireScope(helpers.ScopeCompanyRead), validate, handler.GetCompanyHistory)
+	private.POST("/import", middleware.RequireScope(helpers.ScopeCompanyWrite), validate, handler.ImportCompanies)
+	private.PATCH("/:company-id", middleware.RequireScope(helpers.ScopeCompanyWrite), validate, handler.UpdateCompany)
+	private.DELETE("/:company-id", middleware.RequireScope(helpers.ScopeCompanyDelete), validate, handler.DeleteCompany)
+	private.POST("/:company-id/restore", middleware.RequireScope(helpers.ScopeCompanyDelete), validate, handler.RestoreCompany)
+	private.POST("/:company-id/purge", middleware.RequireScope(helpers.ScopeAdmin), validate, handler.PurgeCompany)
+
+	engine.POST("/api/v1/company:method",
+		middleware.RequireCustomMethod("method", "batch"),
+		middleware.NewRouteFilter(cfg, revocations, r),
+		middleware.RequireScope(helpers.ScopeCompanyWrite),
+		middleware.NewIdempotencyFilter(r, cfg),
+		validate,
+		handler.BatchCompanies,
+	)
+
+	jobGroup := engine.Group("/api/v1/jobs", middleware.NewRouteFilter(cfg, revocations, r))
+	jobGroup.POST("/exports", middleware.RequireScope(helpers.ScopeCompanyRead), validate, handler.CreateExportJob)
+	jobGroup.POST("/imports", middleware.RequireScope(helpers.ScopeCompanyWrite), validate, handler.CreateImportJob)
+	jobGroup.POST("/purges", middleware.RequireScope(helpers.ScopeAdmin), validate, handler.CreatePurgeJob)
+	jobGroup.GET("/:job-id", validate, handler.GetJob)
+	jobGroup.GET("/:job-id/result", validate, handler.GetJobResult)
+	jobGroup.POST("/:job-id/cancel", validate, handler.CancelJob)
+
+	webhooks := engine.Group("/api/v1/webhooks", middleware.NewRouteFilter(cfg, revocations, r), middleware.RequireScope(helpers.ScopeAdmin), validate)
+	webhooks.POST("", handler.CreateWebhook)
+	webhooks.GET("", handler.ListWebhooks)
+	webhooks.GET("/:webhook-id", handler.GetWebhook)
+	webhooks.PATCH("/:webhook-id", handler.UpdateWebhook)
+	webhooks.DELETE("/:webhook-id", handler.DeleteWebhook)
+	webhooks.GET("/:webhook-id/deliveries", handler.ListWebhookDeliveries)
+	webhooks.POST("/:webhook-id/deliveries/:delivery-id/replay", handler.ReplayWebhookDelivery)
+
+	admin := engine.Group("/api/v1/admin", middleware.NewRouteFilter(cfg, revocations, r), middleware.RequireScope(helpers.ScopeAdmin), validate)
+	admin.POST("/users", handler.CreateUser)
+	admin.POST("/users/:user-id/revoke-tokens", handler.RevokeUserTokens)
+	admin.GET("/users/:user-id/roles", handler.GetUserRoles)
+	admin.PUT("/users/:user-id/roles", handler.SetUserRoles)
+	admin.GET("/roles", handler.ListRoles)
+	admin.PUT("/roles/:role", middleware.RequirePlatformAdmin(cfg), handler.UpsertRole)
+	admin.DELETE("/roles/:role", middleware.RequirePlatformAdmin(cfg), handler.DeleteRole)
+
+	// Registered last, the document covers every route above
+	serveOpenAPI(engine)
 
 	engine.NoRoute(func(ctx *gin.Context) {
 		ctx.JSON(http.StatusNotFound, gin.H{
@@ -77,11 +180,124 @@ func newHTTPHandler(handler *handlers.Handler, cfg config.Configurations) IHTTPH
 	return engine
 }
 
-func newHTTPServer(handler IHTTPHandler, cfg config.Configurations) HTTPServer {
-	return HTTPServer{handler: handler, cfg: cfg}
+func newWorkers(
+	relay *kfkp.Relay,
+	retentionJob *retention.Job,
+	pool *jobs.Pool,
+	grpcServer *companyrpc.Server,
+	dispatcher *webhook.Dispatcher,
+	consumer *ingest.Consumer,
+) []Worker {
+	return []Worker{relay, retentionJob, pool, grpcServer, dispatcher, consumer}
 }
 
-func (s HTTPServer) Start() error {
-	err := s.handler.Run(":" + s.cfg.HTTPPort)
-	return err
+// newClosers lists what is closed once the server and the workers have stopped, in order: the
+// kafka producer flushes the events relayed last before the database goes away
+func newClosers(publisher kfkp.IPublisher, db *sql.DB) []io.Closer {
+	return []io.Closer{publisher, db}
+}
+
+func newHTTPServer(
+	handler IHTTPHandler,
+	h *handlers.Handler,
+	cfg config.Configurations,
+	workers []Worker,
+	closers []io.Closer,
+) HTTPServer {
+	return HTTPServer{handler: handler, h: h, cfg: cfg, workers: workers, closers: closers}
+}
+
+// Start serves on the HTTP port until ctx is cancelled, see Serve
+func (s HTTPServer) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", ":"+s.cfg.HTTPPort)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on listener and runs the workers until ctx is cancelled or either fails, then
+// shuts down within SHUTDOWN_TIMEOUT: the server stops accepting connections and lets the
+// requests in flight finish while the workers keep running, so that the changes those requests
+// make are still relayed, then the workers are stopped and the closers closed in order. It
+// fails with the error that stopped the server or with why the shutdown did not complete
+func (s HTTPServer) Serve(ctx context.Context, listener net.Listener) (err error) {
+	server := &http.Server{
+		Handler:           s.handler,
+		ReadTimeout:       s.cfg.HTTPReadTimeout,
+		ReadHeaderTimeout: s.cfg.HTTPHeaderTimeout,
+		WriteTimeout:      s.cfg.HTTPWriteTimeout,
+		IdleTimeout:       s.cfg.HTTPIdleTimeout,
+	}
+	server.RegisterOnShutdown(s.h.Stop)
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	// buffered so that nothing blocks once the server is shutting down
+	failed := make(chan error, len(s.workers)+1)
+
+	var wg sync.WaitGroup
+	for _, w := range s.workers {
+		wg.Add(1)
+		go func(w Worker) {
+			defer wg.Done()
+			if err := w.Run(workersCtx); err != nil {
+				failed <- err
+			}
+		}(w)
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			failed <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Println("shutting down")
+	case err = <-failed:
+		log.Println("shutting down:", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		log.Println("http server shutdown:", shutdownErr)
+		if err == nil {
+			err = fmt.Errorf("http server shutdown: %w", shutdownErr)
+		}
+	}
+
+	stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	default:
+		// workers that stopped already are not late, even when the drain used up the deadline
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			log.Println("workers shutdown:", shutdownCtx.Err())
+			if err == nil {
+				err = fmt.Errorf("workers shutdown: %w", shutdownCtx.Err())
+			}
+		}
+	}
+
+	for _, c := range s.closers {
+		if closeErr := c.Close(); closeErr != nil {
+			log.Println(closeErr)
+		}
+	}
+
+	return
 }
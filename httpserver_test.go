@@ -1,469 +1,393 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"errors"
-	"fmt"
 	"io"
-	"log"
-	"net/http/httptest"
-	"strings"
+	"net"
+	"net/http"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/danielboakye/go-xm/config"
 	"github.com/danielboakye/go-xm/handlers"
 	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
 	"github.com/danielboakye/go-xm/repo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 var cfg = config.Configurations{
-	DBName:              "xmdb",
-	DBUser:              "postgres",
-	DBPass:              "postgres",
-	AccessTokenDuration: 3600000000000,
-	JWTSecretKey:        "91ODUHa4O0wRJCbeCXK4igB4ny/JnFH4jJiMmjf5loo=",
-	HTTPPort:            "8080",
-	KafkaURL:            "kafka:9092",
+	DBName:               "xmdb",
+	DBUser:               "postgres",
+	DBPass:               "postgres",
+	AccessTokenDuration:  3600000000000,
+	JWTSecretKey:         "91ODUHa4O0wRJCbeCXK4igB4ny/JnFH4jJiMmjf5loo=",
+	JWTSigningMethod:     config.SigningMethodHS256,
+	HTTPPort:             "8080",
+	KafkaURL:             "kafka:9092",
+	AdminUserIDs:         []string{testAdminUUID},
+	IdempotencyKeyTTL:    time.Hour,
+	RefreshTokenDuration: 24 * time.Hour,
+	DefaultTenantID:      "default",
+	WatchPollInterval:    time.Hour,
 }
 
-var ErrDBConnection = errors.New("db connection error")
-
-func newTestHTTPHandler(t *testing.T) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, IHTTPHandler) {
-	assert := assert.New(t)
-	require := require.New(t)
-
-	db, mockDB, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	require.NoError(err)
+const testAdminUUID = "0b7f8c5e-5a4c-4d0e-9d6a-2f1a3c4b5d6e"
+
+const testTenantID = "acme"
+
+var (
+	testIdentity = helpers.Identity{
+		UserID:   testUUID,
+		TenantID: testTenantID,
+		Roles:    []string{"manager"},
+		Scopes:   []string{helpers.ScopeCompanyRead, helpers.ScopeCompanyWrite, helpers.ScopeCompanyDelete},
+	}
+	testAdminIdentity = helpers.Identity{
+		UserID:   testAdminUUID,
+		TenantID: testTenantID,
+		Roles:    []string{helpers.RoleAdmin},
+		Scopes:   helpers.Scopes,
+	}
+)
 
-	validator, err := helpers.NewValidation()
-	require.NoError(err)
+var ErrDBConnection = errors.New("db connection error")
 
-	var (
-		testRepo    = repo.NewRepository(db)
-		testHandler = handlers.NewHandler(testRepo, validator, cfg)
+const insertOutboxEventQuery = `
+	INSERT INTO company_outbox (event_id, company_id, event_type, payload)
+	VALUES ($1, $2, $3, $4)
+`
+
+const insertHistoryQuery = `
+	INSERT INTO companies_history (tenant_id, company_id, version, operation, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+`
+
+const lockCompanyQuery = `
+	SELECT
+		company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+	FROM companies
+	WHERE company_id = $1
+		AND tenant_id = $2
+		AND deleted_at IS NULL
+	FOR UPDATE
+`
+
+const getCompanyByIDQuery = `
+	SELECT
+		company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+	FROM companies
+	WHERE company_id = $1
+		AND tenant_id = $2
+		AND deleted_at IS NULL
+`
+
+const batchInsertCompaniesQuery = `
+	INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (tenant_id, company_name) WHERE deleted_at IS NULL DO NOTHING
+	RETURNING company_id, company_name
+`
+
+const exportCompaniesQuery = `
+	SELECT
+		company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+	FROM companies
+	WHERE tenant_id = $1
+		AND deleted_at IS NULL
+	ORDER BY created_at, company_id
+`
+
+const reserveIdempotencyKeyQuery = `
+	INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at, locked_until)
+	VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4), CURRENT_TIMESTAMP + make_interval(secs => $5))
+	ON CONFLICT (user_id, idempotency_key) DO UPDATE
+	SET
+		request_hash = EXCLUDED.request_hash,
+		status_code = NULL,
+		content_type = NULL,
+		response_body = NULL,
+		created_at = CURRENT_TIMESTAMP,
+		expires_at = EXCLUDED.expires_at,
+		locked_until = EXCLUDED.locked_until
+	WHERE
+		idempotency_keys.expires_at <= CURRENT_TIMESTAMP
+		OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until <= CURRENT_TIMESTAMP)
+	RETURNING true
+`
+
+func companyRows(companies ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(
+		[]string{
+			"company_id", "company_name",
+			"description", "amount_of_employees",
+			"is_registered", "company_type", "version",
+		},
 	)
-
-	testHTTPHandler := newHTTPHandler(testHandler, cfg)
-
-	return assert, require, mockDB, testHTTPHandler
+	for _, c := range companies {
+		rows.FromCSVString(c)
+	}
+	return rows
 }
 
-func TestCompany(t *testing.T) {
-
-	t.Run("Create Company", func(t *testing.T) {
-		t.Run("Invalid Token", func(t *testing.T) {
-			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
-
-			const invalidAccessToken = "invalid token"
+// testRevocations is an in-memory revocation.IStore
+type testRevocations struct {
+	tokens map[string]bool
+	users  map[string]bool
+}
 
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
-				{
-					"name": "example",
-					"description": "company description",
-					"amountOfEmployees": 2,
-					"registered": false,
-					"companyType": "Non Profit"
-				}
-			`))
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", invalidAccessToken))
+func newTestRevocations() *testRevocations {
+	return &testRevocations{tokens: map[string]bool{}, users: map[string]bool{}}
+}
 
-			testHTTPHandler.ServeHTTP(w, r)
+func (s *testRevocations) IsRevoked(ctx context.Context, claims *helpers.JWTClaims) (bool, error) {
+	return s.tokens[claims.ID] || s.users[claims.UserID], nil
+}
 
-			resp := w.Result()
+func (s *testRevocations) RevokeToken(ctx context.Context, claims *helpers.JWTClaims) error {
+	s.tokens[claims.ID] = true
+	return nil
+}
 
-			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
-				assert.Equal(`{"error":"unauthorized"}`, string(body))
-			}
+func (s *testRevocations) RevokeUserTokens(ctx context.Context, userID string) error {
+	s.users[userID] = true
+	return nil
+}
 
-			assert.Equal(401, resp.StatusCode)
+func newTestHTTPHandler(t *testing.T) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, IHTTPHandler) {
+	return newTestHTTPHandlerWithRevocations(t, newTestRevocations())
+}
 
-		})
+func newTestHTTPHandlerWithRevocations(t *testing.T, revocations *testRevocations) (
+	*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, IHTTPHandler,
+) {
+	return newTestHTTPHandlerWithConfig(t, revocations, cfg)
+}
 
-		t.Run("Invalid Parameter", func(t *testing.T) {
-			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+func newTestHTTPHandlerWithConfig(t *testing.T, revocations *testRevocations, cfg config.Configurations) (
+	*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, IHTTPHandler,
+) {
+	assert := assert.New(t)
+	require := require.New(t)
 
-			accessToken, err := helpers.GenerateAccessToken(cfg, testUUID)
-			require.NoError(err)
+	db, mockDB := dbtest.New(t)
 
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
-				{
-					"name": "example",
-					"description": "company description",
-					"amountOfEmployees": 2,
-					"registered": false,
-					"companyType": "invalid type"
-				}
-			`))
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	validator, err := helpers.NewValidation()
+	require.NoError(err)
 
-			testHTTPHandler.ServeHTTP(w, r)
+	var (
+		testRepo    = repo.NewRepository(db)
+		testHandler = handlers.NewHandler(testRepo, validator, revocations, cfg)
+	)
 
-			resp := w.Result()
+	testHTTPHandler := newHTTPHandler(testHandler, testRepo, validator, revocations, cfg)
 
-			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
-				assert.Equal(`{"error":"invalid parameters"}`, string(body))
-			}
+	return assert, require, mockDB, testHTTPHandler
+}
 
-			assert.Equal(422, resp.StatusCode)
-
-		})
-
-		t.Run("Duplicate company", func(t *testing.T) {
-			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
-
-			mockDB.ExpectQuery(`
-				SELECT
-					company_id, company_name, description, amount_of_employees, is_registered, company_type
-				FROM companies
-				WHERE company_name = $1
-					AND deleted_at IS NULL
-			`).
-				WithArgs("example").
-				WillReturnRows(
-					sqlmock.NewRows(
-						[]string{
-							"company_id", "company_name",
-							"description", "amount_of_employees",
-							"is_registered", "company_type",
-						},
-					).
-						FromCSVString("2899bacc-7107-4cd4-9364-6a6fc4fc2fd3,example,company description,2,false,Non Profit"),
+const getUserByEmailQuery = `
+	SELECT
+		user_id, tenant_id, email, password_hash, created_at
+	FROM users
+	WHERE lower(email) = lower($1)
+`
+
+const getUserByIDQuery = `
+	SELECT
+		user_id, tenant_id, email, password_hash, created_at
+	FROM users
+	WHERE user_id::text = $1
+`
+
+const insertRefreshTokenQuery = `
+	INSERT INTO refresh_tokens (token_id, family_id, user_id, expires_at)
+	VALUES ($1, $2, $3, $4)
+`
+
+const getUserRolesQuery = `
+	SELECT
+		r.role_name, r.scopes
+	FROM user_roles ur
+	JOIN roles r ON r.role_name = ur.role_name
+	WHERE ur.user_id::text = $1
+	ORDER BY r.role_name
+`
+
+const lockRefreshTokenQuery = `
+	SELECT
+		family_id, user_id, expires_at <= CURRENT_TIMESTAMP, used_at, revoked_at
+	FROM refresh_tokens
+	WHERE token_id = $1
+	FOR UPDATE
+`
+
+const testJobID = "5f0c2a8e-3b1d-4c6e-9a7f-1e2d3c4b5a69"
+
+const getJobQuery = `
+	SELECT
+		job_id, job_type, status, progress, total, result, output_type IS NOT NULL, error, created_at, started_at, finished_at, tenant_id, owner_id, params, attempts, cancel_requested
+	FROM jobs
+	WHERE job_id::text = $1
+		AND tenant_id = $2
+		AND owner_id = $3
+`
+
+const listCompanyChangesQuery = `
+	SELECT
+		history_id, version, operation, company_id, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by, changed_at
+	FROM companies_history
+			WHERE tenant_id = $1
+				AND tx_id < pg_snapshot_xmin(pg_current_snapshot())
+				AND (
+					(tx_id, history_id) > ((SELECT tx_id FROM companies_history WHERE history_id = $2), $2)
+					OR (history_id > $2 AND NOT EXISTS (SELECT 1 FROM companies_history WHERE history_id = $2))
 				)
+			ORDER BY tx_id, history_id
+			LIMIT $3
+`
 
-			accessToken, err := helpers.GenerateAccessToken(cfg, testUUID)
-			require.NoError(err)
+const testWebhookID = "c3d2e1f0-1a2b-4c3d-8e4f-5a6b7c8d9e0f"
 
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
-				{
-					"name": "example",
-					"description": "company description",
-					"amountOfEmployees": 2,
-					"registered": false,
-					"companyType": "Non Profit"
-				}
-			`))
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+// shutdownLog records the order in which the parts of the server stop
+type shutdownLog struct {
+	mu     sync.Mutex
+	events []string
+}
 
-			testHTTPHandler.ServeHTTP(w, r)
+func (l *shutdownLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
 
-			resp := w.Result()
+func (l *shutdownLog) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
 
-			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
-				assert.Equal(`{"error":"duplicate record"}`, string(body))
-			}
+type testWorker struct {
+	log *shutdownLog
+	err error
+}
 
-			assert.Equal(409, resp.StatusCode)
-
-			assert.NoError(mockDB.ExpectationsWereMet())
-		})
-
-		t.Run("success", func(t *testing.T) {
-			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
-
-			mockDB.ExpectQuery(`
-				SELECT
-					company_id, company_name, description, amount_of_employees, is_registered, company_type
-				FROM companies
-				WHERE company_name = $1
-					AND deleted_at IS NULL
-			`).
-				WithArgs("example12").
-				WillReturnError(sql.ErrNoRows)
-
-			mockDB.ExpectQuery(`
-				INSERT INTO companies (company_name, description, amount_of_employees, is_registered, company_type)
-				VALUES ($1, $2, $3, $4, $5)
-				RETURNING company_id
-			`).
-				WithArgs("example12", "company description", 2, false, "Non Profit").
-				WillReturnRows(
-					sqlmock.NewRows([]string{"company_id"}).
-						AddRow("ae17b2e2-6b87-4c5b-9c94-3623dacf113b"),
-				)
+func (w testWorker) Run(ctx context.Context) error {
+	if w.err != nil {
+		return w.err
+	}
+	<-ctx.Done()
+	w.log.add("worker stopped")
+	return nil
+}
 
-			accessToken, err := helpers.GenerateAccessToken(cfg, testUUID)
-			require.NoError(err)
-
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("POST", "http:/api/v1/company", strings.NewReader(`
-				{
-					"name": "example12",
-					"description": "company description",
-					"amountOfEmployees": 2,
-					"registered": false,
-					"companyType": "Non Profit"
-				}
-			`))
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-
-			testHTTPHandler.ServeHTTP(w, r)
-
-			resp := w.Result()
-
-			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
-				log.Println(string(body))
-				// assert.Equal(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example12","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit"}`, string(body))
-			}
-			log.Println(resp.StatusCode)
-			assert.Equal(200, resp.StatusCode)
+type testCloser struct {
+	log  *shutdownLog
+	name string
+}
 
-			assert.NoError(mockDB.ExpectationsWereMet())
-		})
-	})
+func (c testCloser) Close() error {
+	c.log.add(c.name + " closed")
+	return nil
+}
 
-	t.Run("Update Company", func(t *testing.T) {
+// newTestServer returns a server whose requests block until release is closed, and a channel
+// receiving a value once a request is in flight
+func newTestServer(t *testing.T, shutdownLog *shutdownLog, shutdownTimeout time.Duration, workers ...Worker) (
+	server HTTPServer, listener net.Listener, inFlight chan struct{}, release chan struct{},
+) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	inFlight, release = make(chan struct{}, 1), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})
 
-		t.Run("Invalid Token", func(t *testing.T) {
-			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+	server = HTTPServer{
+		handler: handler,
+		h:       handlers.NewHandler(nil, nil, nil, cfg),
+		cfg:     config.Configurations{ShutdownTimeout: shutdownTimeout},
+		workers: workers,
+		closers: []io.Closer{testCloser{shutdownLog, "publisher"}, testCloser{shutdownLog, "db"}},
+	}
+	return
+}
 
-			const invalidAccessToken = "invalid token"
+func TestGracefulShutdown(t *testing.T) {
 
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("PATCH", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", strings.NewReader(`
-				{
-					"description": "company description",
-					"amountOfEmployees": 2
-				}
-			`))
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", invalidAccessToken))
+	t.Run("Drains requests then stops workers and closers in order", func(t *testing.T) {
+		assert := assert.New(t)
 
-			testHTTPHandler.ServeHTTP(w, r)
+		shutdownLog := &shutdownLog{}
+		server, listener, inFlight, release := newTestServer(t, shutdownLog, 5*time.Second, testWorker{log: shutdownLog})
 
-			resp := w.Result()
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- server.Serve(ctx, listener) }()
 
-			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
-				assert.Equal(`{"error":"unauthorized"}`, string(body))
+		responses := make(chan int, 1)
+		go func() {
+			resp, err := http.Get("http://" + listener.Addr().String())
+			if err != nil {
+				responses <- 0
+				return
 			}
+			resp.Body.Close()
+			responses <- resp.StatusCode
+		}()
 
-			assert.Equal(401, resp.StatusCode)
-
-		})
-
-		t.Run("Invalid Parameter", func(t *testing.T) {
-			assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
-
-			accessToken, err := helpers.GenerateAccessToken(cfg, testUUID)
-			require.NoError(err)
-
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("PATCH", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", strings.NewReader(`
-				{
-					"amountOfEmployees": -1
-				}
-			`))
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		<-inFlight
+		cancel()
 
-			testHTTPHandler.ServeHTTP(w, r)
+		// the request in flight holds the shutdown, the workers keep running meanwhile
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(shutdownLog.list())
 
-			resp := w.Result()
-
-			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
-				assert.Equal(`{"error":"invalid parameters"}`, string(body))
-			}
-
-			assert.Equal(422, resp.StatusCode)
-
-		})
-		t.Run("success", func(t *testing.T) {
-			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
-
-			mockDB.ExpectQuery(`
-				SELECT
-					company_id, company_name, description, amount_of_employees, is_registered, company_type
-				FROM companies
-				WHERE company_id = $1
-					AND deleted_at IS NULL
-			`).
-				WillReturnRows(
-					sqlmock.NewRows(
-						[]string{
-							"company_id", "company_name",
-							"description", "amount_of_employees",
-							"is_registered", "company_type",
-						},
-					).
-						FromCSVString("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,3,false,Non Profit"),
-				)
+		close(release)
 
-			mockDB.ExpectExec(`
-				UPDATE companies 
-				SET 
-					company_name = coalesce($2, company_name), 
-					description = coalesce($3, description), 
-					amount_of_employees = coalesce($4, amount_of_employees), 
-					is_registered = coalesce($5, is_registered), 
-					company_type = coalesce($6, company_type),
-					modified_at = now()
-				WHERE
-					company_id = $1
-			`).
-				WillReturnResult(sqlmock.NewResult(1, 1))
-
-			accessToken, err := helpers.GenerateAccessToken(cfg, testUUID)
-			require.NoError(err)
-
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("PATCH", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", strings.NewReader(`
-				{
-					"amountOfEmployees": 2
-				}
-			`))
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-
-			testHTTPHandler.ServeHTTP(w, r)
-
-			resp := w.Result()
-
-			_, err = io.ReadAll(resp.Body)
-			assert.NoError(err)
-
-			assert.Equal(200, resp.StatusCode)
-
-			assert.NoError(mockDB.ExpectationsWereMet())
-		})
+		assert.Equal(http.StatusNoContent, <-responses)
+		assert.NoError(<-served)
+		assert.Equal([]string{"worker stopped", "publisher closed", "db closed"}, shutdownLog.list())
 	})
 
-	t.Run("Delete Company", func(t *testing.T) {
+	t.Run("Fails when requests outlast the timeout", func(t *testing.T) {
+		assert := assert.New(t)
 
-		t.Run("Invalid Token", func(t *testing.T) {
-			assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+		shutdownLog := &shutdownLog{}
+		server, listener, inFlight, release := newTestServer(t, shutdownLog, 50*time.Millisecond)
+		defer close(release)
 
-			const invalidAccessToken = "invalid token"
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- server.Serve(ctx, listener) }()
 
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("DELETE", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", invalidAccessToken))
-
-			testHTTPHandler.ServeHTTP(w, r)
-
-			resp := w.Result()
-
-			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
-				assert.Equal(`{"error":"unauthorized"}`, string(body))
+		go func() {
+			if resp, err := http.Get("http://" + listener.Addr().String()); err == nil {
+				resp.Body.Close()
 			}
+		}()
 
-			assert.Equal(401, resp.StatusCode)
-
-		})
-
-		t.Run("success", func(t *testing.T) {
-			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
-
-			mockDB.ExpectExec(`
-				UPDATE companies 
-				SET 
-					deleted_at = now()
-				WHERE
-					company_id = $1
-			`).
-				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b").
-				WillReturnResult(sqlmock.NewResult(1, 1))
-
-			accessToken, err := helpers.GenerateAccessToken(cfg, testUUID)
-			require.NoError(err)
-
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("DELETE", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
-			r.Header.Set("Content-Type", "application/json")
-			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-
-			testHTTPHandler.ServeHTTP(w, r)
-
-			resp := w.Result()
+		<-inFlight
+		cancel()
 
-			_, err = io.ReadAll(resp.Body)
-			assert.NoError(err)
-
-			assert.Equal(200, resp.StatusCode)
-
-			assert.NoError(mockDB.ExpectationsWereMet())
-		})
+		assert.ErrorIs(<-served, context.DeadlineExceeded)
+		assert.Equal([]string{"publisher closed", "db closed"}, shutdownLog.list())
 	})
 
-	t.Run("Get Company", func(t *testing.T) {
-
-		t.Run("No record", func(t *testing.T) {
-			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
-
-			mockDB.ExpectQuery(`
-				SELECT
-					company_id, company_name, description, amount_of_employees, is_registered, company_type
-				FROM companies
-				WHERE company_id = $1
-					AND deleted_at IS NULL
-			`).
-				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b").
-				WillReturnError(sql.ErrNoRows)
-
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
-			r.Header.Set("Content-Type", "application/json")
-
-			testHTTPHandler.ServeHTTP(w, r)
-
-			resp := w.Result()
-
-			_, err := io.ReadAll(resp.Body)
-
-			require.NoError(err)
-
-			assert.Equal(404, resp.StatusCode)
-
-			assert.NoError(mockDB.ExpectationsWereMet())
-		})
-
-		t.Run("success", func(t *testing.T) {
-			assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
-
-			mockDB.ExpectQuery(`
-				SELECT
-					company_id, company_name, description, amount_of_employees, is_registered, company_type
-				FROM companies
-				WHERE company_id = $1
-					AND deleted_at IS NULL
-			`).
-				WithArgs("ae17b2e2-6b87-4c5b-9c94-3623dacf113b").
-				WillReturnRows(
-					sqlmock.NewRows(
-						[]string{
-							"company_id", "company_name",
-							"description", "amount_of_employees",
-							"is_registered", "company_type",
-						},
-					).
-						FromCSVString("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example,company description,2,false,Non Profit"),
-				)
-
-			w := httptest.NewRecorder()
-			r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b", nil)
-			r.Header.Set("Content-Type", "application/json")
-
-			testHTTPHandler.ServeHTTP(w, r)
+	t.Run("A failing worker shuts the server down", func(t *testing.T) {
+		assert := assert.New(t)
 
-			resp := w.Result()
+		shutdownLog := &shutdownLog{}
+		errListen := errors.New("listen tcp :9090: address already in use")
+		server, listener, _, _ := newTestServer(t, shutdownLog, time.Second, testWorker{err: errListen}, testWorker{log: shutdownLog})
 
-			if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
-				assert.Equal(`{"id":"ae17b2e2-6b87-4c5b-9c94-3623dacf113b","name":"example","description":"company description","amountOfEmployees":2,"registered":false,"companyType":"Non Profit"}`, string(body))
-			}
-
-			assert.Equal(200, resp.StatusCode)
-
-			assert.NoError(mockDB.ExpectationsWereMet())
-		})
+		assert.Equal(errListen, server.Serve(context.Background(), listener))
+		assert.Equal([]string{"worker stopped", "publisher closed", "db closed"}, shutdownLog.list())
 	})
-
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/models"
+)
+
+func jobRows(status string, hasOutput bool) *sqlmock.Rows {
+	created := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	return sqlmock.NewRows([]string{
+		"job_id", "job_type", "status", "progress", "total", "result", "has_output", "error",
+		"created_at", "started_at", "finished_at", "tenant_id", "owner_id", "params",
+		"attempts", "cancel_requested",
+	}).AddRow(
+		testJobID, models.JobTypeExport, status, 0, nil, nil, hasOutput, nil,
+		created, nil, nil, testTenantID, testUUID, []byte(`{"format":"csv"}`),
+		0, false,
+	)
+}
+
+func TestJobs(t *testing.T) {
+
+	t.Run("Create export job", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		mockDB.ExpectQuery(`
+			INSERT INTO jobs (tenant_id, owner_id, job_type, params, input)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING job_id, job_type, status, progress, total, result, output_type IS NOT NULL, error, created_at, started_at, finished_at, tenant_id, owner_id, params, attempts, cancel_requested
+		`).
+			WithArgs(testTenantID, testUUID, models.JobTypeExport, []byte(`{"format":"csv"}`), []byte(nil)).
+			WillReturnRows(jobRows(models.JobQueued, false))
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http:/api/v1/jobs/exports?format=csv", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"id":"`+testJobID+`","type":"export","status":"queued","progress":0,"createdAt":"2023-03-01T10:00:00Z"}`, string(body))
+		}
+
+		assert.Equal(202, resp.StatusCode)
+		assert.Equal("/api/v1/jobs/"+testJobID, resp.Header.Get("Location"))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Purge job requires admin", func(t *testing.T) {
+		assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http:/api/v1/jobs/purges", strings.NewReader(`{"deletedBefore":"2023-01-01T00:00:00Z"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"error":"insufficient scope"}`, string(body))
+		}
+
+		assert.Equal(403, resp.StatusCode)
+	})
+
+	t.Run("Get job", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		mockDB.ExpectQuery(getJobQuery).
+			WithArgs(testJobID, testTenantID, testUUID).
+			WillReturnRows(jobRows(models.JobSucceeded, true))
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/jobs/"+testJobID, nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"id":"`+testJobID+`","type":"export","status":"succeeded","progress":0,"resultUrl":"/api/v1/jobs/`+testJobID+`/result","createdAt":"2023-03-01T10:00:00Z"}`, string(body))
+		}
+
+		assert.Equal(200, resp.StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Get job of another user", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		mockDB.ExpectQuery(getJobQuery).
+			WithArgs(testJobID, testTenantID, testAdminUUID).
+			WillReturnError(sql.ErrNoRows)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/jobs/"+testJobID, nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"error":"no record found"}`, string(body))
+		}
+
+		assert.Equal(404, resp.StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Download result", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		mockDB.ExpectQuery(`
+			SELECT
+				output_type
+			FROM jobs
+			WHERE job_id::text = $1
+				AND tenant_id = $2
+				AND owner_id = $3
+				AND output_type IS NOT NULL
+		`).
+			WithArgs(testJobID, testTenantID, testUUID).
+			WillReturnRows(sqlmock.NewRows([]string{"output_type"}).AddRow("text/csv"))
+
+		mockDB.ExpectQuery(`
+			SELECT
+				data
+			FROM job_output_chunks
+			WHERE job_id::text = $1
+			ORDER BY chunk
+		`).
+			WithArgs(testJobID).
+			WillReturnRows(sqlmock.NewRows([]string{"data"}).
+				AddRow([]byte("id,name\n")).
+				AddRow([]byte("ae17b2e2-6b87-4c5b-9c94-3623dacf113b,example\n")))
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/jobs/"+testJobID+"/result", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal("id,name\nae17b2e2-6b87-4c5b-9c94-3623dacf113b,example\n", string(body))
+		}
+
+		assert.Equal(200, resp.StatusCode)
+		assert.Equal("text/csv", resp.Header.Get("Content-Type"))
+		assert.Equal(`attachment; filename="`+testJobID+`.csv"`, resp.Header.Get("Content-Disposition"))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Cancel finished job", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		mockDB.ExpectQuery(`
+			UPDATE jobs
+			SET
+				cancel_requested = true,
+				status = CASE WHEN status = 'queued' THEN 'cancelled' ELSE status END,
+				finished_at = CASE WHEN status = 'queued' THEN CURRENT_TIMESTAMP ELSE finished_at END,
+				input = CASE WHEN status = 'queued' THEN NULL ELSE input END
+			WHERE
+				job_id::text = $1
+				AND tenant_id = $2
+				AND owner_id = $3
+				AND status IN ('queued', 'running')
+			RETURNING job_id, job_type, status, progress, total, result, output_type IS NOT NULL, error, created_at, started_at, finished_at, tenant_id, owner_id, params, attempts, cancel_requested
+		`).
+			WithArgs(testJobID, testTenantID, testUUID).
+			WillReturnError(sql.ErrNoRows)
+
+		mockDB.ExpectQuery(getJobQuery).
+			WithArgs(testJobID, testTenantID, testUUID).
+			WillReturnRows(jobRows(models.JobSucceeded, true))
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http:/api/v1/jobs/"+testJobID+"/cancel", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"error":"job already finished"}`, string(body))
+		}
+
+		assert.Equal(409, resp.StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
@@ -3,29 +3,64 @@ package main
 import (
 	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
+// Exit statuses of the service
+const (
+	// exitOK follows a shutdown asked for with SIGTERM or SIGINT that completed in time
+	exitOK = 0
+	// exitStartup follows a failure to configure or wire the service or to create its first admin
+	exitStartup = 1
+	// exitServe follows a failure of the server or a worker, or a shutdown that did not
+	// complete within SHUTDOWN_TIMEOUT
+	exitServe = 2
+)
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 
-	ctx := context.Background()
+	// a second signal while shutting down kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
 	err := godotenv.Load(".env")
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return exitStartup
 	}
 
 	serverHTTP, err := buildCompileTime(ctx)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return exitStartup
 	}
 
-	err = serverHTTP.Start()
+	err = serverHTTP.h.BootstrapAdmin(ctx)
+	if err != nil {
+		log.Println(err)
+		return exitStartup
+	}
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err = serverHTTP.Start(ctx)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return exitServe
 	}
 
+	return exitOK
 }
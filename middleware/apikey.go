@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const apiKeyHeaderName = "X-API-Key"
+
+// APIKeyStore looks up the API keys presented in the X-API-Key header
+type APIKeyStore interface {
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (key models.APIKey, hash string, err error)
+	TouchAPIKey(ctx context.Context, keyID string) error
+	GetUserRoles(ctx context.Context, userID string) (roles []string, scopes []string, err error)
+}
+
+// getAPIKeyClaims authenticates the API key of the request and returns claims granting the
+// scopes of the key its owner still has. It fails with helpers.ErrInvalidToken unless the key
+// exists, matches, and is neither revoked nor expired
+func getAPIKeyClaims(ctx *gin.Context, cfg config.Configurations, apiKeys APIKeyStore, apiKey string) (
+	claims *helpers.JWTClaims, err error,
+) {
+	prefix, ok := helpers.ParseAPIKey(apiKey)
+	if !ok {
+		return nil, helpers.ErrInvalidToken
+	}
+
+	key, hash, err := apiKeys.GetAPIKeyByPrefix(ctx.Request.Context(), prefix)
+	if err == sql.ErrNoRows {
+		return nil, helpers.ErrInvalidToken
+	}
+	if err != nil {
+		return
+	}
+
+	if !helpers.CheckAPIKey(hash, apiKey) ||
+		key.RevokedAt != nil ||
+		(key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now())) {
+		return nil, helpers.ErrInvalidToken
+	}
+
+	if err = apiKeys.TouchAPIKey(ctx.Request.Context(), key.ID); err != nil {
+		return
+	}
+
+	// administrators hold every scope whatever their roles
+	scopes := key.Scopes
+	if !helpers.SliceContains(cfg.AdminUserIDs, key.OwnerID) {
+		_, ownerScopes, err := apiKeys.GetUserRoles(ctx.Request.Context(), key.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+
+		scopes = []string{}
+		for _, scope := range key.Scopes {
+			if helpers.SliceContains(ownerScopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	claims = &helpers.JWTClaims{
+		UserID:   key.OwnerID,
+		TenantID: key.TenantID,
+		KeyType:  helpers.APIKeyType,
+		Scopes:   scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       key.ID,
+			IssuedAt: jwt.NewNumericDate(key.CreatedAt),
+		},
+	}
+	if key.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*key.ExpiresAt)
+	}
+	return
+}
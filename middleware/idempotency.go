@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeaderName      = "Idempotency-Key"
+	idempotentReplayedHeaderName  = "Idempotent-Replayed"
+	idempotencyKeyMaxLength       = 255
+	idempotencyKeyReleaseDeadline = 5 * time.Second
+	// idempotencyKeyLease is how long a request holds its key before a retry may take it over,
+	// well above the time the requests behind the filter take to answer
+	idempotencyKeyLease = 5 * time.Minute
+)
+
+// IdempotencyStore keeps the responses of requests sent with an Idempotency-Key
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, userID, key, requestHash string, ttl, lease time.Duration) (record models.IdempotencyRecord, reserved bool, err error)
+	SaveIdempotencyResponse(ctx context.Context, userID, key string, statusCode int, contentType string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID, key string) error
+}
+
+// responseRecorder keeps a copy of the body written to the client
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// NewIdempotencyFilter makes requests carrying an Idempotency-Key header safe to retry. The first
+// response for a key is stored for the configured ttl and replayed to later requests with the same
+// key and body, a different body is rejected. A request still in progress answers 409 to its
+// retries until its lease runs out, then a retry runs it again. Keys are scoped to the user so
+// it must run after NewRouteFilter. Server errors are not stored so the request can be retried
+func NewIdempotencyFilter(store IdempotencyStore, cfg config.Configurations) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+
+		key := ctx.GetHeader(idempotencyKeyHeaderName)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+
+		if len(key) > idempotencyKeyMaxLength {
+			ctx.Header("Content-Type", helpers.ProblemContentType)
+			ctx.JSON(http.StatusUnprocessableEntity, helpers.NewValidationProblem(
+				helpers.NewValidationError(helpers.FieldError{
+					Field:   idempotencyKeyHeaderName,
+					Rule:    "max",
+					Param:   strconv.Itoa(idempotencyKeyMaxLength),
+					Message: fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeaderName, idempotencyKeyMaxLength),
+				}),
+				ctx.Request.URL.Path,
+			))
+			ctx.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.Header("Content-Type", helpers.ProblemContentType)
+			ctx.JSON(http.StatusUnprocessableEntity, helpers.NewValidationProblem(helpers.BindingError(err), ctx.Request.URL.Path))
+			ctx.Abort()
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := ctx.GetString(consts.USER_ID)
+		hash := requestHash(ctx.Request, body)
+
+		record, reserved, err := store.ReserveIdempotencyKey(ctx.Request.Context(), userID, key, hash, cfg.IdempotencyKeyTTL, idempotencyKeyLease)
+		if err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": helpers.ErrProcessingFailed.Error()})
+			ctx.Abort()
+			return
+		}
+
+		if !reserved {
+			replayIdempotentResponse(ctx, record, hash)
+			return
+		}
+
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			releaseCtx, cancel := context.WithTimeout(context.Background(), idempotencyKeyReleaseDeadline)
+			defer cancel()
+			if err := store.ReleaseIdempotencyKey(releaseCtx, userID, key); err != nil {
+				log.Println(err)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+
+		ctx.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		err = store.SaveIdempotencyResponse(
+			ctx.Request.Context(),
+			userID,
+			key,
+			recorder.Status(),
+			recorder.Header().Get("Content-Type"),
+			recorder.body.Bytes(),
+		)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		saved = true
+	}
+}
+
+// replayIdempotentResponse answers a request whose key is already held by record
+func replayIdempotentResponse(ctx *gin.Context, record models.IdempotencyRecord, hash string) {
+	var err error
+	switch {
+	case record.RequestHash != hash:
+		err = helpers.ErrIdempotencyKeyUsed
+	case record.StatusCode == 0:
+		err = helpers.ErrRequestInProgress
+	}
+	if err != nil {
+		ctx.JSON(helpers.GetHttpStatusByErr(err), gin.H{"error": err.Error()})
+		ctx.Abort()
+		return
+	}
+
+	ctx.Header(idempotentReplayedHeaderName, "true")
+	ctx.Data(record.StatusCode, record.ContentType, record.Body)
+	ctx.Abort()
+}
+
+// requestHash fingerprints the method, target and body of a request
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
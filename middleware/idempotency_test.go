@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const testUserID = "6a8a1a8e-2a52-4c0e-8e35-0f1b5e1c9d01"
+
+const reserveIdempotencyKeyQuery = `
+	INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at, locked_until)
+	VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4), CURRENT_TIMESTAMP + make_interval(secs => $5))
+	ON CONFLICT (user_id, idempotency_key) DO UPDATE
+	SET
+		request_hash = EXCLUDED.request_hash,
+		status_code = NULL,
+		content_type = NULL,
+		response_body = NULL,
+		created_at = CURRENT_TIMESTAMP,
+		expires_at = EXCLUDED.expires_at,
+		locked_until = EXCLUDED.locked_until
+	WHERE
+		idempotency_keys.expires_at <= CURRENT_TIMESTAMP
+		OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until <= CURRENT_TIMESTAMP)
+	RETURNING true
+`
+
+const getIdempotencyKeyQuery = `
+	SELECT
+		request_hash, status_code, content_type, response_body
+	FROM idempotency_keys
+	WHERE user_id = $1
+		AND idempotency_key = $2
+`
+
+const saveIdempotencyResponseQuery = `
+	UPDATE idempotency_keys
+	SET
+		status_code = $3,
+		content_type = $4,
+		response_body = $5
+	WHERE
+		user_id = $1
+		AND idempotency_key = $2
+`
+
+const releaseIdempotencyKeyQuery = `
+	DELETE FROM idempotency_keys
+	WHERE
+		user_id = $1
+		AND idempotency_key = $2
+`
+
+// newTestIdempotentServer serves POST /companies behind the idempotency filter for testUserID,
+// answering with status and body
+func newTestIdempotentServer(t *testing.T, status int, body string) (*assert.Assertions, sqlmock.Sqlmock, http.Handler) {
+	gin.SetMode(gin.TestMode)
+
+	db, mockDB := dbtest.New(t)
+
+	engine := gin.New()
+	engine.POST("/companies",
+		func(ctx *gin.Context) {
+			ctx.Set(consts.USER_ID, testUserID)
+		},
+		NewIdempotencyFilter(repo.NewRepository(db), config.Configurations{IdempotencyKeyTTL: time.Hour}),
+		func(ctx *gin.Context) {
+			ctx.Data(status, "application/json", []byte(body))
+		},
+	)
+
+	return assert.New(t), mockDB, engine
+}
+
+func idempotentRequest(body string) *http.Request {
+	r := httptest.NewRequest("POST", "/companies", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Idempotency-Key", "create-example12")
+	return r
+}
+
+func TestIdempotencyFilter(t *testing.T) {
+	body := `{"name":"example12"}`
+	hash := requestHash(idempotentRequest(body), []byte(body))
+
+	t.Run("stores the first response", func(t *testing.T) {
+		assert, mockDB, server := newTestIdempotentServer(t, 201, `{"id":"1"}`)
+
+		mockDB.ExpectQuery(reserveIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12", hash, float64(3600), float64(300)).
+			WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+
+		mockDB.ExpectExec(saveIdempotencyResponseQuery).
+			WithArgs(testUserID, "create-example12", 201, "application/json", []byte(`{"id":"1"}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, idempotentRequest(body))
+
+		assert.Equal(201, w.Code)
+		assert.Empty(w.Header().Get("Idempotent-Replayed"))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("replays the stored response", func(t *testing.T) {
+		assert, mockDB, server := newTestIdempotentServer(t, 500, "")
+
+		mockDB.ExpectQuery(reserveIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12", hash, float64(3600), float64(300)).
+			WillReturnError(sql.ErrNoRows)
+
+		mockDB.ExpectQuery(getIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+					AddRow(hash, 201, "application/json", []byte(`{"id":"1"}`)),
+			)
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, idempotentRequest(body))
+
+		if body, err := io.ReadAll(w.Result().Body); assert.NoError(err) {
+			assert.Equal(`{"id":"1"}`, string(body))
+		}
+		assert.Equal(201, w.Code)
+		assert.Equal("true", w.Header().Get("Idempotent-Replayed"))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("rejects a key reused for another request", func(t *testing.T) {
+		assert, mockDB, server := newTestIdempotentServer(t, 500, "")
+
+		mockDB.ExpectQuery(reserveIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12", sqlmock.AnyArg(), float64(3600), float64(300)).
+			WillReturnError(sql.ErrNoRows)
+
+		mockDB.ExpectQuery(getIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+					AddRow(hash, 201, "application/json", []byte(`{}`)),
+			)
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, idempotentRequest(`{"name":"example13"}`))
+
+		if body, err := io.ReadAll(w.Result().Body); assert.NoError(err) {
+			assert.Equal(`{"error":"idempotency key already used for a different request"}`, string(body))
+		}
+		assert.Equal(422, w.Code)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("answers 409 while the request holding the key runs", func(t *testing.T) {
+		assert, mockDB, server := newTestIdempotentServer(t, 201, `{"id":"1"}`)
+
+		mockDB.ExpectQuery(reserveIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12", hash, float64(3600), float64(300)).
+			WillReturnError(sql.ErrNoRows)
+
+		mockDB.ExpectQuery(getIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+					AddRow(hash, nil, nil, nil),
+			)
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, idempotentRequest(body))
+
+		if body, err := io.ReadAll(w.Result().Body); assert.NoError(err) {
+			assert.Equal(`{"error":"a request with this idempotency key is in progress"}`, string(body))
+		}
+		assert.Equal(409, w.Code)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("releases the key of a failed request", func(t *testing.T) {
+		assert, mockDB, server := newTestIdempotentServer(t, 500, `{"error":"request could not be processed"}`)
+
+		mockDB.ExpectQuery(reserveIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12", hash, float64(3600), float64(300)).
+			WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+
+		mockDB.ExpectExec(releaseIdempotencyKeyQuery).
+			WithArgs(testUserID, "create-example12").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, idempotentRequest(body))
+
+		assert.Equal(500, w.Code)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
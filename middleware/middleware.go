@@ -1,12 +1,14 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/danielboakye/go-xm/config"
 	"github.com/danielboakye/go-xm/helpers"
 	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/pkg/revocation"
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,16 +17,163 @@ const (
 	authorizationHeaderScheme = "Bearer"
 )
 
-func NewRouteFilter(cfg config.Configurations) func(ctx *gin.Context) {
+// NewRouteFilter only lets requests with a valid access token that has not been revoked, or
+// with a valid API key in the X-API-Key header, through. API keys are not accepted when apiKeys
+// is nil
+func NewRouteFilter(
+	cfg config.Configurations,
+	revocations revocation.IStore,
+	apiKeys APIKeyStore,
+) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
 
-		claims, err := getJWTClaimsFromHTTPRequest(ctx, cfg)
-		if err != nil {
+		claims, err := authenticate(ctx, cfg, revocations, apiKeys)
+		if err == helpers.ErrUnauthorized {
 			ctx.JSON(http.StatusUnauthorized, gin.H{"error": helpers.ErrUnauthorized.Error()})
 			ctx.Abort()
 			return
 		}
-		ctx = setJWTClaimsInContext(ctx, claims)
+		if err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": helpers.ErrProcessingFailed.Error()})
+			ctx.Abort()
+			return
+		}
+		ctx = setJWTClaimsInContext(ctx, cfg, claims)
+
+		ctx.Next()
+	}
+}
+
+// NewOptionalRouteFilter sets the claims of a valid token or API key in the context like
+// NewRouteFilter but lets anonymous requests through. Requests with a revoked token are treated
+// as anonymous, anonymous requests read the companies of the default tenant
+func NewOptionalRouteFilter(
+	cfg config.Configurations,
+	revocations revocation.IStore,
+	apiKeys APIKeyStore,
+) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+
+		ctx.Set(consts.TENANT_ID, cfg.DefaultTenantID)
+
+		claims, err := authenticate(ctx, cfg, revocations, apiKeys)
+		if err != nil && err != helpers.ErrUnauthorized {
+			log.Println(err)
+		}
+		if err == nil {
+			ctx = setJWTClaimsInContext(ctx, cfg, claims)
+		}
+
+		ctx.Next()
+	}
+}
+
+// authenticate returns the claims of the API key or the access token of the request. It fails
+// with helpers.ErrUnauthorized when neither is valid or it was revoked, other errors are lookup
+// failures
+func authenticate(
+	ctx *gin.Context,
+	cfg config.Configurations,
+	revocations revocation.IStore,
+	apiKeys APIKeyStore,
+) (claims *helpers.JWTClaims, err error) {
+
+	if apiKey := ctx.GetHeader(apiKeyHeaderName); apiKey != "" && apiKeys != nil {
+		claims, err = getAPIKeyClaims(ctx, cfg, apiKeys, apiKey)
+		if err == helpers.ErrInvalidToken {
+			return nil, helpers.ErrUnauthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		claims, err = getJWTClaimsFromHTTPRequest(ctx, cfg)
+		if err != nil {
+			return nil, helpers.ErrUnauthorized
+		}
+	}
+
+	// API keys are revoked along with the tokens of their owner
+
+	revoked, err := revocations.IsRevoked(ctx.Request.Context(), claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, helpers.ErrUnauthorized
+	}
+	return
+}
+
+// RequireScope only lets requests whose token grants every one of scopes through. It must
+// run after NewRouteFilter
+func RequireScope(scopes ...string) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+
+		for _, scope := range scopes {
+			if !HasScope(ctx, scope) {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": helpers.ErrInsufficientScope.Error()})
+				ctx.Abort()
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
+
+// RequireScopeIfAuthenticated lets anonymous requests through, but an authenticated one only
+// when its token grants every one of scopes, like RequireScope. It must run after
+// NewOptionalRouteFilter
+func RequireScopeIfAuthenticated(scopes ...string) func(ctx *gin.Context) {
+	requireScope := RequireScope(scopes...)
+	return func(ctx *gin.Context) {
+
+		if _, ok := ctx.Get(consts.TOKEN_CLAIMS); !ok {
+			ctx.Next()
+			return
+		}
+
+		requireScope(ctx)
+	}
+}
+
+// RequirePlatformAdmin only lets the users of ADMIN_USER_IDS through, for changes that apply
+// to every tenant. It must run after NewRouteFilter
+func RequirePlatformAdmin(cfg config.Configurations) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+
+		if !helpers.SliceContains(cfg.AdminUserIDs, ctx.GetString(consts.USER_ID)) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": helpers.ErrInsufficientScope.Error()})
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// HasScope reports whether the authenticated token of the request grants scope
+func HasScope(ctx *gin.Context, scope string) bool {
+	claims, ok := ctx.Get(consts.TOKEN_CLAIMS)
+	if !ok {
+		return false
+	}
+	return claims.(*helpers.JWTClaims).HasScope(scope)
+}
+
+// RequireCustomMethod only lets requests whose param holds the custom method ":"+method through,
+// as in POST /api/v1/company:batch. gin has no literal colons in paths, such routes are registered
+// with a param that matches any suffix of the path
+func RequireCustomMethod(param string, method string) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+
+		if ctx.Param(param) != ":"+method {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Resource not found"})
+			ctx.Abort()
+			return
+		}
 
 		ctx.Next()
 	}
@@ -45,7 +194,16 @@ func getJWTClaimsFromHTTPRequest(ctx *gin.Context, cfg config.Configurations) (
 	return
 }
 
-func setJWTClaimsInContext(ctx *gin.Context, claims *helpers.JWTClaims) *gin.Context {
+// setJWTClaimsInContext sets the user and the tenant of claims in the context. Tokens issued
+// before multi-tenancy carry no tenant and belong to the default tenant
+func setJWTClaimsInContext(ctx *gin.Context, cfg config.Configurations, claims *helpers.JWTClaims) *gin.Context {
+	tenantID := claims.TenantID
+	if tenantID == "" {
+		tenantID = cfg.DefaultTenantID
+	}
+
 	ctx.Set(consts.USER_ID, claims.UserID)
+	ctx.Set(consts.TENANT_ID, tenantID)
+	ctx.Set(consts.TOKEN_CLAIMS, claims)
 	return ctx
 }
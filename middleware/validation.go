@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/pkg/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+// NewRequestValidator validates the path parameters, headers, query parameters and json body of
+// a request against the endpoint documenting its route, keyed by method and gin path, before
+// the handler runs. The bound query and body are set in the context under consts.REQUEST_QUERY
+// and consts.REQUEST_BODY. Invalid path parameters are answered with 404 since no resource can
+// have them, anything else invalid with 422
+func NewRequestValidator(v *helpers.Validation, endpoints map[string]openapi.Endpoint) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+
+		endpoint, ok := endpoints[ctx.Request.Method+" "+ctx.FullPath()]
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		if endpoint.Params != nil {
+			if _, err := bindRequest(v, endpoint.Params, ctx.ShouldBindUri); err != nil {
+				abortWithProblem(ctx, helpers.NewNotFoundProblem(err, ctx.Request.URL.Path))
+				return
+			}
+		}
+
+		if endpoint.Headers != nil {
+			if _, err := bindRequest(v, endpoint.Headers, ctx.ShouldBindHeader); err != nil {
+				abortWithProblem(ctx, helpers.NewValidationProblem(err, ctx.Request.URL.Path))
+				return
+			}
+		}
+
+		if endpoint.Query != nil {
+			query, err := bindRequest(v, endpoint.Query, ctx.ShouldBindQuery)
+			if err != nil {
+				abortWithProblem(ctx, helpers.NewValidationProblem(err, ctx.Request.URL.Path))
+				return
+			}
+			ctx.Set(consts.REQUEST_QUERY, query)
+		}
+
+		if _, isFile := endpoint.Body.(openapi.File); endpoint.Body != nil && !isFile {
+			body, err := bindBody(ctx, v, endpoint)
+			if err != nil {
+				abortWithProblem(ctx, helpers.NewValidationProblem(err, ctx.Request.URL.Path))
+				return
+			}
+			ctx.Set(consts.REQUEST_BODY, body)
+		}
+
+		ctx.Next()
+	}
+}
+
+// bindBody binds the body of the request to a value of the type of the endpoint's body and
+// validates it. The body is left for the handlers that read it again
+func bindBody(ctx *gin.Context, v *helpers.Validation, endpoint openapi.Endpoint) (interface{}, *helpers.ValidationError) {
+	data, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return nil, helpers.BindingError(err)
+	}
+	defer func() {
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(data))
+	}()
+
+	if len(data) == 0 && endpoint.OptionalBody {
+		return reflect.Zero(reflect.TypeOf(endpoint.Body)).Interface(), nil
+	}
+
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(data))
+	return bindRequest(v, endpoint.Body, ctx.ShouldBind)
+}
+
+// bindRequest binds a part of the request to a new value of the type of model and validates it
+func bindRequest(v *helpers.Validation, model interface{}, bind func(obj interface{}) error) (interface{}, *helpers.ValidationError) {
+	ptr := reflect.New(reflect.TypeOf(model))
+	if err := bind(ptr.Interface()); err != nil {
+		return nil, helpers.BindingError(err)
+	}
+
+	value := ptr.Elem().Interface()
+	if err := v.ValidateForm(value); err != nil {
+		var verr *helpers.ValidationError
+		if !errors.As(err, &verr) {
+			verr = helpers.NewValidationError()
+		}
+		return nil, verr
+	}
+	return value, nil
+}
+
+// abortWithProblem answers with problem details
+func abortWithProblem(ctx *gin.Context, problem helpers.Problem) {
+	ctx.Header("Content-Type", helpers.ProblemContentType)
+	ctx.AbortWithStatusJSON(problem.Status, problem)
+}
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE company_outbox;
+
+COMMIT;
\ No newline at end of file
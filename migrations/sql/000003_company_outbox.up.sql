@@ -0,0 +1,29 @@
+BEGIN;
+
+CREATE TABLE company_outbox (
+	outbox_id BIGSERIAL
+	,
+	event_id UUID NOT NULL UNIQUE
+	,
+	company_id UUID NOT NULL
+	,
+	event_type VARCHAR NOT NULL
+	,
+	payload JSONB NOT NULL
+	,
+	attempts INT NOT NULL DEFAULT 0
+	,
+	last_error VARCHAR NULL
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	published_at timestamp without time zone
+	,
+	PRIMARY KEY (outbox_id)
+);
+
+CREATE INDEX company_outbox_unpublished_idx ON company_outbox (outbox_id) WHERE published_at IS NULL;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP INDEX companies_company_name_trgm_idx;
+
+DROP INDEX companies_search_vector_idx;
+
+ALTER TABLE companies
+DROP COLUMN search_vector;
+
+COMMIT;
\ No newline at end of file
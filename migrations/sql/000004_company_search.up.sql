@@ -0,0 +1,16 @@
+BEGIN;
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE companies
+ADD COLUMN search_vector tsvector
+	GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', coalesce(company_name, '')), 'A')
+		|| setweight(to_tsvector('simple', coalesce(description, '')), 'B')
+	) STORED;
+
+CREATE INDEX companies_search_vector_idx ON companies USING GIN (search_vector);
+
+CREATE INDEX companies_company_name_trgm_idx ON companies USING GIN (company_name gin_trgm_ops);
+
+COMMIT;
\ No newline at end of file
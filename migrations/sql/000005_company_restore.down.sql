@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX companies_company_name_active_key;
+
+ALTER TABLE companies
+ADD CONSTRAINT companies_company_name_key UNIQUE (company_name);
+
+COMMIT;
\ No newline at end of file
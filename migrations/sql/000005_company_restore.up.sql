@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE companies
+DROP CONSTRAINT IF EXISTS companies_company_name_key;
+
+CREATE UNIQUE INDEX companies_company_name_active_key ON companies (company_name) WHERE deleted_at IS NULL;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE companies_history;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,42 @@
+BEGIN;
+
+CREATE TABLE companies_history (
+	history_id BIGSERIAL
+	,
+	company_id UUID NOT NULL
+	,
+	operation VARCHAR NOT NULL
+	,
+	company_name VARCHAR NOT NULL
+	,
+	description VARCHAR NULL
+	,
+	amount_of_employees INT DEFAULT 0
+	,
+	is_registered BOOLEAN DEFAULT false
+	,
+	company_type VARCHAR NULL
+	,
+	diff JSONB NOT NULL DEFAULT '{}'
+	,
+	changed_by VARCHAR NOT NULL
+	,
+	changed_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	PRIMARY KEY (history_id)
+);
+
+CREATE INDEX companies_history_company_id_idx ON companies_history (company_id, changed_at);
+
+INSERT INTO companies_history (company_id, operation, company_name, description, amount_of_employees, is_registered, company_type, changed_by, changed_at)
+SELECT company_id, 'created', company_name, description, amount_of_employees, is_registered, company_type, 'system', created_at
+FROM companies;
+
+INSERT INTO companies_history (company_id, operation, company_name, description, amount_of_employees, is_registered, company_type, changed_by, changed_at)
+SELECT company_id, 'deleted', company_name, description, amount_of_employees, is_registered, company_type, 'system', deleted_at
+FROM companies
+WHERE deleted_at IS NOT NULL;
+
+COMMIT;
\ No newline at end of file
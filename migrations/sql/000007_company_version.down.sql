@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE companies_history
+DROP COLUMN version;
+
+ALTER TABLE companies
+DROP COLUMN version;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE companies
+ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+ALTER TABLE companies_history
+ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+COMMIT;
\ No newline at end of file
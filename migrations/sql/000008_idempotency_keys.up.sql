@@ -0,0 +1,28 @@
+BEGIN;
+
+CREATE TABLE idempotency_keys (
+	user_id VARCHAR NOT NULL
+	,
+	idempotency_key VARCHAR NOT NULL
+	,
+	request_hash VARCHAR NOT NULL
+	,
+	status_code INT NULL
+	,
+	content_type VARCHAR NULL
+	,
+	response_body BYTEA NULL
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	expires_at timestamp without time zone
+		NOT NULL
+	,
+	PRIMARY KEY (user_id, idempotency_key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS refresh_tokens;
+
+DROP TABLE IF EXISTS users;
+
+COMMIT;
\ No newline at end of file
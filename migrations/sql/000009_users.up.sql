@@ -0,0 +1,44 @@
+BEGIN;
+
+CREATE TABLE users (
+	user_id UUID DEFAULT gen_random_uuid ()
+	,
+	email VARCHAR NOT NULL
+	,
+	password_hash VARCHAR NOT NULL
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	modified_at timestamp without time zone
+	,
+	PRIMARY KEY (user_id)
+);
+
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
+
+CREATE TABLE refresh_tokens (
+	token_id UUID NOT NULL
+	,
+	family_id UUID NOT NULL
+	,
+	user_id UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE
+	,
+	expires_at timestamp without time zone
+		NOT NULL
+	,
+	used_at timestamp without time zone
+	,
+	revoked_at timestamp without time zone
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	PRIMARY KEY (token_id)
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS user_token_revocations;
+
+DROP TABLE IF EXISTS revoked_tokens;
+
+COMMIT;
\ No newline at end of file
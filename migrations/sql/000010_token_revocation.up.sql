@@ -0,0 +1,29 @@
+BEGIN;
+
+CREATE TABLE revoked_tokens (
+	jti VARCHAR NOT NULL
+	,
+	user_id VARCHAR NOT NULL
+	,
+	expires_at timestamp without time zone
+		NOT NULL
+	,
+	revoked_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	PRIMARY KEY (jti)
+);
+
+CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+
+CREATE TABLE user_token_revocations (
+	user_id VARCHAR NOT NULL
+	,
+	revoked_before timestamp without time zone
+		NOT NULL
+	,
+	PRIMARY KEY (user_id)
+);
+
+COMMIT;
\ No newline at end of file
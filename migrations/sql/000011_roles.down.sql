@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS user_roles;
+
+DROP TABLE IF EXISTS roles;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,31 @@
+BEGIN;
+
+CREATE TABLE roles (
+	role_name VARCHAR NOT NULL
+	,
+	scopes JSONB NOT NULL DEFAULT '[]'
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	modified_at timestamp without time zone
+	,
+	PRIMARY KEY (role_name)
+);
+
+CREATE TABLE user_roles (
+	user_id UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE
+	,
+	role_name VARCHAR NOT NULL REFERENCES roles (role_name) ON DELETE CASCADE
+	,
+	PRIMARY KEY (user_id, role_name)
+);
+
+INSERT INTO roles (role_name, scopes) VALUES
+	('viewer', '["company:read"]'),
+	('editor', '["company:read", "company:write"]'),
+	('manager', '["company:read", "company:write", "company:delete"]'),
+	('admin', '["company:read", "company:write", "company:delete", "admin"]');
+
+COMMIT;
\ No newline at end of file
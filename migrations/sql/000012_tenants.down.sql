@@ -0,0 +1,22 @@
+BEGIN;
+
+DROP INDEX companies_history_company_id_idx;
+
+CREATE INDEX companies_history_company_id_idx ON companies_history (company_id, changed_at);
+
+DROP INDEX companies_tenant_id_idx;
+
+DROP INDEX companies_tenant_company_name_active_key;
+
+CREATE UNIQUE INDEX companies_company_name_active_key ON companies (company_name) WHERE deleted_at IS NULL;
+
+ALTER TABLE companies_history
+DROP COLUMN tenant_id;
+
+ALTER TABLE companies
+DROP COLUMN tenant_id;
+
+ALTER TABLE users
+DROP COLUMN tenant_id;
+
+COMMIT;
\ No newline at end of file
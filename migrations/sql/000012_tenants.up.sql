@@ -0,0 +1,31 @@
+BEGIN;
+
+ALTER TABLE users
+ADD COLUMN tenant_id VARCHAR NOT NULL DEFAULT 'default';
+
+ALTER TABLE users
+ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE companies
+ADD COLUMN tenant_id VARCHAR NOT NULL DEFAULT 'default';
+
+ALTER TABLE companies
+ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE companies_history
+ADD COLUMN tenant_id VARCHAR NOT NULL DEFAULT 'default';
+
+ALTER TABLE companies_history
+ALTER COLUMN tenant_id DROP DEFAULT;
+
+DROP INDEX companies_company_name_active_key;
+
+CREATE UNIQUE INDEX companies_tenant_company_name_active_key ON companies (tenant_id, company_name) WHERE deleted_at IS NULL;
+
+CREATE INDEX companies_tenant_id_idx ON companies (tenant_id, created_at);
+
+DROP INDEX companies_history_company_id_idx;
+
+CREATE INDEX companies_history_company_id_idx ON companies_history (tenant_id, company_id, changed_at);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,33 @@
+BEGIN;
+
+CREATE TABLE api_keys (
+	key_id UUID DEFAULT gen_random_uuid ()
+	,
+	prefix VARCHAR NOT NULL UNIQUE
+	,
+	key_hash VARCHAR NOT NULL
+	,
+	name VARCHAR NOT NULL
+	,
+	owner_id UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE
+	,
+	tenant_id VARCHAR NOT NULL
+	,
+	scopes JSONB NOT NULL DEFAULT '[]'
+	,
+	expires_at timestamp without time zone
+	,
+	last_used_at timestamp without time zone
+	,
+	revoked_at timestamp without time zone
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	PRIMARY KEY (key_id)
+);
+
+CREATE INDEX api_keys_owner_id_idx ON api_keys (owner_id, created_at);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,53 @@
+BEGIN;
+
+CREATE TABLE jobs (
+	job_id UUID DEFAULT gen_random_uuid ()
+	,
+	tenant_id VARCHAR NOT NULL
+	,
+	owner_id VARCHAR NOT NULL
+	,
+	job_type VARCHAR NOT NULL
+	,
+	status VARCHAR NOT NULL DEFAULT 'queued'
+	,
+	params JSONB NOT NULL DEFAULT '{}'
+	,
+	input BYTEA
+	,
+	progress INT NOT NULL DEFAULT 0
+	,
+	total INT
+	,
+	result JSONB
+	,
+	output BYTEA
+	,
+	output_type VARCHAR
+	,
+	error VARCHAR
+	,
+	attempts INT NOT NULL DEFAULT 0
+	,
+	cancel_requested BOOLEAN NOT NULL DEFAULT false
+	,
+	locked_by VARCHAR
+	,
+	locked_until timestamp without time zone
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	started_at timestamp without time zone
+	,
+	finished_at timestamp without time zone
+	,
+	PRIMARY KEY (job_id)
+);
+
+CREATE INDEX jobs_claim_idx ON jobs (created_at) WHERE status IN ('queued', 'running');
+
+CREATE INDEX jobs_finished_at_idx ON jobs (finished_at) WHERE finished_at IS NOT NULL;
+
+COMMIT;
\ No newline at end of file
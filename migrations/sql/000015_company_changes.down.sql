@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP INDEX IF EXISTS companies_history_tenant_history_id_idx;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+
+CREATE INDEX companies_history_tenant_history_id_idx ON companies_history (tenant_id, history_id);
+
+COMMIT;
\ No newline at end of file
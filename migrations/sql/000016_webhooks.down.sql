@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP INDEX IF EXISTS company_outbox_webhooks_unqueued_idx;
+
+ALTER TABLE company_outbox
+DROP COLUMN IF EXISTS webhooks_queued_at;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TABLE IF EXISTS webhooks;
+
+COMMIT;
\ No newline at end of file
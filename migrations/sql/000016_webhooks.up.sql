@@ -0,0 +1,87 @@
+BEGIN;
+
+CREATE TABLE webhooks (
+	webhook_id UUID DEFAULT gen_random_uuid ()
+	,
+	tenant_id VARCHAR NOT NULL
+	,
+	url VARCHAR NOT NULL
+	,
+	description VARCHAR NOT NULL DEFAULT ''
+	,
+	event_types JSONB NOT NULL DEFAULT '[]'
+	,
+	secret VARCHAR NOT NULL
+	,
+	enabled BOOLEAN NOT NULL DEFAULT true
+	,
+	consecutive_failures INT NOT NULL DEFAULT 0
+	,
+	disabled_at timestamp without time zone
+	,
+	created_by VARCHAR NOT NULL
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	updated_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	PRIMARY KEY (webhook_id)
+);
+
+CREATE INDEX webhooks_tenant_id_idx ON webhooks (tenant_id, created_at);
+
+CREATE TABLE webhook_deliveries (
+	delivery_id UUID DEFAULT gen_random_uuid ()
+	,
+	webhook_id UUID NOT NULL REFERENCES webhooks (webhook_id) ON DELETE CASCADE
+	,
+	tenant_id VARCHAR NOT NULL
+	,
+	event_id UUID NOT NULL
+	,
+	event_type VARCHAR NOT NULL
+	,
+	payload JSONB NOT NULL
+	,
+	status VARCHAR NOT NULL DEFAULT 'pending'
+	,
+	attempts INT NOT NULL DEFAULT 0
+	,
+	next_attempt_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	last_attempt_at timestamp without time zone
+	,
+	response_status INT
+	,
+	last_error VARCHAR
+	,
+	replay_of UUID
+	,
+	created_at timestamp without time zone
+		NOT NULL
+		DEFAULT CURRENT_TIMESTAMP
+	,
+	delivered_at timestamp without time zone
+	,
+	PRIMARY KEY (delivery_id)
+);
+
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE INDEX webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
+
+ALTER TABLE company_outbox
+ADD COLUMN webhooks_queued_at timestamp without time zone;
+
+UPDATE company_outbox
+SET webhooks_queued_at = CURRENT_TIMESTAMP;
+
+CREATE INDEX company_outbox_webhooks_unqueued_idx ON company_outbox (outbox_id) WHERE webhooks_queued_at IS NULL;
+
+COMMIT;
\ No newline at end of file
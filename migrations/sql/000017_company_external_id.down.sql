@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS companies_tenant_external_id_active_key;
+
+ALTER TABLE companies
+DROP COLUMN IF EXISTS external_id;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE companies
+ADD COLUMN external_id VARCHAR NULL;
+
+CREATE UNIQUE INDEX companies_tenant_external_id_active_key ON companies (tenant_id, external_id) WHERE deleted_at IS NULL AND external_id IS NOT NULL;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS companies_history_tx_id_idx;
+
+ALTER TABLE companies_history
+DROP COLUMN IF EXISTS tx_id;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE companies_history
+ADD COLUMN tx_id xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX companies_history_tx_id_idx ON companies_history (tenant_id, tx_id, history_id);
+
+COMMIT;
\ No newline at end of file
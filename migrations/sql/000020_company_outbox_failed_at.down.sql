@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP INDEX IF EXISTS company_outbox_failed_idx;
+
+DROP INDEX IF EXISTS company_outbox_unpublished_idx;
+
+CREATE INDEX company_outbox_unpublished_idx ON company_outbox (outbox_id) WHERE published_at IS NULL;
+
+ALTER TABLE company_outbox
+DROP COLUMN IF EXISTS failed_at;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,12 @@
+BEGIN;
+
+ALTER TABLE company_outbox
+ADD COLUMN failed_at timestamp without time zone;
+
+DROP INDEX company_outbox_unpublished_idx;
+
+CREATE INDEX company_outbox_unpublished_idx ON company_outbox (outbox_id) WHERE published_at IS NULL AND failed_at IS NULL;
+
+CREATE INDEX company_outbox_failed_idx ON company_outbox (company_id) WHERE failed_at IS NOT NULL;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,13 @@
+BEGIN;
+
+ALTER TABLE jobs
+ADD COLUMN IF NOT EXISTS output BYTEA;
+
+UPDATE jobs
+SET
+	output = coalesce((SELECT string_agg(data, ''::bytea ORDER BY chunk) FROM job_output_chunks WHERE job_output_chunks.job_id = jobs.job_id), ''::bytea)
+WHERE output_type IS NOT NULL;
+
+DROP TABLE IF EXISTS job_output_chunks;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,21 @@
+BEGIN;
+
+CREATE TABLE job_output_chunks (
+	job_id UUID NOT NULL REFERENCES jobs (job_id) ON DELETE CASCADE
+	,
+	chunk INT NOT NULL
+	,
+	data BYTEA NOT NULL
+	,
+	PRIMARY KEY (job_id, chunk)
+);
+
+INSERT INTO job_output_chunks (job_id, chunk, data)
+SELECT job_id, 0, output FROM jobs WHERE output IS NOT NULL;
+
+UPDATE jobs SET output_type = NULL WHERE output IS NULL;
+
+ALTER TABLE jobs
+DROP COLUMN output;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE idempotency_keys
+DROP COLUMN IF EXISTS locked_until;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE idempotency_keys
+ADD COLUMN locked_until timestamp without time zone NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+ALTER TABLE idempotency_keys
+ALTER COLUMN locked_until DROP DEFAULT;
+
+COMMIT;
\ No newline at end of file
@@ -1,12 +1,60 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/danielboakye/go-xm/helpers"
+)
+
 type Company struct {
-	ID                string `json:"id"`
-	Name              string `json:"name" validate:"required,max=15"`
-	Description       string `json:"description" validate:"max=3000"`
-	AmountOfEmployees int64  `json:"amountOfEmployees" validate:"gte=0"`
-	Registered        bool   `json:"registered"`
-	CompanyType       string `json:"companyType" validate:"required,eq=Corporations|eq=Non Profit|eq=Cooperative|eq=Sole Proprietorship"`
+	ID                string     `json:"id"`
+	Name              string     `json:"name" validate:"required,max=15"`
+	Description       string     `json:"description" validate:"max=3000"`
+	AmountOfEmployees int64      `json:"amountOfEmployees" validate:"gte=0"`
+	Registered        bool       `json:"registered"`
+	CompanyType       string     `json:"companyType" validate:"required,eq=Corporations|eq=Non Profit|eq=Cooperative|eq=Sole Proprietorship"`
+	DeletedAt         *time.Time `json:"deletedAt,omitempty"`
+	Version           int64      `json:"-"`
+}
+
+// CompanyParams are the path parameters of the routes of a company
+type CompanyParams struct {
+	CompanyID string `json:"company-id" uri:"company-id" validate:"uuid"`
+}
+
+// CompanyGetReq holds the query parameters of a company read. AsOf reads the company as it was
+// at an RFC 3339 time
+type CompanyGetReq struct {
+	IncludeDeleted bool   `json:"includeDeleted" form:"includeDeleted"`
+	AsOf           string `json:"asOf" form:"asOf" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+// CompanyChangesReq filters the change feed to companies of a type or to some companies
+type CompanyChangesReq struct {
+	CompanyType *string  `json:"companyType" form:"companyType" validate:"omitempty,eq=Corporations|eq=Non Profit|eq=Cooperative|eq=Sole Proprietorship"`
+	CompanyIDs  []string `json:"companyIds" form:"companyIds" validate:"max=100,dive,uuid"`
+}
+
+// CompanyChangesHeaders resume the change feed after the last event received, sent again by
+// EventSource when it reconnects
+type CompanyChangesHeaders struct {
+	LastEventID string `json:"Last-Event-ID" header:"Last-Event-ID" validate:"omitempty,numeric"`
+}
+
+// IfMatchHeaders optionally require the current version of a company, as its ETag
+type IfMatchHeaders struct {
+	IfMatch string `json:"If-Match" header:"If-Match"`
+}
+
+// IfNoneMatchHeaders answer 304 Not Modified when the ETag of the company is unchanged
+type IfNoneMatchHeaders struct {
+	IfNoneMatch string `json:"If-None-Match" header:"If-None-Match"`
+}
+
+// IdempotencyHeaders make a request safe to retry with the same key
+type IdempotencyHeaders struct {
+	IdempotencyKey string `json:"Idempotency-Key" header:"Idempotency-Key" validate:"max=255"`
 }
 
 type CompanyUpdateReq struct {
@@ -16,3 +64,431 @@ type CompanyUpdateReq struct {
 	Registered        *bool   `json:"registered"`
 	CompanyType       *string `json:"companyType" validate:"omitempty,eq=Corporations|eq=Non Profit|eq=Cooperative|eq=Sole Proprietorship"`
 }
+
+// Operations of a company batch
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+)
+
+// Modes of a company batch. An atomic batch is applied entirely or not at all, a best-effort
+// batch applies every operation that succeeds
+const (
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "bestEffort"
+)
+
+// CompanyBatchReq applies Operations in order, Mode defaults to atomic
+type CompanyBatchReq struct {
+	Mode       string           `json:"mode" validate:"omitempty,oneof=atomic bestEffort"`
+	Operations []CompanyBatchOp `json:"operations" validate:"required,min=1,max=500"`
+}
+
+// CompanyBatchOp is one operation of a batch. Data is a Company to create or a
+// CompanyUpdateReq, Version optionally requires the current version like If-Match does
+type CompanyBatchOp struct {
+	Op      string          `json:"op" validate:"required,oneof=create update delete"`
+	ID      string          `json:"id" validate:"required_unless=Op create,omitempty,uuid"`
+	Version *int64          `json:"version" validate:"omitempty,gte=1"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// CompanyChange is a validated batch operation handed to the repository
+type CompanyChange struct {
+	Op              string
+	CompanyID       string
+	ExpectedVersion *int64
+	Company         Company
+	Update          CompanyUpdateReq
+}
+
+// CompanyChangeResult is the outcome of a CompanyChange. Err is nil when it was applied
+type CompanyChangeResult struct {
+	CompanyID string
+	Version   int64
+	Err       error
+}
+
+// Outcomes of a company upsert
+const (
+	UpsertCreated   = "created"
+	UpsertUpdated   = "updated"
+	UpsertUnchanged = "unchanged"
+)
+
+// CompanyRecord is a company published by the master data system on the ingest topic. It is
+// matched to a company of the tenant by ExternalID, or by name when no company has that
+// external ID yet. TenantID defaults to DEFAULT_TENANT_ID
+type CompanyRecord struct {
+	ExternalID string `json:"externalId" validate:"max=255"`
+	TenantID   string `json:"tenantId" validate:"max=255"`
+	Company
+}
+
+// CompanyBatchResult is the outcome of the operation at Index of a batch, Status is the
+// status code the operation would have had as a request of its own
+type CompanyBatchResult struct {
+	Index   int                  `json:"index"`
+	Status  int                  `json:"status"`
+	ID      string               `json:"id,omitempty"`
+	Version int64                `json:"version,omitempty"`
+	Error   string               `json:"error,omitempty"`
+	Errors  []helpers.FieldError `json:"errors,omitempty"`
+}
+
+type CompanyExportReq struct {
+	Format string `json:"format" form:"format" validate:"required,oneof=csv ndjson"`
+}
+
+// CompanyImportReq holds the query parameters of an import. Format defaults to the extension
+// of the uploaded file, a dry run validates the rows without creating any company and Report
+// csv answers with the rejected rows as a CSV file
+type CompanyImportReq struct {
+	Format string `json:"format" form:"format" validate:"omitempty,oneof=csv ndjson"`
+	DryRun bool   `json:"dryRun" form:"dryRun"`
+	Report string `json:"report" form:"report" validate:"omitempty,oneof=json csv"`
+}
+
+// CompanyImportError is why the row of an import at Row was rejected. Rows are numbered like
+// the lines of the file, the header of a CSV file being row 1
+type CompanyImportError struct {
+	Row    int                  `json:"row"`
+	Error  string               `json:"error"`
+	Errors []helpers.FieldError `json:"errors,omitempty"`
+}
+
+// CompanyImportResult is the outcome of an import, Imported counts the companies a dry run
+// would have created
+type CompanyImportResult struct {
+	DryRun   bool                 `json:"dryRun"`
+	Total    int                  `json:"total"`
+	Imported int                  `json:"imported"`
+	Rejected int                  `json:"rejected"`
+	Errors   []CompanyImportError `json:"errors"`
+}
+
+// CompanyListReq holds the query parameters of the company listing. Date ranges include
+// their lower bound and exclude their upper bound. Sort is a field name optionally
+// prefixed with "-" for descending order
+type CompanyListReq struct {
+	CompanyType  *string    `json:"companyType" form:"companyType" validate:"omitempty,eq=Corporations|eq=Non Profit|eq=Cooperative|eq=Sole Proprietorship"`
+	Registered   *bool      `json:"registered" form:"registered"`
+	MinEmployees *int64     `json:"minEmployees" form:"minEmployees" validate:"omitempty,gte=0"`
+	MaxEmployees *int64     `json:"maxEmployees" form:"maxEmployees" validate:"omitempty,gte=0"`
+	NamePrefix   *string    `json:"namePrefix" form:"namePrefix" validate:"omitempty,max=15"`
+	CreatedFrom  *time.Time `json:"createdFrom" form:"createdFrom"`
+	CreatedTo    *time.Time `json:"createdTo" form:"createdTo"`
+	ModifiedFrom *time.Time `json:"modifiedFrom" form:"modifiedFrom"`
+	ModifiedTo   *time.Time `json:"modifiedTo" form:"modifiedTo"`
+	Sort         string     `json:"sort" form:"sort" validate:"omitempty,oneof=name -name description -description amountOfEmployees -amountOfEmployees registered -registered companyType -companyType createdAt -createdAt modifiedAt -modifiedAt"`
+	Limit        int        `json:"limit" form:"limit" validate:"omitempty,gte=1,lte=100"`
+	Cursor       string     `json:"cursor" form:"cursor"`
+}
+
+type CompanyList struct {
+	Data          []Company `json:"data"`
+	NextPageToken string    `json:"nextPageToken,omitempty"`
+}
+
+type CompanySearchReq struct {
+	Query string `json:"q" form:"q" validate:"required,max=200"`
+	Limit int    `json:"limit" form:"limit" validate:"omitempty,gte=1,lte=100"`
+}
+
+// CompanySearchResult is a company matched by a search. Score combines the full-text
+// rank with the trigram similarity of the name, Snippet is html escaped text highlighting
+// the matched terms with <mark> tags
+type CompanySearchResult struct {
+	Company
+	Score      float64 `json:"score"`
+	Similarity float64 `json:"similarity"`
+	Snippet    string  `json:"snippet"`
+}
+
+// FieldChange is the previous and new value of a changed company field
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// CompanyVersion is the state of a company after one of its changes
+type CompanyVersion struct {
+	HistoryID int64                  `json:"historyId"`
+	Version   int64                  `json:"version"`
+	Operation string                 `json:"operation"`
+	Company   Company                `json:"company"`
+	Diff      map[string]FieldChange `json:"diff"`
+	ChangedBy string                 `json:"changedBy"`
+	ChangedAt time.Time              `json:"changedAt"`
+}
+
+// IdempotencyRecord is the stored outcome of a request sent with an Idempotency-Key.
+// StatusCode is zero while the original request is still being processed
+type IdempotencyRecord struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+type User struct {
+	ID           string    `json:"id"`
+	TenantID     string    `json:"tenantId"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type LoginReq struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+type RefreshReq struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+// LogoutReq optionally carries the refresh token of the session to end along with the access token
+type LogoutReq struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// TokenResp is the token pair issued on login and refresh. ExpiresIn is the
+// lifetime of the access token in seconds
+type TokenResp struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+// Role is a named set of scopes granted to the users holding it
+type Role struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// RoleParams are the path parameters of the routes of a role
+type RoleParams struct {
+	Role string `json:"role" uri:"role" validate:"max=64"`
+}
+
+// UserParams are the path parameters of the routes of a user
+// UserReq creates a user of the tenant of the caller with roles
+type UserReq struct {
+	Email    string   `json:"email" validate:"required,email,max=254"`
+	Password string   `json:"password" validate:"required,min=12,max=72"`
+	Roles    []string `json:"roles" validate:"dive,required,max=64"`
+}
+
+type UserParams struct {
+	UserID string `json:"user-id" uri:"user-id" validate:"uuid"`
+}
+
+type RoleReq struct {
+	Scopes []string `json:"scopes" validate:"required,dive,oneof=company:read company:write company:delete admin"`
+}
+
+type UserRolesReq struct {
+	Roles []string `json:"roles" validate:"required,dive,required,max=64"`
+}
+
+// UserRoles is the roles of a user and the scopes they grant together
+type UserRoles struct {
+	UserID string   `json:"userId"`
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes"`
+}
+
+// APIKeyParams are the path parameters of the routes of an API key
+type APIKeyParams struct {
+	KeyID string `json:"key-id" uri:"key-id" validate:"uuid"`
+}
+
+// APIKey is an API key without its secret. Prefix is the part of the key that identifies it
+type APIKey struct {
+	ID         string     `json:"id"`
+	Prefix     string     `json:"prefix"`
+	Name       string     `json:"name"`
+	OwnerID    string     `json:"ownerId"`
+	TenantID   string     `json:"tenantId"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// APIKeyReq creates an API key. The key never expires when ExpiresAt is not set
+type APIKeyReq struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=company:read company:write company:delete admin"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// NewAPIKeyResp is a newly created API key. Key is only ever returned here
+type NewAPIKeyResp struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Types of background jobs
+const (
+	JobTypeImport = "import"
+	JobTypeExport = "export"
+	JobTypePurge  = "purge"
+)
+
+// Statuses of background jobs, a job is finished once it succeeded, failed or was cancelled
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long-running task executed by the worker pool. Progress counts the items processed
+// so far out of Total when it is known, ResultURL downloads the file a job produced
+type Job struct {
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	Status          string          `json:"status"`
+	Progress        int             `json:"progress"`
+	Total           *int            `json:"total,omitempty"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	ResultURL       string          `json:"resultUrl,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	StartedAt       *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time      `json:"finishedAt,omitempty"`
+	TenantID        string          `json:"-"`
+	OwnerID         string          `json:"-"`
+	Params          json.RawMessage `json:"-"`
+	Input           []byte          `json:"-"`
+	HasOutput       bool            `json:"-"`
+	Attempts        int             `json:"-"`
+	CancelRequested bool            `json:"-"`
+}
+
+// JobParams are the path parameters of the routes of a job
+type JobParams struct {
+	JobID string `json:"job-id" uri:"job-id" validate:"uuid"`
+}
+
+// ImportJobParams are the parameters of an import job, whose input is the uploaded file
+type ImportJobParams struct {
+	Format string `json:"format"`
+	DryRun bool   `json:"dryRun"`
+}
+
+// ExportJobParams are the parameters of an export job
+type ExportJobParams struct {
+	Format string `json:"format"`
+}
+
+// PurgeJobReq starts a job permanently deleting the companies of every tenant that were
+// soft-deleted before DeletedBefore
+type PurgeJobReq struct {
+	DeletedBefore time.Time `json:"deletedBefore" validate:"required"`
+}
+
+// Statuses of webhook deliveries, a delivery failed once its attempts are exhausted
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an HTTP endpoint the changes of the companies of a tenant are posted to. It only
+// receives the EventTypes it subscribed to, every type when empty. A webhook is disabled after
+// too many failed deliveries in a row
+type Webhook struct {
+	ID                  string     `json:"id"`
+	URL                 string     `json:"url"`
+	Description         string     `json:"description"`
+	EventTypes          []string   `json:"eventTypes"`
+	Enabled             bool       `json:"enabled"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	DisabledAt          *time.Time `json:"disabledAt,omitempty"`
+	CreatedBy           string     `json:"createdBy"`
+	CreatedAt           time.Time  `json:"createdAt"`
+	UpdatedAt           time.Time  `json:"updatedAt"`
+}
+
+// WebhookParams are the path parameters of the routes of a webhook
+type WebhookParams struct {
+	WebhookID string `json:"webhook-id" uri:"webhook-id" validate:"uuid"`
+}
+
+// WebhookReq registers a webhook
+type WebhookReq struct {
+	URL         string   `json:"url" validate:"required,url,max=2048"`
+	Description string   `json:"description" validate:"max=500"`
+	EventTypes  []string `json:"eventTypes" validate:"dive,oneof=company.created company.updated company.deleted company.restored company.purged"`
+}
+
+// WebhookUpdateReq changes the fields that are set. Enabling a webhook resets its failures
+type WebhookUpdateReq struct {
+	URL         *string   `json:"url" validate:"omitempty,url,max=2048"`
+	Description *string   `json:"description" validate:"omitempty,max=500"`
+	EventTypes  *[]string `json:"eventTypes" validate:"omitempty,dive,oneof=company.created company.updated company.deleted company.restored company.purged"`
+	Enabled     *bool     `json:"enabled"`
+}
+
+// NewWebhookResp is a newly registered webhook. Secret signs its deliveries and is only ever
+// returned here
+type NewWebhookResp struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookDelivery is an event posted, or to be posted, to a webhook. ReplayOf is the delivery
+// a replay was made from
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhookId"`
+	EventID        string          `json:"eventId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time      `json:"lastAttemptAt,omitempty"`
+	ResponseStatus *int            `json:"responseStatus,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	ReplayOf       *string         `json:"replayOf,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+}
+
+// WebhookDeliveryParams are the path parameters of the routes of a webhook delivery
+type WebhookDeliveryParams struct {
+	WebhookID  string `json:"webhook-id" uri:"webhook-id" validate:"uuid"`
+	DeliveryID string `json:"delivery-id" uri:"delivery-id" validate:"uuid"`
+}
+
+// WebhookDeliveriesReq filters the delivery log of a webhook, newest first
+type WebhookDeliveriesReq struct {
+	Status string `json:"status" form:"status" validate:"omitempty,oneof=pending succeeded failed"`
+	Limit  int    `json:"limit" form:"limit" validate:"omitempty,gte=1,lte=100"`
+}
+
+// PendingDelivery is a delivery claimed for an attempt along with where and how to send it
+type PendingDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// WebhookAttempt is the outcome of an attempt to deliver to a webhook. NextAttemptAt is when
+// to try again after a failure, nil once the attempts are exhausted
+type WebhookAttempt struct {
+	DeliveryID     string
+	WebhookID      string
+	Succeeded      bool
+	ResponseStatus *int
+	Error          string
+	NextAttemptAt  *time.Time
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	openAPIPath = "/api/v1/openapi.json"
+	apiDocsPath = "/api/v1/docs"
+)
+
+var (
+	companyFiles = openapi.File{ContentTypes: []string{"text/csv", "application/x-ndjson"}}
+	importFiles  = openapi.File{ContentTypes: []string{"multipart/form-data", "text/csv", "application/x-ndjson"}}
+	eventStream  = openapi.File{ContentTypes: []string{"text/event-stream"}}
+)
+
+// apiEndpoints documents the routes registered by newHTTPHandler, keyed by method and gin path
+var apiEndpoints = map[string]openapi.Endpoint{
+	"GET /api/v1/token": {
+		Summary:     "Generate a test token",
+		Description: "Only available when DEV_MODE is true.",
+		Tag:         "Auth",
+		Response: struct {
+			Token string `json:"token"`
+		}{},
+	},
+	"GET /.well-known/jwks.json": {
+		Summary:  "Public keys verifying access tokens",
+		Tag:      "Auth",
+		Response: helpers.JWKS{},
+	},
+	"POST /api/v1/auth/login": {
+		Summary:  "Log in",
+		Tag:      "Auth",
+		Body:     models.LoginReq{},
+		Response: models.TokenResp{},
+	},
+	"POST /api/v1/auth/refresh": {
+		Summary:  "Exchange a refresh token for a new token pair",
+		Tag:      "Auth",
+		Body:     models.RefreshReq{},
+		Response: models.TokenResp{},
+	},
+	"POST /api/v1/auth/logout": {
+		Summary:      "Log out",
+		Tag:          "Auth",
+		Auth:         openapi.AuthRequired,
+		Body:         models.LogoutReq{},
+		OptionalBody: true,
+		Status:       http.StatusNoContent,
+	},
+
+	"POST /api/v1/api-keys": {
+		Summary:  "Create an API key",
+		Tag:      "API keys",
+		Auth:     openapi.AuthRequired,
+		Body:     models.APIKeyReq{},
+		Response: models.NewAPIKeyResp{},
+	},
+	"GET /api/v1/api-keys": {
+		Summary:  "List API keys",
+		Tag:      "API keys",
+		Auth:     openapi.AuthRequired,
+		Response: openapi.List{Of: models.APIKey{}},
+	},
+	"DELETE /api/v1/api-keys/:key-id": {
+		Summary: "Revoke an API key",
+		Tag:     "API keys",
+		Auth:    openapi.AuthRequired,
+		Params:  models.APIKeyParams{},
+		Status:  http.StatusNoContent,
+	},
+
+	"GET /api/v1/company": {
+		Summary:  "List companies",
+		Tag:      "Companies",
+		Auth:     openapi.AuthOptional,
+		Scope:    helpers.ScopeCompanyRead,
+		Query:    models.CompanyListReq{},
+		Response: models.CompanyList{},
+	},
+	"GET /api/v1/company/search": {
+		Summary:  "Search companies",
+		Tag:      "Companies",
+		Auth:     openapi.AuthOptional,
+		Scope:    helpers.ScopeCompanyRead,
+		Query:    models.CompanySearchReq{},
+		Response: openapi.List{Of: models.CompanySearchResult{}},
+	},
+	"GET /api/v1/company/changes": {
+		Summary:     "Stream the changes made to the companies",
+		Description: "Server-sent events whose data is a company version and whose id resumes the feed when sent as Last-Event-ID.",
+		Tag:         "Companies",
+		Auth:        openapi.AuthRequired,
+		Scope:       helpers.ScopeCompanyRead,
+		Headers:     models.CompanyChangesHeaders{},
+		Query:       models.CompanyChangesReq{},
+		Response:    eventStream,
+	},
+	"GET /api/v1/company/:company-id": {
+		Summary:  "Get a company",
+		Tag:      "Companies",
+		Auth:     openapi.AuthOptional,
+		Scope:    helpers.ScopeCompanyRead,
+		Params:   models.CompanyParams{},
+		Headers:  models.IfNoneMatchHeaders{},
+		Query:    models.CompanyGetReq{},
+		Response: models.Company{},
+	},
+	"GET /api/v1/company/:company-id/history": {
+		Summary:  "List the versions of a company",
+		Tag:      "Companies",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeCompanyRead,
+		Params:   models.CompanyParams{},
+		Response: openapi.List{Of: models.CompanyVersion{}},
+	},
+	"POST /api/v1/company": {
+		Summary:  "Create a company",
+		Tag:      "Companies",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeCompanyWrite,
+		Headers:  models.IdempotencyHeaders{},
+		Body:     models.Company{},
+		Response: models.Company{},
+	},
+	"GET /api/v1/company/export": {
+		Summary:  "Export the companies",
+		Tag:      "Companies",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeCompanyRead,
+		Query:    models.CompanyExportReq{},
+		Response: companyFiles,
+	},
+	"POST /api/v1/company/import": {
+		Summary:  "Import companies",
+		Tag:      "Companies",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeCompanyWrite,
+		Query:    models.CompanyImportReq{},
+		Body:     importFiles,
+		Response: models.CompanyImportResult{},
+	},
+	"PATCH /api/v1/company/:company-id": {
+		Summary: "Update a company",
+		Tag:     "Companies",
+		Auth:    openapi.AuthRequired,
+		Scope:   helpers.ScopeCompanyWrite,
+		Params:  models.CompanyParams{},
+		Headers: models.IfMatchHeaders{},
+		Body:    models.CompanyUpdateReq{},
+	},
+	"DELETE /api/v1/company/:company-id": {
+		Summary: "Delete a company",
+		Tag:     "Companies",
+		Auth:    openapi.AuthRequired,
+		Scope:   helpers.ScopeCompanyDelete,
+		Params:  models.CompanyParams{},
+		Headers: models.IfMatchHeaders{},
+	},
+	"POST /api/v1/company/:company-id/restore": {
+		Summary:  "Restore a deleted company",
+		Tag:      "Companies",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeCompanyDelete,
+		Params:   models.CompanyParams{},
+		Response: models.Company{},
+	},
+	"POST /api/v1/company/:company-id/purge": {
+		Summary: "Permanently delete a company",
+		Tag:     "Companies",
+		Auth:    openapi.AuthRequired,
+		Scope:   helpers.ScopeAdmin,
+		Params:  models.CompanyParams{},
+	},
+	"POST /api/v1/company:method": {
+		Path:    "/api/v1/company:batch",
+		Summary: "Create, update and delete companies in one request",
+		Tag:     "Companies",
+		Auth:    openapi.AuthRequired,
+		Scope:   helpers.ScopeCompanyWrite,
+		Headers: models.IdempotencyHeaders{},
+		Body:    models.CompanyBatchReq{},
+		Response: struct {
+			Mode    string                      `json:"mode"`
+			Results []models.CompanyBatchResult `json:"results"`
+		}{},
+	},
+
+	"POST /api/v1/jobs/exports": {
+		Summary:  "Start an export job",
+		Tag:      "Jobs",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeCompanyRead,
+		Query:    models.CompanyExportReq{},
+		Status:   http.StatusAccepted,
+		Response: models.Job{},
+	},
+	"POST /api/v1/jobs/imports": {
+		Summary:  "Start an import job",
+		Tag:      "Jobs",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeCompanyWrite,
+		Query:    models.CompanyImportReq{},
+		Body:     importFiles,
+		Status:   http.StatusAccepted,
+		Response: models.Job{},
+	},
+	"POST /api/v1/jobs/purges": {
+		Summary:  "Start a purge job",
+		Tag:      "Jobs",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Body:     models.PurgeJobReq{},
+		Status:   http.StatusAccepted,
+		Response: models.Job{},
+	},
+	"GET /api/v1/jobs/:job-id": {
+		Summary:  "Get a job",
+		Tag:      "Jobs",
+		Auth:     openapi.AuthRequired,
+		Params:   models.JobParams{},
+		Response: models.Job{},
+	},
+	"GET /api/v1/jobs/:job-id/result": {
+		Summary:  "Download the file produced by a job",
+		Tag:      "Jobs",
+		Auth:     openapi.AuthRequired,
+		Params:   models.JobParams{},
+		Response: companyFiles,
+	},
+	"POST /api/v1/jobs/:job-id/cancel": {
+		Summary:  "Cancel a job",
+		Tag:      "Jobs",
+		Auth:     openapi.AuthRequired,
+		Params:   models.JobParams{},
+		Response: models.Job{},
+	},
+
+	"POST /api/v1/webhooks": {
+		Summary:     "Register a webhook",
+		Description: "The secret signing the deliveries is only returned here.",
+		Tag:         "Webhooks",
+		Auth:        openapi.AuthRequired,
+		Scope:       helpers.ScopeAdmin,
+		Body:        models.WebhookReq{},
+		Response:    models.NewWebhookResp{},
+	},
+	"GET /api/v1/webhooks": {
+		Summary:  "List webhooks",
+		Tag:      "Webhooks",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Response: openapi.List{Of: models.Webhook{}},
+	},
+	"GET /api/v1/webhooks/:webhook-id": {
+		Summary:  "Get a webhook",
+		Tag:      "Webhooks",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Params:   models.WebhookParams{},
+		Response: models.Webhook{},
+	},
+	"PATCH /api/v1/webhooks/:webhook-id": {
+		Summary:  "Update or re-enable a webhook",
+		Tag:      "Webhooks",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Params:   models.WebhookParams{},
+		Body:     models.WebhookUpdateReq{},
+		Response: models.Webhook{},
+	},
+	"DELETE /api/v1/webhooks/:webhook-id": {
+		Summary: "Delete a webhook",
+		Tag:     "Webhooks",
+		Auth:    openapi.AuthRequired,
+		Scope:   helpers.ScopeAdmin,
+		Params:  models.WebhookParams{},
+		Status:  http.StatusNoContent,
+	},
+	"GET /api/v1/webhooks/:webhook-id/deliveries": {
+		Summary:  "List the deliveries of a webhook",
+		Tag:      "Webhooks",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Params:   models.WebhookParams{},
+		Query:    models.WebhookDeliveriesReq{},
+		Response: openapi.List{Of: models.WebhookDelivery{}},
+	},
+	"POST /api/v1/webhooks/:webhook-id/deliveries/:delivery-id/replay": {
+		Summary:  "Send a delivery again",
+		Tag:      "Webhooks",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Params:   models.WebhookDeliveryParams{},
+		Status:   http.StatusAccepted,
+		Response: models.WebhookDelivery{},
+	},
+
+	"POST /api/v1/admin/users": {
+		Summary:     "Create a user",
+		Description: "The user belongs to the tenant of the caller and logs in with the email and the password.",
+		Tag:         "Admin",
+		Auth:        openapi.AuthRequired,
+		Scope:       helpers.ScopeAdmin,
+		Body:        models.UserReq{},
+		Response:    models.User{},
+	},
+	"POST /api/v1/admin/users/:user-id/revoke-tokens": {
+		Summary: "Revoke every token of a user",
+		Tag:     "Admin",
+		Auth:    openapi.AuthRequired,
+		Scope:   helpers.ScopeAdmin,
+		Params:  models.UserParams{},
+		Status:  http.StatusNoContent,
+	},
+	"GET /api/v1/admin/users/:user-id/roles": {
+		Summary:  "Get the roles of a user",
+		Tag:      "Admin",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Params:   models.UserParams{},
+		Response: models.UserRoles{},
+	},
+	"PUT /api/v1/admin/users/:user-id/roles": {
+		Summary:  "Replace the roles of a user",
+		Tag:      "Admin",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Params:   models.UserParams{},
+		Body:     models.UserRolesReq{},
+		Response: models.UserRoles{},
+	},
+	"GET /api/v1/admin/roles": {
+		Summary:  "List roles",
+		Tag:      "Admin",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Response: openapi.List{Of: models.Role{}},
+	},
+	"PUT /api/v1/admin/roles/:role": {
+		Summary:  "Create or replace a role",
+		Tag:      "Admin",
+		Auth:     openapi.AuthRequired,
+		Scope:    helpers.ScopeAdmin,
+		Params:   models.RoleParams{},
+		Body:     models.RoleReq{},
+		Response: models.Role{},
+	},
+	"DELETE /api/v1/admin/roles/:role": {
+		Summary: "Delete a role",
+		Tag:     "Admin",
+		Auth:    openapi.AuthRequired,
+		Scope:   helpers.ScopeAdmin,
+		Params:  models.RoleParams{},
+		Status:  http.StatusNoContent,
+	},
+}
+
+// newOpenAPIDocument documents routes with apiEndpoints, it also returns the routes missing
+// from apiEndpoints
+func newOpenAPIDocument(routes gin.RoutesInfo) (doc openapi.Document, undocumented []string) {
+	b := openapi.NewBuilder("XM companies API", "1.0.0")
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		endpoint, ok := apiEndpoints[key]
+		if !ok {
+			undocumented = append(undocumented, key)
+			continue
+		}
+		b.Add(route.Method, route.Path, endpoint)
+	}
+	return b.Document(), undocumented
+}
+
+// serveOpenAPI serves the document of the routes registered on engine so far and a
+// Swagger UI page rendering it
+func serveOpenAPI(engine *gin.Engine) {
+	doc, undocumented := newOpenAPIDocument(engine.Routes())
+	for _, route := range undocumented {
+		log.Println("openapi: route is not documented:", route)
+	}
+
+	engine.GET(openAPIPath, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, doc)
+	})
+	engine.GET(apiDocsPath, func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(openapi.UI(doc.Info.Title, openAPIPath)))
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/pkg/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+func TestOpenAPI(t *testing.T) {
+
+	t.Run("Spec matches routes", func(t *testing.T) {
+		assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:"+openAPIPath, nil)
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+		require.Equal(200, resp.StatusCode)
+
+		var doc openapi.Document
+		require.NoError(json.NewDecoder(resp.Body).Decode(&doc))
+
+		var documented []string
+		for path, item := range doc.Paths {
+			for method := range item {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+
+		var routed []string
+		routes := map[string]bool{}
+		for _, route := range testHTTPHandler.(*gin.Engine).Routes() {
+			if route.Path == openAPIPath || route.Path == apiDocsPath {
+				continue
+			}
+			key := route.Method + " " + route.Path
+			routes[key] = true
+
+			endpoint, ok := apiEndpoints[key]
+			if !assert.True(ok, "route %s is not documented", key) {
+				continue
+			}
+			path := route.Path
+			if endpoint.Path != "" {
+				path = endpoint.Path
+			}
+			routed = append(routed, route.Method+" "+openapi.Path(path))
+		}
+		assert.ElementsMatch(routed, documented)
+
+		for key := range apiEndpoints {
+			if key == "GET /api/v1/token" && !cfg.DevMode {
+				continue
+			}
+			assert.True(routes[key], "documented route %s is not registered", key)
+		}
+	})
+
+	t.Run("Company schema", func(t *testing.T) {
+		assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:"+openAPIPath, nil)
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		var doc struct {
+			Components struct {
+				Schemas map[string]json.RawMessage `json:"schemas"`
+			} `json:"components"`
+		}
+		require.NoError(json.NewDecoder(w.Result().Body).Decode(&doc))
+
+		assert.JSONEq(`{
+			"type": "object",
+			"properties": {
+				"id": {"type": "string"},
+				"name": {"type": "string", "maxLength": 15},
+				"description": {"type": "string", "maxLength": 3000},
+				"amountOfEmployees": {"type": "integer", "format": "int64", "minimum": 0},
+				"registered": {"type": "boolean"},
+				"companyType": {"type": "string", "enum": ["Corporations", "Non Profit", "Cooperative", "Sole Proprietorship"]},
+				"deletedAt": {"type": "string", "format": "date-time"}
+			},
+			"required": ["companyType", "name"]
+		}`, string(doc.Components.Schemas["Company"]))
+	})
+
+	t.Run("Swagger UI", func(t *testing.T) {
+		assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:"+apiDocsPath, nil)
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+		assert.Equal(200, resp.StatusCode)
+		assert.Equal("text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Contains(string(body), `url: "`+openAPIPath+`"`)
+		}
+	})
+}
+
+func TestRequestValidation(t *testing.T) {
+
+	t.Run("Invalid company ID", func(t *testing.T) {
+		assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/company/not-a-uuid", nil)
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"type":"about:blank","title":"Not Found","status":404,"detail":"no record found","instance":"/api/v1/company/not-a-uuid","errors":[{"field":"company-id","rule":"uuid","message":"company-id must be a UUID"}]}`, string(body))
+		}
+
+		assert.Equal(404, resp.StatusCode)
+		assert.Equal(helpers.ProblemContentType, resp.Header.Get("Content-Type"))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid point in time", func(t *testing.T) {
+		assert, _, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b?asOf=yesterday", nil)
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"invalid parameters","instance":"/api/v1/company/ae17b2e2-6b87-4c5b-9c94-3623dacf113b","errors":[{"field":"asOf","rule":"datetime","param":"2006-01-02T15:04:05Z07:00","message":"asOf must be an RFC 3339 timestamp"}]}`, string(body))
+		}
+
+		assert.Equal(422, resp.StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Authentication comes first", func(t *testing.T) {
+		assert, _, _, testHTTPHandler := newTestHTTPHandler(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PATCH", "http:/api/v1/company/not-a-uuid", strings.NewReader(`{"amountOfEmployees":-1}`))
+		r.Header.Set("Content-Type", "application/json")
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"error":"unauthorized"}`, string(body))
+		}
+
+		assert.Equal(401, resp.StatusCode)
+	})
+
+	t.Run("Role name too long", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+		require.NoError(err)
+
+		role := strings.Repeat("r", 65)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", "http:/api/v1/admin/roles/"+role, strings.NewReader(`{"scopes":["company:read"]}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		if body, err := io.ReadAll(resp.Body); assert.NoError(err) {
+			assert.Equal(`{"type":"about:blank","title":"Not Found","status":404,"detail":"no record found","instance":"/api/v1/admin/roles/`+role+`","errors":[{"field":"role","rule":"max","param":"64","message":"role must be at most 64 characters"}]}`, string(body))
+		}
+
+		assert.Equal(404, resp.StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
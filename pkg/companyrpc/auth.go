@@ -0,0 +1,132 @@
+package companyrpc
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/danielboakye/go-xm/helpers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	authorizationMetadataKey = "authorization"
+	authorizationScheme      = "Bearer"
+)
+
+// methodScopes are the scopes required by the methods of CompanyService. Methods missing from
+// it, such as health checks and reflection, are served without authentication
+var methodScopes = map[string]string{
+	"/company.v1.CompanyService/CreateCompany":  helpers.ScopeCompanyWrite,
+	"/company.v1.CompanyService/GetCompany":     helpers.ScopeCompanyRead,
+	"/company.v1.CompanyService/UpdateCompany":  helpers.ScopeCompanyWrite,
+	"/company.v1.CompanyService/DeleteCompany":  helpers.ScopeCompanyDelete,
+	"/company.v1.CompanyService/ListCompanies":  helpers.ScopeCompanyRead,
+	"/company.v1.CompanyService/WatchCompanies": helpers.ScopeCompanyRead,
+}
+
+type identityKey struct{}
+
+// identity is the authenticated caller of a method
+type identity struct {
+	userID   string
+	tenantID string
+}
+
+// callerFromContext returns the caller set in ctx by the interceptors
+func callerFromContext(ctx context.Context) identity {
+	caller, _ := ctx.Value(identityKey{}).(identity)
+	return caller
+}
+
+// unaryInterceptor authenticates the calls of unary methods
+func (s *Server) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamInterceptor authenticates the calls of streaming methods
+func (s *Server) streamInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+
+	ctx, err := s.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate validates the access token of the call and returns its context with the caller
+// set. Like the HTTP API the token must not be revoked and must grant the scope of the method,
+// tokens without a tenant belong to the default tenant
+func (s *Server) authenticate(ctx context.Context, method string) (context.Context, error) {
+	scope, ok := methodScopes[method]
+	if !ok {
+		return ctx, nil
+	}
+
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(authorizationMetadataKey); len(values) > 0 {
+			token = strings.TrimSpace(strings.Replace(values[0], authorizationScheme, "", 1))
+		}
+	}
+	if token == "" {
+		return nil, statusFromErr(helpers.ErrUnauthorized)
+	}
+
+	claims, err := helpers.ValidateAccessToken(token, s.cfg)
+	if err != nil {
+		return nil, statusFromErr(helpers.ErrUnauthorized)
+	}
+
+	revoked, err := s.revocations.IsRevoked(ctx, claims)
+	if err != nil {
+		log.Println(err)
+		return nil, statusFromErr(helpers.ErrProcessingFailed)
+	}
+	if revoked {
+		return nil, statusFromErr(helpers.ErrUnauthorized)
+	}
+
+	if !claims.HasScope(scope) {
+		return nil, statusFromErr(helpers.ErrInsufficientScope)
+	}
+
+	tenantID := claims.TenantID
+	if tenantID == "" {
+		tenantID = s.cfg.DefaultTenantID
+	}
+
+	return context.WithValue(ctx, identityKey{}, identity{userID: claims.UserID, tenantID: tenantID}), nil
+}
+
+// serverStream overrides the context of a stream with the authenticated one
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// statusFromErr returns the status of a helpers error
+func statusFromErr(err error) error {
+	return status.Error(helpers.GetGrpcCodeByErr(err), err.Error())
+}
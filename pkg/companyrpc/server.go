@@ -0,0 +1,443 @@
+package companyrpc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/companypb"
+	"github.com/danielboakye/go-xm/pkg/revocation"
+	"github.com/danielboakye/go-xm/repo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// watchBatchSize is the most changes WatchCompanies reads per query
+const watchBatchSize = 100
+
+// Server serves CompanyService over gRPC along with health checking and reflection. It
+// authenticates calls with the access tokens of the HTTP API
+type Server struct {
+	companypb.UnimplementedCompanyServiceServer
+
+	r            repo.IRepository
+	v            *helpers.Validation
+	revocations  revocation.IStore
+	cfg          config.Configurations
+	grpcServer   *grpc.Server
+	healthServer *health.Server
+	stopping     chan struct{}
+}
+
+func NewServer(
+	r repo.IRepository,
+	v *helpers.Validation,
+	revocations revocation.IStore,
+	cfg config.Configurations,
+) *Server {
+	s := &Server{
+		r:            r,
+		v:            v,
+		revocations:  revocations,
+		cfg:          cfg,
+		healthServer: health.NewServer(),
+		stopping:     make(chan struct{}),
+	}
+
+	s.grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(s.unaryInterceptor),
+		grpc.StreamInterceptor(s.streamInterceptor),
+	)
+	companypb.RegisterCompanyServiceServer(s.grpcServer, s)
+	healthpb.RegisterHealthServer(s.grpcServer, s.healthServer)
+	reflection.Register(s.grpcServer)
+
+	return s
+}
+
+// Run serves on the gRPC port until ctx is cancelled, then ends the watches and stops
+// gracefully, letting the unary calls in flight finish
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", ":"+s.cfg.GRPCPort)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.healthServer.Shutdown()
+		close(s.stopping)
+		s.grpcServer.GracefulStop()
+	}()
+
+	return s.Serve(listener)
+}
+
+// Serve serves on listener until the server is stopped
+func (s *Server) Serve(listener net.Listener) error {
+	s.healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	s.healthServer.SetServingStatus(companypb.CompanyService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	err := s.grpcServer.Serve(listener)
+	if err == grpc.ErrServerStopped {
+		err = nil
+	}
+	return err
+}
+
+func (s *Server) companyExists(ctx context.Context, tenantID string, companyName string) (exists bool, err error) {
+	_, err = s.r.GetCompanyByName(ctx, tenantID, companyName)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	}
+
+	exists = err == nil
+	return
+}
+
+func (s *Server) CreateCompany(ctx context.Context, req *companypb.CreateCompanyRequest) (*companypb.Company, error) {
+	caller := callerFromContext(ctx)
+
+	company := models.Company{
+		Name:              req.GetName(),
+		Description:       req.GetDescription(),
+		AmountOfEmployees: req.GetAmountOfEmployees(),
+		Registered:        req.GetRegistered(),
+		CompanyType:       req.GetCompanyType(),
+	}
+	if err := s.v.ValidateForm(company); err != nil {
+		return nil, validationStatus(err)
+	}
+
+	exists, err := s.companyExists(ctx, caller.tenantID, company.Name)
+	if err != nil {
+		log.Println(err)
+		return nil, statusFromErr(helpers.ErrProcessingFailed)
+	}
+	if exists {
+		return nil, statusFromErr(helpers.ErrDuplicateRecord)
+	}
+
+	company.ID, err = s.r.CreateCompany(
+		ctx,
+		caller.tenantID,
+		caller.userID,
+		company.Name,
+		company.Description,
+		company.AmountOfEmployees,
+		company.Registered,
+		company.CompanyType,
+	)
+	if err != nil {
+		if err == repo.ErrDuplicateName {
+			err = helpers.ErrDuplicateRecord
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		return nil, statusFromErr(err)
+	}
+	company.Version = 1
+
+	return toProto(company), nil
+}
+
+func (s *Server) GetCompany(ctx context.Context, req *companypb.GetCompanyRequest) (*companypb.Company, error) {
+	caller := callerFromContext(ctx)
+
+	if err := s.validateCompanyID(req.GetId()); err != nil {
+		return nil, err
+	}
+
+	company, err := s.r.GetCompanyByID(ctx, caller.tenantID, req.GetId())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		return nil, statusFromErr(err)
+	}
+
+	return toProto(company), nil
+}
+
+func (s *Server) UpdateCompany(ctx context.Context, req *companypb.UpdateCompanyRequest) (*companypb.Company, error) {
+	caller := callerFromContext(ctx)
+
+	if err := s.validateCompanyID(req.GetId()); err != nil {
+		return nil, err
+	}
+
+	update := models.CompanyUpdateReq{
+		Name:              req.Name,
+		Description:       req.Description,
+		AmountOfEmployees: req.AmountOfEmployees,
+		Registered:        req.Registered,
+		CompanyType:       req.CompanyType,
+	}
+	if err := s.v.ValidateForm(update); err != nil {
+		return nil, validationStatus(err)
+	}
+
+	current, err := s.r.GetCompanyByID(ctx, caller.tenantID, req.GetId())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		return nil, statusFromErr(err)
+	}
+
+	if update.Name != nil && *update.Name != current.Name {
+		exists, err := s.companyExists(ctx, caller.tenantID, *update.Name)
+		if err != nil {
+			log.Println(err)
+			return nil, statusFromErr(helpers.ErrProcessingFailed)
+		}
+		if exists {
+			return nil, statusFromErr(helpers.ErrDuplicateRecord)
+		}
+	}
+
+	version, err := s.r.UpdateCompany(
+		ctx,
+		caller.tenantID,
+		caller.userID,
+		req.GetId(),
+		req.ExpectedVersion,
+		update.Name,
+		update.Description,
+		update.AmountOfEmployees,
+		update.Registered,
+		update.CompanyType,
+	)
+	if err != nil {
+		if err == repo.ErrDuplicateName {
+			err = helpers.ErrDuplicateRecord
+		} else if err == repo.ErrVersionMismatch {
+			err = helpers.ErrPreconditionFailed
+		} else if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		return nil, statusFromErr(err)
+	}
+
+	updated := current
+	if update.Name != nil {
+		updated.Name = *update.Name
+	}
+	if update.Description != nil {
+		updated.Description = *update.Description
+	}
+	if update.AmountOfEmployees != nil {
+		updated.AmountOfEmployees = *update.AmountOfEmployees
+	}
+	if update.Registered != nil {
+		updated.Registered = *update.Registered
+	}
+	if update.CompanyType != nil {
+		updated.CompanyType = *update.CompanyType
+	}
+	updated.Version = version
+
+	return toProto(updated), nil
+}
+
+func (s *Server) DeleteCompany(ctx context.Context, req *companypb.DeleteCompanyRequest) (*companypb.DeleteCompanyResponse, error) {
+	caller := callerFromContext(ctx)
+
+	if err := s.validateCompanyID(req.GetId()); err != nil {
+		return nil, err
+	}
+
+	err := s.r.DeleteCompany(ctx, caller.tenantID, caller.userID, req.GetId(), req.ExpectedVersion)
+	if err != nil {
+		if err == repo.ErrVersionMismatch {
+			err = helpers.ErrPreconditionFailed
+		} else if err == sql.ErrNoRows {
+			err = helpers.ErrNoRecordFound
+		} else {
+			log.Println(err)
+			err = helpers.ErrProcessingFailed
+		}
+		return nil, statusFromErr(err)
+	}
+
+	return &companypb.DeleteCompanyResponse{}, nil
+}
+
+func (s *Server) ListCompanies(ctx context.Context, req *companypb.ListCompaniesRequest) (*companypb.ListCompaniesResponse, error) {
+	caller := callerFromContext(ctx)
+
+	filter := models.CompanyListReq{
+		CompanyType:  req.CompanyType,
+		Registered:   req.Registered,
+		MinEmployees: req.MinEmployees,
+		MaxEmployees: req.MaxEmployees,
+		NamePrefix:   req.NamePrefix,
+		Sort:         req.GetSort(),
+		Limit:        int(req.GetPageSize()),
+		Cursor:       req.GetPageToken(),
+	}
+	if err := s.v.ValidateForm(filter); err != nil {
+		return nil, validationStatus(err)
+	}
+
+	companies, nextCursor, err := s.r.ListCompanies(ctx, caller.tenantID, filter)
+	if err != nil {
+		switch err {
+		case repo.ErrInvalidCursor:
+			return nil, validationStatus(helpers.NewValidationError(helpers.FieldError{
+				Field:   "page_token",
+				Rule:    "cursor",
+				Message: "page_token is not a page token of this listing",
+			}))
+		case repo.ErrInvalidSort:
+			return nil, validationStatus(helpers.NewValidationError(helpers.FieldError{
+				Field:   "sort",
+				Rule:    "oneof",
+				Message: "sort is not a sortable field",
+			}))
+		}
+
+		log.Println(err)
+		return nil, statusFromErr(helpers.ErrProcessingFailed)
+	}
+
+	resp := &companypb.ListCompaniesResponse{NextPageToken: nextCursor}
+	for _, company := range companies {
+		resp.Companies = append(resp.Companies, toProto(company))
+	}
+	return resp, nil
+}
+
+// WatchCompanies polls the history of the companies of the tenant for changes after the
+// requested one and streams them in the order of the transactions that made them, each once
+// every older transaction has finished so that a slow one is not skipped. The stream ends with
+// UNAVAILABLE when the server stops, clients resume with the id of the last change they
+// received, which is not the greatest one
+func (s *Server) WatchCompanies(req *companypb.WatchCompaniesRequest, stream companypb.CompanyService_WatchCompaniesServer) error {
+	ctx := stream.Context()
+	caller := callerFromContext(ctx)
+
+	afterID := req.GetAfterChangeId()
+	if afterID == 0 {
+		latestID, err := s.r.GetLatestCompanyChangeID(ctx, caller.tenantID)
+		if err != nil {
+			log.Println(err)
+			return statusFromErr(helpers.ErrProcessingFailed)
+		}
+		afterID = latestID
+	}
+
+	ticker := time.NewTicker(s.cfg.WatchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			changes, err := s.r.ListCompanyChanges(ctx, caller.tenantID, afterID, watchBatchSize)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				log.Println(err)
+				return statusFromErr(helpers.ErrProcessingFailed)
+			}
+
+			for _, change := range changes {
+				if err := stream.Send(toProtoEvent(change)); err != nil {
+					return err
+				}
+				afterID = change.HistoryID
+			}
+
+			if len(changes) < watchBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.stopping:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case <-ticker.C:
+		}
+	}
+}
+
+// validateCompanyID answers ids that cannot be a company with NOT_FOUND, like the HTTP API
+func (s *Server) validateCompanyID(companyID string) error {
+	if err := s.v.ValidateForm(models.CompanyParams{CompanyID: companyID}); err != nil {
+		return statusFromErr(helpers.ErrNoRecordFound)
+	}
+	return nil
+}
+
+// validationStatus returns an INVALID_ARGUMENT status detailing the invalid fields of a
+// *helpers.ValidationError
+func validationStatus(err error) error {
+	var verr *helpers.ValidationError
+	if !errors.As(err, &verr) {
+		verr = helpers.NewValidationError()
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, e := range verr.Errors {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       e.Field,
+			Description: e.Message,
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, helpers.ErrInvalidParameters.Error())
+	if detailed, err := st.WithDetails(badRequest); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func toProto(company models.Company) *companypb.Company {
+	return &companypb.Company{
+		Id:                company.ID,
+		Name:              company.Name,
+		Description:       company.Description,
+		AmountOfEmployees: company.AmountOfEmployees,
+		Registered:        company.Registered,
+		CompanyType:       company.CompanyType,
+		Version:           company.Version,
+	}
+}
+
+func toProtoEvent(change models.CompanyVersion) *companypb.CompanyEvent {
+	company := change.Company
+	company.Version = change.Version
+
+	return &companypb.CompanyEvent{
+		ChangeId:  change.HistoryID,
+		Operation: change.Operation,
+		Company:   toProto(company),
+		ChangedBy: change.ChangedBy,
+		ChangedAt: timestamppb.New(change.ChangedAt),
+	}
+}
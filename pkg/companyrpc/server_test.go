@@ -0,0 +1,273 @@
+package companyrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/pkg/companypb"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	testUserID    = "af7c1fe6-d669-414e-b066-e9733f0de7a8"
+	testCompanyID = "c0ffee00-0000-4000-8000-00000000c0de"
+	testTenantID  = "acme"
+)
+
+var cfg = config.Configurations{
+	AccessTokenDuration: time.Hour,
+	JWTSecretKey:        "91ODUHa4O0wRJCbeCXK4igB4ny/JnFH4jJiMmjf5loo=",
+	JWTSigningMethod:    config.SigningMethodHS256,
+	DefaultTenantID:     "default",
+	WatchPollInterval:   time.Hour,
+}
+
+const getCompanyByIDQuery = `
+			SELECT
+				company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+			FROM companies
+			WHERE company_id = $1
+				AND tenant_id = $2
+				AND deleted_at IS NULL
+		`
+
+const listCompanyChangesQuery = `
+			SELECT
+				history_id, version, operation, company_id, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by, changed_at
+			FROM companies_history
+			WHERE tenant_id = $1
+				AND tx_id < pg_snapshot_xmin(pg_current_snapshot())
+				AND (
+					(tx_id, history_id) > ((SELECT tx_id FROM companies_history WHERE history_id = $2), $2)
+					OR (history_id > $2 AND NOT EXISTS (SELECT 1 FROM companies_history WHERE history_id = $2))
+				)
+			ORDER BY tx_id, history_id
+			LIMIT $3
+		`
+
+// testRevocations is a revocation.IStore that revokes nothing
+type testRevocations struct{}
+
+func (testRevocations) IsRevoked(ctx context.Context, claims *helpers.JWTClaims) (bool, error) {
+	return false, nil
+}
+
+func (testRevocations) RevokeToken(ctx context.Context, claims *helpers.JWTClaims) error {
+	return nil
+}
+
+func (testRevocations) RevokeUserTokens(ctx context.Context, userID string) error {
+	return nil
+}
+
+// newTestServer serves a Server backed by sqlmock over an in-memory listener and returns a
+// connection to it
+func newTestServer(t *testing.T) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, *grpc.ClientConn) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, mockDB := dbtest.New(t)
+
+	v, err := helpers.NewValidation()
+	require.NoError(err)
+
+	s := NewServer(repo.NewRepository(db), v, testRevocations{}, cfg)
+
+	listener := bufconn.Listen(1024 * 1024)
+	go s.Serve(listener)
+	t.Cleanup(s.grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(err)
+	t.Cleanup(func() { conn.Close() })
+
+	return assert, require, mockDB, conn
+}
+
+// withToken returns a context sending an access token granting scopes within the test tenant
+func withToken(t *testing.T, scopes ...string) context.Context {
+	token, err := helpers.GenerateAccessToken(cfg, helpers.Identity{
+		UserID:   testUserID,
+		TenantID: testTenantID,
+		Scopes:   scopes,
+	})
+	require.NoError(t, err)
+
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestCompanyService(t *testing.T) {
+
+	t.Run("Missing token", func(t *testing.T) {
+		assert, _, _, conn := newTestServer(t)
+
+		_, err := companypb.NewCompanyServiceClient(conn).GetCompany(context.Background(), &companypb.GetCompanyRequest{Id: testCompanyID})
+		assert.Equal(codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("Insufficient scope", func(t *testing.T) {
+		assert, _, _, conn := newTestServer(t)
+
+		_, err := companypb.NewCompanyServiceClient(conn).CreateCompany(
+			withToken(t, helpers.ScopeCompanyRead),
+			&companypb.CreateCompanyRequest{Name: "XM", CompanyType: "Corporations"},
+		)
+		assert.Equal(codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("Get company", func(t *testing.T) {
+		assert, require, mockDB, conn := newTestServer(t)
+
+		mockDB.ExpectQuery(getCompanyByIDQuery).
+			WithArgs(testCompanyID, testTenantID).
+			WillReturnRows(sqlmock.NewRows([]string{"company_id", "company_name", "description", "amount_of_employees", "is_registered", "company_type", "version"}).
+				AddRow(testCompanyID, "XM", "broker", 50, true, "Corporations", 3))
+
+		company, err := companypb.NewCompanyServiceClient(conn).GetCompany(
+			withToken(t, helpers.ScopeCompanyRead),
+			&companypb.GetCompanyRequest{Id: testCompanyID},
+		)
+		require.NoError(err)
+		assert.Equal("XM", company.Name)
+		assert.Equal(int64(50), company.AmountOfEmployees)
+		assert.Equal(int64(3), company.Version)
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Get missing company", func(t *testing.T) {
+		assert, _, mockDB, conn := newTestServer(t)
+
+		mockDB.ExpectQuery(getCompanyByIDQuery).
+			WithArgs(testCompanyID, testTenantID).
+			WillReturnRows(sqlmock.NewRows([]string{"company_id"}))
+
+		_, err := companypb.NewCompanyServiceClient(conn).GetCompany(
+			withToken(t, helpers.ScopeCompanyRead),
+			&companypb.GetCompanyRequest{Id: testCompanyID},
+		)
+		assert.Equal(codes.NotFound, status.Code(err))
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid company id", func(t *testing.T) {
+		assert, _, _, conn := newTestServer(t)
+
+		_, err := companypb.NewCompanyServiceClient(conn).GetCompany(
+			withToken(t, helpers.ScopeCompanyRead),
+			&companypb.GetCompanyRequest{Id: "not-a-uuid"},
+		)
+		assert.Equal(codes.NotFound, status.Code(err))
+	})
+
+	t.Run("Create invalid company", func(t *testing.T) {
+		assert, require, _, conn := newTestServer(t)
+
+		_, err := companypb.NewCompanyServiceClient(conn).CreateCompany(
+			withToken(t, helpers.ScopeCompanyWrite),
+			&companypb.CreateCompanyRequest{Name: "XM", CompanyType: "Partnership"},
+		)
+		st := status.Convert(err)
+		assert.Equal(codes.InvalidArgument, st.Code())
+
+		require.Len(st.Details(), 1)
+		badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+		require.True(ok)
+		require.Len(badRequest.FieldViolations, 1)
+		assert.Equal("companyType", badRequest.FieldViolations[0].Field)
+	})
+
+	t.Run("Watch companies", func(t *testing.T) {
+		assert, require, mockDB, conn := newTestServer(t)
+
+		changedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+		mockDB.ExpectQuery(listCompanyChangesQuery).
+			WithArgs(testTenantID, 41, watchBatchSize).
+			WillReturnRows(sqlmock.NewRows([]string{"history_id", "version", "operation", "company_id", "company_name", "description", "amount_of_employees", "is_registered", "company_type", "diff", "changed_by", "changed_at"}).
+				AddRow(42, 2, "updated", testCompanyID, "XM", "broker", 50, true, "Corporations", []byte(`{"amountOfEmployees":{"from":40,"to":50}}`), testUserID, changedAt))
+
+		ctx, cancel := context.WithCancel(withToken(t, helpers.ScopeCompanyRead))
+		defer cancel()
+
+		stream, err := companypb.NewCompanyServiceClient(conn).WatchCompanies(ctx, &companypb.WatchCompaniesRequest{AfterChangeId: 41})
+		require.NoError(err)
+
+		event, err := stream.Recv()
+		require.NoError(err)
+		assert.Equal(int64(42), event.ChangeId)
+		assert.Equal("updated", event.Operation)
+		assert.Equal(int64(2), event.Company.Version)
+		assert.Equal(testUserID, event.ChangedBy)
+		assert.True(changedAt.Equal(event.ChangedAt.AsTime()))
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Watch resumes after the last change streamed", func(t *testing.T) {
+		assert, require, mockDB, conn := newTestServer(t)
+
+		// a full batch in transaction order, whose history ids decrease
+		changedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+		rows := sqlmock.NewRows([]string{"history_id", "version", "operation", "company_id", "company_name", "description", "amount_of_employees", "is_registered", "company_type", "diff", "changed_by", "changed_at"})
+		for i := 0; i < watchBatchSize; i++ {
+			rows.AddRow(141-i, 2, "updated", testCompanyID, "XM", "broker", 50, true, "Corporations", []byte(`{}`), testUserID, changedAt)
+		}
+		mockDB.ExpectQuery(listCompanyChangesQuery).
+			WithArgs(testTenantID, 41, watchBatchSize).
+			WillReturnRows(rows)
+		mockDB.ExpectQuery(listCompanyChangesQuery).
+			WithArgs(testTenantID, 42, watchBatchSize).
+			WillReturnRows(sqlmock.NewRows([]string{"history_id", "version", "operation", "company_id", "company_name", "description", "amount_of_employees", "is_registered", "company_type", "diff", "changed_by", "changed_at"}))
+
+		ctx, cancel := context.WithCancel(withToken(t, helpers.ScopeCompanyRead))
+		defer cancel()
+
+		stream, err := companypb.NewCompanyServiceClient(conn).WatchCompanies(ctx, &companypb.WatchCompaniesRequest{AfterChangeId: 41})
+		require.NoError(err)
+
+		for i := 0; i < watchBatchSize; i++ {
+			event, err := stream.Recv()
+			require.NoError(err)
+			assert.Equal(int64(141-i), event.ChangeId)
+		}
+		assert.Eventually(func() bool { return mockDB.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Health check without token", func(t *testing.T) {
+		assert, require, _, conn := newTestServer(t)
+
+		resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{
+			Service: companypb.CompanyService_ServiceDesc.ServiceName,
+		})
+		require.NoError(err)
+		assert.Equal(healthpb.HealthCheckResponse_SERVING, resp.Status)
+	})
+}
+
+func TestGetGrpcCodeByErr(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(codes.Unauthenticated, helpers.GetGrpcCodeByErr(helpers.ErrExpiredToken))
+	assert.Equal(codes.PermissionDenied, helpers.GetGrpcCodeByErr(helpers.ErrInsufficientScope))
+	assert.Equal(codes.AlreadyExists, helpers.GetGrpcCodeByErr(helpers.ErrDuplicateRecord))
+	assert.Equal(codes.Aborted, helpers.GetGrpcCodeByErr(helpers.ErrPreconditionFailed))
+	assert.Equal(codes.Internal, helpers.GetGrpcCodeByErr(helpers.ErrProcessingFailed))
+}
@@ -0,0 +1,25 @@
+// Package dbtest sets up the mocked database the tests of the repository and of its callers
+// run against
+package dbtest
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// New returns a database whose queries must equal, up to whitespace, the ones expected on the
+// returned mock. It is closed when the test ends
+func New(t testing.TB) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("dbtest: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db, mock
+}
@@ -0,0 +1,223 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/helpers/consts"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// minRetryBackoff is the wait before retrying a message that failed for a transient reason
+	minRetryBackoff = time.Second
+	// maxRetryBackoff bounds the wait between retries of a message
+	maxRetryBackoff = time.Minute
+)
+
+// Headers added to a message parked on the dead-letter topic
+const (
+	HeaderDeadLetterReason = "dead-letter-reason"
+	HeaderSourceTopic      = "source-topic"
+	HeaderSourcePartition  = "source-partition"
+	HeaderSourceOffset     = "source-offset"
+)
+
+// MessageReader is the part of *kafka.Reader the consumer uses
+type MessageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// MessageWriter is the part of *kafka.Writer the consumer uses
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Consumer upserts the companies published by the master data system on the ingest topic, as
+// a member of the ingest consumer group. A message that can never be applied, because it is
+// not a valid company record, names an unknown tenant, conflicts with another company or is
+// refused by a database constraint, is parked on the dead-letter topic. The offset of a message is committed only once it is written to the database or to
+// the dead-letter topic, any other failure is retried with backoff, so every message is
+// applied at least once
+type Consumer struct {
+	r           repo.IRepository
+	v           *helpers.Validation
+	reader      MessageReader
+	deadLetters MessageWriter
+	tenantID    string
+}
+
+// NewConsumer returns a Consumer of KAFKA_INGEST_TOPIC, which does nothing when it is unset
+func NewConsumer(r repo.IRepository, v *helpers.Validation, cfg config.Configurations) *Consumer {
+	c := &Consumer{r: r, v: v, tenantID: cfg.DefaultTenantID}
+	if cfg.KafkaIngestTopic == "" {
+		return c
+	}
+
+	brokers := strings.Split(cfg.KafkaURL, ",")
+	c.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		GroupID: cfg.KafkaIngestGroup,
+		Topic:   cfg.KafkaIngestTopic,
+		// offsets are committed explicitly, once a message is applied
+		CommitInterval: 0,
+	})
+	c.deadLetters = &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  cfg.KafkaDeadLetterTopic,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		MaxAttempts:            3,
+		AllowAutoTopicCreation: true,
+	}
+	return c
+}
+
+// Run consumes messages until ctx is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	if c.reader == nil {
+		return nil
+	}
+	defer c.deadLetters.Close()
+	defer c.reader.Close()
+
+	backoff := minRetryBackoff
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Println("ingest:", err)
+			if !wait(ctx, &backoff) {
+				return nil
+			}
+			continue
+		}
+		backoff = minRetryBackoff
+
+		if err = c.Process(ctx, msg); err != nil {
+			// only returned once ctx is cancelled, the message is read again on restart
+			return nil
+		}
+	}
+}
+
+// Process handles a message and commits its offset, retrying with backoff until both succeed.
+// It only fails when ctx is cancelled
+func (c *Consumer) Process(ctx context.Context, msg kafka.Message) error {
+	backoff := minRetryBackoff
+	for {
+		err := c.Handle(ctx, msg)
+		if err == nil {
+			err = c.reader.CommitMessages(ctx, msg)
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Printf("ingest: partition %d offset %d: %v", msg.Partition, msg.Offset, err)
+		if !wait(ctx, &backoff) {
+			return ctx.Err()
+		}
+	}
+}
+
+// Handle upserts the company record of a message, or parks the message on the dead-letter
+// topic when it can never be applied. It fails when the message must be retried
+func (c *Consumer) Handle(ctx context.Context, msg kafka.Message) error {
+	reason, err := c.apply(ctx, msg)
+	if err != nil || reason == "" {
+		return err
+	}
+
+	log.Printf("ingest: dead-lettering partition %d offset %d: %s", msg.Partition, msg.Offset, reason)
+	return c.deadLetters.WriteMessages(ctx, deadLetter(msg, reason))
+}
+
+// apply upserts the company record of a message. reason is why the message can never be
+// applied, err a failure worth retrying
+func (c *Consumer) apply(ctx context.Context, msg kafka.Message) (reason string, err error) {
+	var record models.CompanyRecord
+	if err := json.Unmarshal(msg.Value, &record); err != nil {
+		return err.Error(), nil
+	}
+	var verr *helpers.ValidationError
+	if err := c.v.ValidateForm(record); errors.As(err, &verr) {
+		messages := make([]string, len(verr.Errors))
+		for i, fe := range verr.Errors {
+			messages[i] = fe.Message
+		}
+		return strings.Join(messages, "; "), nil
+	}
+
+	tenantID := record.TenantID
+	if tenantID == "" {
+		tenantID = c.tenantID
+	}
+	if tenantID != c.tenantID {
+		exists, err := c.r.TenantExists(ctx, tenantID)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "unknown tenant " + tenantID, nil
+		}
+	}
+
+	_, _, err = c.r.UpsertCompany(ctx, tenantID, consts.INGEST_USER, record.ExternalID, record.Company)
+	if err == repo.ErrDuplicateName || errors.Is(err, repo.ErrRejectedCompany) {
+		return err.Error(), nil
+	}
+	return "", err
+}
+
+// deadLetter returns a copy of msg for the dead-letter topic, with headers telling why and
+// where it was read from
+func deadLetter(msg kafka.Message, reason string) kafka.Message {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+4)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderDeadLetterReason, Value: []byte(reason)},
+		kafka.Header{Key: HeaderSourceTopic, Value: []byte(msg.Topic)},
+		kafka.Header{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+
+	return kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	}
+}
+
+// wait sleeps for backoff, doubled for the next wait up to maxRetryBackoff, and reports
+// whether ctx is still live
+func wait(ctx context.Context, backoff *time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(*backoff):
+	}
+
+	*backoff *= 2
+	if *backoff > maxRetryBackoff {
+		*backoff = maxRetryBackoff
+	}
+	return true
+}
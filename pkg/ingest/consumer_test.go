@@ -0,0 +1,295 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/jackc/pgconn"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBrokerUnavailable = errors.New("broker unavailable")
+
+const testCompanyID = "ae17b2e2-6b87-4c5b-9c94-3623dacf113b"
+
+// testReader is an in-process stand-in for a kafka consumer group member
+type testReader struct {
+	committed []kafka.Message
+}
+
+func (r *testReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *testReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.committed = append(r.committed, msgs...)
+	return nil
+}
+
+func (r *testReader) Close() error {
+	return nil
+}
+
+// testTopic is an in-process stand-in for the dead-letter topic
+type testTopic struct {
+	err  error
+	msgs []kafka.Message
+}
+
+func (w *testTopic) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func (w *testTopic) Close() error {
+	return nil
+}
+
+func newTestConsumer(t *testing.T) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, *testReader, *testTopic, *Consumer) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, mockDB := dbtest.New(t)
+
+	v, err := helpers.NewValidation()
+	require.NoError(err)
+
+	reader, deadLetters := &testReader{}, &testTopic{}
+	consumer := &Consumer{
+		r:           repo.NewRepository(db),
+		v:           v,
+		reader:      reader,
+		deadLetters: deadLetters,
+		tenantID:    "default",
+	}
+
+	return assert, require, mockDB, reader, deadLetters, consumer
+}
+
+func testMessage(value string) kafka.Message {
+	return kafka.Message{
+		Topic:     "company-upserts",
+		Partition: 2,
+		Offset:    41,
+		Key:       []byte("MD-1"),
+		Value:     []byte(value),
+	}
+}
+
+func header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+const matchCompanyQuery = `
+	SELECT
+		company_id, company_name, description, amount_of_employees, is_registered, company_type, version, external_id
+	FROM companies
+	WHERE tenant_id = $1
+		AND deleted_at IS NULL
+		AND (external_id = $2 OR company_name = $3)
+	ORDER BY external_id = $2 DESC NULLS LAST
+	LIMIT 1
+	FOR UPDATE
+`
+
+const tenantExistsQuery = `
+	SELECT EXISTS (SELECT 1 FROM users WHERE tenant_id = $1)
+`
+
+var matchedColumns = []string{"company_id", "company_name", "description", "amount_of_employees", "is_registered", "company_type", "version", "external_id"}
+
+func TestConsumer(t *testing.T) {
+
+	t.Run("creates a new company and commits its offset", func(t *testing.T) {
+		assert, require, mockDB, reader, deadLetters, consumer := newTestConsumer(t)
+
+		mockDB.ExpectQuery(tenantExistsQuery).
+			WithArgs("acme").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(matchCompanyQuery).
+			WithArgs("acme", "MD-1", "XM").
+			WillReturnRows(sqlmock.NewRows(matchedColumns))
+		mockDB.ExpectQuery(`
+			INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type, external_id)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+			RETURNING company_id
+		`).
+			WithArgs("acme", "XM", "broker", 50, true, "Corporations", "MD-1").
+			WillReturnRows(sqlmock.NewRows([]string{"company_id"}).AddRow(testCompanyID))
+		mockDB.ExpectExec(`
+			INSERT INTO companies_history (tenant_id, company_id, version, operation, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`).
+			WithArgs("acme", testCompanyID, 1, "created", "XM", "broker", 50, true, "Corporations", sqlmock.AnyArg(), "ingest").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mockDB.ExpectExec(`
+			INSERT INTO company_outbox (event_id, company_id, event_type, payload)
+			VALUES ($1, $2, $3, $4)
+		`).
+			WithArgs(sqlmock.AnyArg(), testCompanyID, "company.created", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mockDB.ExpectCommit()
+
+		msg := testMessage(`{"externalId":"MD-1","tenantId":"acme","name":"XM","description":"broker","amountOfEmployees":50,"registered":true,"companyType":"Corporations"}`)
+		require.NoError(consumer.Process(context.Background(), msg))
+
+		assert.Equal([]kafka.Message{msg}, reader.committed)
+		assert.Empty(deadLetters.msgs)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("an unchanged company is left alone", func(t *testing.T) {
+		assert, require, mockDB, reader, deadLetters, consumer := newTestConsumer(t)
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(matchCompanyQuery).
+			WithArgs("default", "MD-1", "XM").
+			WillReturnRows(sqlmock.NewRows(matchedColumns).
+				AddRow(testCompanyID, "XM", "broker", 50, true, "Corporations", 3, "MD-1"))
+		mockDB.ExpectRollback()
+
+		msg := testMessage(`{"externalId":"MD-1","name":"XM","description":"broker","amountOfEmployees":50,"registered":true,"companyType":"Corporations"}`)
+		require.NoError(consumer.Process(context.Background(), msg))
+
+		assert.Len(reader.committed, 1)
+		assert.Empty(deadLetters.msgs)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("an invalid record is dead-lettered", func(t *testing.T) {
+		assert, require, mockDB, reader, deadLetters, consumer := newTestConsumer(t)
+
+		msg := testMessage(`{"externalId":"MD-1","name":"XM","amountOfEmployees":50,"companyType":"LLC"}`)
+		require.NoError(consumer.Process(context.Background(), msg))
+
+		require.Len(deadLetters.msgs, 1)
+		parked := deadLetters.msgs[0]
+		assert.Equal(msg.Key, parked.Key)
+		assert.Equal(msg.Value, parked.Value)
+		assert.Contains(header(parked, HeaderDeadLetterReason), "companyType")
+		assert.Equal("company-upserts", header(parked, HeaderSourceTopic))
+		assert.Equal("2", header(parked, HeaderSourcePartition))
+		assert.Equal("41", header(parked, HeaderSourceOffset))
+
+		assert.Len(reader.committed, 1)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("a malformed message is dead-lettered", func(t *testing.T) {
+		assert, require, _, reader, deadLetters, consumer := newTestConsumer(t)
+
+		require.NoError(consumer.Process(context.Background(), testMessage(`{"name":`)))
+
+		assert.Len(deadLetters.msgs, 1)
+		assert.Len(reader.committed, 1)
+	})
+
+	t.Run("a name taken by another external ID is dead-lettered", func(t *testing.T) {
+		assert, require, mockDB, reader, deadLetters, consumer := newTestConsumer(t)
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(matchCompanyQuery).
+			WithArgs("default", "MD-1", "XM").
+			WillReturnRows(sqlmock.NewRows(matchedColumns).
+				AddRow(testCompanyID, "XM", "broker", 50, true, "Corporations", 3, "MD-7"))
+		mockDB.ExpectRollback()
+
+		msg := testMessage(`{"externalId":"MD-1","name":"XM","description":"broker","amountOfEmployees":50,"registered":true,"companyType":"Corporations"}`)
+		require.NoError(consumer.Process(context.Background(), msg))
+
+		require.Len(deadLetters.msgs, 1)
+		assert.Equal(repo.ErrDuplicateName.Error(), header(deadLetters.msgs[0], HeaderDeadLetterReason))
+		assert.Len(reader.committed, 1)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("a record of an unknown tenant is dead-lettered", func(t *testing.T) {
+		assert, require, mockDB, reader, deadLetters, consumer := newTestConsumer(t)
+
+		mockDB.ExpectQuery(tenantExistsQuery).
+			WithArgs("acme").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		msg := testMessage(`{"externalId":"MD-1","tenantId":"acme","name":"XM","description":"broker","amountOfEmployees":50,"registered":true,"companyType":"Corporations"}`)
+		require.NoError(consumer.Process(context.Background(), msg))
+
+		require.Len(deadLetters.msgs, 1)
+		assert.Equal("unknown tenant acme", header(deadLetters.msgs[0], HeaderDeadLetterReason))
+		assert.Len(reader.committed, 1)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("a record the database rejects is dead-lettered", func(t *testing.T) {
+		assert, require, mockDB, reader, deadLetters, consumer := newTestConsumer(t)
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(matchCompanyQuery).
+			WithArgs("default", "MD-1", "XM").
+			WillReturnRows(sqlmock.NewRows(matchedColumns))
+		mockDB.ExpectQuery(`
+			INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type, external_id)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+			RETURNING company_id
+		`).
+			WithArgs("default", "XM", "broker", 50, true, "Corporations", "MD-1").
+			WillReturnError(&pgconn.PgError{Code: "23514"})
+		mockDB.ExpectRollback()
+
+		msg := testMessage(`{"externalId":"MD-1","name":"XM","description":"broker","amountOfEmployees":50,"registered":true,"companyType":"Corporations"}`)
+		require.NoError(consumer.Process(context.Background(), msg))
+
+		require.Len(deadLetters.msgs, 1)
+		assert.Contains(header(deadLetters.msgs[0], HeaderDeadLetterReason), repo.ErrRejectedCompany.Error())
+		assert.Len(reader.committed, 1)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("a database failure is retried without committing", func(t *testing.T) {
+		assert, _, mockDB, reader, deadLetters, consumer := newTestConsumer(t)
+
+		mockDB.ExpectBegin().WillReturnError(errors.New("db connection error"))
+
+		msg := testMessage(`{"externalId":"MD-1","name":"XM","description":"broker","amountOfEmployees":50,"registered":true,"companyType":"Corporations"}`)
+		assert.Error(consumer.Handle(context.Background(), msg))
+
+		assert.Empty(deadLetters.msgs)
+		assert.Empty(reader.committed)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("a message is not committed until it is dead-lettered", func(t *testing.T) {
+		assert, _, _, reader, deadLetters, consumer := newTestConsumer(t)
+		deadLetters.err = errBrokerUnavailable
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.Error(consumer.Process(ctx, testMessage(`not json`)))
+
+		assert.Empty(reader.committed)
+	})
+}
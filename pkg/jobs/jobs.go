@@ -0,0 +1,224 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/repo"
+)
+
+// maxJobAttempts is how many times a job is claimed before it is given up on. A job is claimed
+// again when the worker running it stops, a job that keeps crashing its workers fails instead
+const maxJobAttempts = 3
+
+var errOutputNotSaved = errors.New("the file of the job could not be saved")
+
+// Outcome is what a job that succeeded leaves for its caller. Result is stored as json,
+// ContentType is the media type of the file the job wrote to its output, downloaded from the
+// job's result URL. It is empty when the job produced no file
+type Outcome struct {
+	Result      interface{}
+	ContentType string
+}
+
+// Progress reports how many items of a job were processed so far out of total, zero when the
+// total is not known
+type Progress func(done, total int)
+
+// Runner executes a job of one type and must return once ctx is cancelled. The file it
+// produces is written to output. Its error is shown to the caller of the job
+type Runner func(ctx context.Context, job models.Job, progress Progress, output io.Writer) (Outcome, error)
+
+// Runners are the runners of each job type
+type Runners map[string]Runner
+
+// Pool runs queued jobs with a fixed number of workers. Jobs are kept in the database, a job
+// running when the process stops is picked up again by any replica once its lease expires
+type Pool struct {
+	r        repo.IRepository
+	runners  Runners
+	workers  int
+	interval time.Duration
+	lease    time.Duration
+	id       string
+}
+
+func NewPool(r repo.IRepository, runners Runners, cfg config.Configurations) *Pool {
+	host, _ := os.Hostname()
+	return &Pool{
+		r:        r,
+		runners:  runners,
+		workers:  cfg.JobWorkers,
+		interval: cfg.JobPollInterval,
+		lease:    cfg.JobLease,
+		id:       fmt.Sprintf("%s-%d", host, os.Getpid()),
+	}
+}
+
+// Run runs jobs until ctx is cancelled
+func (p *Pool) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			p.work(ctx, workerID)
+		}(p.id + "-" + strconv.Itoa(i))
+	}
+
+	wg.Wait()
+	return nil
+}
+
+func (p *Pool) work(ctx context.Context, workerID string) {
+	for {
+		ran, err := p.RunNext(ctx, workerID)
+		if err != nil {
+			log.Println("jobs:", err)
+		}
+
+		wait := p.interval
+		if ran && err == nil {
+			// there may be more queued jobs
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RunNext claims the oldest queued job for workerID and runs it, reporting whether there was
+// one. A job interrupted by ctx is left to be claimed again
+func (p *Pool) RunNext(ctx context.Context, workerID string) (ran bool, err error) {
+	job, err := p.r.ClaimJob(ctx, workerID, p.lease)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return
+	}
+	ran = true
+
+	runner, ok := p.runners[job.Type]
+	switch {
+	case job.CancelRequested:
+		job.Status = models.JobCancelled
+		err = p.r.FinishJob(ctx, workerID, job, "", 0)
+		return
+	case job.Attempts > maxJobAttempts:
+		job.Status = models.JobFailed
+		job.Error = fmt.Sprintf("job was interrupted %d times", maxJobAttempts)
+		err = p.r.FinishJob(ctx, workerID, job, "", 0)
+		return
+	case !ok:
+		job.Status = models.JobFailed
+		job.Error = "unknown job type " + job.Type
+		err = p.r.FinishJob(ctx, workerID, job, "", 0)
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		cancelled bool
+		lost      bool
+	)
+	progress := func(done, total int) {
+		mu.Lock()
+		defer mu.Unlock()
+		job.Progress = done
+		job.Total = nil
+		if total > 0 {
+			job.Total = &total
+		}
+	}
+
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(p.lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			mu.Lock()
+			done, total := job.Progress, job.Total
+			mu.Unlock()
+
+			cancelRequested, err := p.r.RenewJobLease(ctx, job.ID, workerID, p.lease, done, total)
+			if err != nil && err != sql.ErrNoRows {
+				log.Println("jobs:", err)
+				continue
+			}
+
+			mu.Lock()
+			cancelled, lost = cancelRequested, err == sql.ErrNoRows
+			mu.Unlock()
+			if cancelRequested || err == sql.ErrNoRows {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	out := &output{ctx: jobCtx, r: p.r, jobID: job.ID, workerID: workerID}
+	outcome, runErr := runner(jobCtx, job, progress, out)
+	if runErr == nil && outcome.ContentType != "" {
+		if err := out.Flush(); err != nil {
+			log.Println("jobs:", err)
+			runErr = errOutputNotSaved
+		}
+	}
+	close(stop)
+	<-renewed
+
+	switch {
+	case lost:
+		// another worker claimed the job after the lease expired
+		return
+	case cancelled:
+		job.Status = models.JobCancelled
+	case ctx.Err() != nil:
+		// shutting down, the job runs again once its lease expires
+		return
+	case runErr != nil:
+		job.Status = models.JobFailed
+		job.Error = runErr.Error()
+	default:
+		job.Status = models.JobSucceeded
+		if outcome.Result != nil {
+			if job.Result, err = json.Marshal(outcome.Result); err != nil {
+				return
+			}
+		}
+	}
+
+	if job.Status != models.JobSucceeded {
+		outcome = Outcome{}
+	}
+	err = p.r.FinishJob(ctx, workerID, job, outcome.ContentType, out.chunks)
+	return
+}
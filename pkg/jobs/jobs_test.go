@@ -0,0 +1,330 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJobID = "5f0c2a8e-3b1d-4c6e-9a7f-1e2d3c4b5a69"
+
+const claimJobQuery = `
+	UPDATE jobs
+	SET
+		status = 'running',
+		attempts = attempts + 1,
+		locked_by = $1,
+		locked_until = CURRENT_TIMESTAMP + make_interval(secs => $2),
+		started_at = coalesce(started_at, CURRENT_TIMESTAMP)
+	WHERE job_id = (
+		SELECT
+			job_id
+		FROM jobs
+		WHERE status = 'queued'
+			OR (status = 'running' AND locked_until < CURRENT_TIMESTAMP)
+		ORDER BY created_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING job_id, job_type, status, progress, total, result, output_type IS NOT NULL, error, created_at, started_at, finished_at, tenant_id, owner_id, params, attempts, cancel_requested, input
+`
+
+const renewJobLeaseQuery = `
+	UPDATE jobs
+	SET
+		locked_until = CURRENT_TIMESTAMP + make_interval(secs => $3),
+		progress = $4,
+		total = $5
+	WHERE
+		job_id = $1
+		AND locked_by = $2
+		AND status = 'running'
+	RETURNING cancel_requested
+`
+
+const finishJobQuery = `
+	UPDATE jobs
+	SET
+		status = $3,
+		progress = $4,
+		total = $5,
+		result = $6,
+		output_type = $7,
+		error = NULLIF($8, ''),
+		finished_at = CURRENT_TIMESTAMP,
+		locked_by = NULL,
+		locked_until = NULL,
+		input = NULL
+	WHERE
+		job_id = $1
+		AND locked_by = $2
+`
+
+const deleteJobOutputQuery = `
+	DELETE FROM job_output_chunks
+	WHERE
+		job_id = $1
+		AND chunk >= $2
+`
+
+const writeJobOutputQuery = `
+	INSERT INTO job_output_chunks (job_id, chunk, data)
+	SELECT
+		job_id, $3, $4
+	FROM jobs
+	WHERE job_id = $1
+		AND locked_by = $2
+		AND status = 'running'
+	ON CONFLICT (job_id, chunk) DO UPDATE SET data = EXCLUDED.data
+`
+
+// expectFinishJob expects a job to be finished with args, keeping chunks chunks of its file
+func expectFinishJob(mockDB sqlmock.Sqlmock, chunks int, args ...driver.Value) {
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(finishJobQuery).
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(deleteJobOutputQuery).
+		WithArgs(testJobID, chunks).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectCommit()
+}
+
+func newTestPool(t *testing.T, runners Runners, lease time.Duration) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, *Pool) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, mockDB := dbtest.New(t)
+
+	pool := NewPool(repo.NewRepository(db), runners, config.Configurations{
+		JobWorkers:      1,
+		JobPollInterval: time.Millisecond,
+		JobLease:        lease,
+	})
+
+	return assert, require, mockDB, pool
+}
+
+func claimedJobRows(jobType string, attempts int, cancelRequested bool) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{
+		"job_id", "job_type", "status", "progress", "total", "result", "has_output", "error",
+		"created_at", "started_at", "finished_at", "tenant_id", "owner_id", "params",
+		"attempts", "cancel_requested", "input",
+	}).AddRow(
+		testJobID, jobType, models.JobRunning, 0, nil, nil, false, nil,
+		now, now, nil, "acme", "user", []byte(`{"format":"csv"}`),
+		attempts, cancelRequested, nil,
+	)
+}
+
+func TestPool(t *testing.T) {
+
+	t.Run("no queued job", func(t *testing.T) {
+		assert, _, mockDB, pool := newTestPool(t, Runners{}, time.Minute)
+
+		mockDB.ExpectQuery(claimJobQuery).
+			WithArgs("worker", 60.0).
+			WillReturnError(sql.ErrNoRows)
+
+		ran, err := pool.RunNext(context.Background(), "worker")
+		assert.NoError(err)
+		assert.False(ran)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("stores the outcome of a job", func(t *testing.T) {
+		var claimed models.Job
+		runners := Runners{
+			models.JobTypeExport: func(ctx context.Context, job models.Job, progress Progress, output io.Writer) (Outcome, error) {
+				claimed = job
+				progress(2, 2)
+				if _, err := io.WriteString(output, "id,name\n"); err != nil {
+					return Outcome{}, err
+				}
+				return Outcome{
+					Result:      map[string]int{"exported": 2},
+					ContentType: "text/csv",
+				}, nil
+			},
+		}
+		assert, _, mockDB, pool := newTestPool(t, runners, time.Minute)
+
+		mockDB.ExpectQuery(claimJobQuery).
+			WithArgs("worker", 60.0).
+			WillReturnRows(claimedJobRows(models.JobTypeExport, 1, false))
+
+		mockDB.ExpectExec(writeJobOutputQuery).
+			WithArgs(testJobID, "worker", 0, []byte("id,name\n")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		expectFinishJob(mockDB, 1,
+			testJobID, "worker", models.JobSucceeded, 2, 2,
+			[]byte(`{"exported":2}`), "text/csv", "",
+		)
+
+		ran, err := pool.RunNext(context.Background(), "worker")
+		assert.NoError(err)
+		assert.True(ran)
+		assert.Equal("acme", claimed.TenantID)
+		assert.Equal(`{"format":"csv"}`, string(claimed.Params))
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("saves the file of a job in chunks", func(t *testing.T) {
+		file := bytes.Repeat([]byte("x"), outputChunkSize+3)
+		runners := Runners{
+			models.JobTypeExport: func(ctx context.Context, job models.Job, progress Progress, output io.Writer) (Outcome, error) {
+				if _, err := output.Write(file); err != nil {
+					return Outcome{}, err
+				}
+				return Outcome{ContentType: "text/csv"}, nil
+			},
+		}
+		assert, _, mockDB, pool := newTestPool(t, runners, time.Minute)
+
+		mockDB.ExpectQuery(claimJobQuery).
+			WithArgs("worker", 60.0).
+			WillReturnRows(claimedJobRows(models.JobTypeExport, 2, false))
+
+		mockDB.ExpectExec(writeJobOutputQuery).
+			WithArgs(testJobID, "worker", 0, file[:outputChunkSize]).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectExec(writeJobOutputQuery).
+			WithArgs(testJobID, "worker", 1, []byte("xxx")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		expectFinishJob(mockDB, 2,
+			testJobID, "worker", models.JobSucceeded, 0, nil,
+			nil, "text/csv", "",
+		)
+
+		ran, err := pool.RunNext(context.Background(), "worker")
+		assert.NoError(err)
+		assert.True(ran)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("fails a job whose file is not saved", func(t *testing.T) {
+		runners := Runners{
+			models.JobTypeExport: func(ctx context.Context, job models.Job, progress Progress, output io.Writer) (Outcome, error) {
+				_, err := io.WriteString(output, "id,name\n")
+				return Outcome{ContentType: "text/csv"}, err
+			},
+		}
+		assert, _, mockDB, pool := newTestPool(t, runners, time.Minute)
+
+		mockDB.ExpectQuery(claimJobQuery).
+			WithArgs("worker", 60.0).
+			WillReturnRows(claimedJobRows(models.JobTypeExport, 1, false))
+
+		mockDB.ExpectExec(writeJobOutputQuery).
+			WithArgs(testJobID, "worker", 0, []byte("id,name\n")).
+			WillReturnError(errors.New("db connection error"))
+
+		expectFinishJob(mockDB, 0,
+			testJobID, "worker", models.JobFailed, 0, nil,
+			nil, nil, "the file of the job could not be saved",
+		)
+
+		ran, err := pool.RunNext(context.Background(), "worker")
+		assert.NoError(err)
+		assert.True(ran)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("records a failed job", func(t *testing.T) {
+		runners := Runners{
+			models.JobTypeExport: func(ctx context.Context, job models.Job, progress Progress, output io.Writer) (Outcome, error) {
+				return Outcome{}, errors.New("request could not be processed")
+			},
+		}
+		assert, _, mockDB, pool := newTestPool(t, runners, time.Minute)
+
+		mockDB.ExpectQuery(claimJobQuery).
+			WithArgs("worker", 60.0).
+			WillReturnRows(claimedJobRows(models.JobTypeExport, 1, false))
+
+		expectFinishJob(mockDB, 0,
+			testJobID, "worker", models.JobFailed, 0, nil,
+			nil, nil, "request could not be processed",
+		)
+
+		ran, err := pool.RunNext(context.Background(), "worker")
+		assert.NoError(err)
+		assert.True(ran)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("stops a job cancelled while running", func(t *testing.T) {
+		runners := Runners{
+			models.JobTypeExport: func(ctx context.Context, job models.Job, progress Progress, output io.Writer) (Outcome, error) {
+				<-ctx.Done()
+				return Outcome{}, ctx.Err()
+			},
+		}
+		assert, _, mockDB, pool := newTestPool(t, runners, 30*time.Millisecond)
+
+		mockDB.ExpectQuery(claimJobQuery).
+			WithArgs("worker", 0.03).
+			WillReturnRows(claimedJobRows(models.JobTypeExport, 1, false))
+
+		mockDB.ExpectQuery(renewJobLeaseQuery).
+			WithArgs(testJobID, "worker", 0.03, 0, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"cancel_requested"}).AddRow(true))
+
+		expectFinishJob(mockDB, 0,
+			testJobID, "worker", models.JobCancelled, 0, nil,
+			nil, nil, "",
+		)
+
+		ran, err := pool.RunNext(context.Background(), "worker")
+		assert.NoError(err)
+		assert.True(ran)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("gives up on a job interrupted too often", func(t *testing.T) {
+		runners := Runners{
+			models.JobTypeExport: func(ctx context.Context, job models.Job, progress Progress, output io.Writer) (Outcome, error) {
+				t.Fatal("job should not run")
+				return Outcome{}, nil
+			},
+		}
+		assert, _, mockDB, pool := newTestPool(t, runners, time.Minute)
+
+		mockDB.ExpectQuery(claimJobQuery).
+			WithArgs("worker", 60.0).
+			WillReturnRows(claimedJobRows(models.JobTypeExport, maxJobAttempts+1, false))
+
+		expectFinishJob(mockDB, 0,
+			testJobID, "worker", models.JobFailed, 0, nil,
+			nil, nil, "job was interrupted 3 times",
+		)
+
+		ran, err := pool.RunNext(context.Background(), "worker")
+		assert.NoError(err)
+		assert.True(ran)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
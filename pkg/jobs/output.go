@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/danielboakye/go-xm/repo"
+)
+
+// outputChunkSize is the size of the chunks a job's file is saved in
+const outputChunkSize = 1 << 20
+
+// output is the file a job writes, saved in chunks of outputChunkSize as it fills so that a
+// large export is never held in memory as a whole
+type output struct {
+	ctx      context.Context
+	r        repo.IRepository
+	jobID    string
+	workerID string
+	buf      []byte
+	chunks   int
+}
+
+func (o *output) Write(p []byte) (n int, err error) {
+	n = len(p)
+	for len(p) > 0 {
+		free := outputChunkSize - len(o.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		o.buf = append(o.buf, p[:free]...)
+		p = p[free:]
+
+		if len(o.buf) == outputChunkSize {
+			if err = o.Flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return
+}
+
+// Flush saves what is buffered as the next chunk
+func (o *output) Flush() (err error) {
+	if len(o.buf) == 0 {
+		return
+	}
+	if err = o.r.WriteJobOutput(o.ctx, o.jobID, o.workerID, o.chunks, o.buf); err != nil {
+		return
+	}
+	o.chunks++
+	o.buf = o.buf[:0]
+	return
+}
@@ -0,0 +1,111 @@
+package kfkp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/segmentio/kafka-go"
+)
+
+// EventVersion is the schema version of the events published by this package.
+// It must be bumped whenever a breaking change is made to Event
+const EventVersion = 1
+
+const (
+	EventCompanyCreated  = "company.created"
+	EventCompanyUpdated  = "company.updated"
+	EventCompanyDeleted  = "company.deleted"
+	EventCompanyRestored = "company.restored"
+	EventCompanyPurged   = "company.purged"
+)
+
+// Event describes a mutation of a company
+type Event struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Version   int                    `json:"version"`
+	TenantID  string                 `json:"tenantId"`
+	CompanyID string                 `json:"companyId"`
+	UserID    string                 `json:"userId"`
+	Timestamp time.Time              `json:"timestamp"`
+	Changes   map[string]interface{} `json:"changes,omitempty"`
+}
+
+type IPublisher interface {
+	Publish(ctx context.Context, events ...Event) error
+	Close() error
+}
+
+type Publisher struct {
+	w *kafka.Writer
+}
+
+// NewEvent returns an Event of the given type stamped with a new ID, the current
+// schema version and the current time
+func NewEvent(eventType, tenantID, companyID, userID string, changes map[string]interface{}) Event {
+	return Event{
+		ID:        helpers.NewUUID(),
+		Type:      eventType,
+		Version:   EventVersion,
+		TenantID:  tenantID,
+		CompanyID: companyID,
+		UserID:    userID,
+		Timestamp: time.Now().UTC(),
+		Changes:   changes,
+	}
+}
+
+// NewPublisher returns a Publisher writing to the company topic. Messages are keyed
+// by company ID so that all events of a company land on the same partition in order
+func NewPublisher(cfg config.Configurations) IPublisher {
+	w := &kafka.Writer{
+		Addr:                   kafka.TCP(strings.Split(cfg.KafkaURL, ",")...),
+		Topic:                  cfg.KafkaCompanyTopic,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		MaxAttempts:            3,
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+	}
+
+	return &Publisher{w: w}
+}
+
+func (p *Publisher) Publish(ctx context.Context, events ...Event) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, e := range events {
+		msg, err := NewMessage(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return p.w.WriteMessages(ctx, msgs...)
+}
+
+func (p *Publisher) Close() error {
+	return p.w.Close()
+}
+
+// NewMessage encodes an Event as a kafka message keyed by company ID
+func NewMessage(e Event) (msg kafka.Message, err error) {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	msg = kafka.Message{
+		Key:   []byte(e.CompanyID),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "event-type", Value: []byte(e.Type)},
+			{Key: "event-id", Value: []byte(e.ID)},
+		},
+	}
+	return
+}
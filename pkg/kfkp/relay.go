@@ -0,0 +1,267 @@
+package kfkp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/segmentio/kafka-go"
+)
+
+// relayLockID is the postgres advisory lock held while relaying a batch. Only one relay
+// across all replicas drains the outbox at a time, which keeps events of a company in order
+const relayLockID = 7_340_001
+
+const maxRelayBackoff = time.Minute
+
+// Relay drains the company outbox to kafka. Events are published in the order they were
+// written and marked as published afterwards, a failed batch is retried with backoff. When a
+// batch fails its events are published one by one until one fails for a reason that is not the
+// event's own, such as the broker being unreachable, which leaves it and the events after it
+// untouched for the next attempt. An event kafka rejects, because it is too large or invalid,
+// or that cannot be decoded is dead-lettered: it is marked failed and the later events of its
+// company are held back until an operator deals with it, so that they are never published out
+// of order.
+//
+// The outbox is only written once publishing is over. Until its first write a transaction has
+// no transaction id, so the change feed, which waits for every older transaction to finish,
+// is not held back while kafka is slow to answer
+type Relay struct {
+	db        *sql.DB
+	p         IPublisher
+	interval  time.Duration
+	batchSize int
+}
+
+func NewRelay(db *sql.DB, p IPublisher, cfg config.Configurations) *Relay {
+	return &Relay{
+		db:        db,
+		p:         p,
+		interval:  cfg.OutboxPollInterval,
+		batchSize: cfg.OutboxBatchSize,
+	}
+}
+
+// Run relays outbox batches until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) error {
+	backoff := r.interval
+	for {
+		n, err := r.RelayBatch(ctx)
+
+		wait := r.interval
+		switch {
+		case err != nil:
+			log.Println("outbox relay:", err)
+			backoff *= 2
+			if backoff > maxRelayBackoff {
+				backoff = maxRelayBackoff
+			}
+			wait = backoff
+		case n == r.batchSize:
+			// there may be more pending events, keep draining
+			backoff, wait = r.interval, 0
+		default:
+			backoff = r.interval
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayBatch publishes the oldest unpublished outbox events of the companies without a
+// dead-lettered event and returns how many were published. It fails with the publishing error
+// that left events to retry
+func (r *Relay) RelayBatch(ctx context.Context) (n int, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	err = tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, relayLockID).Scan(&locked)
+	if err != nil || !locked {
+		return
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+			SELECT
+				outbox_id, company_id, payload
+			FROM company_outbox o
+			WHERE published_at IS NULL
+				AND failed_at IS NULL
+				AND NOT EXISTS (
+					SELECT 1 FROM company_outbox f WHERE f.company_id = o.company_id AND f.failed_at IS NOT NULL
+				)
+			ORDER BY outbox_id
+			LIMIT $1
+		`,
+		r.batchSize,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	type deadEvent struct {
+		id    int64
+		cause error
+	}
+	var (
+		ids    []int64
+		events []Event
+		dead   []deadEvent
+		// held are the companies whose later events wait for a dead-lettered one
+		held = make(map[string]bool)
+	)
+	for rows.Next() {
+		var (
+			id        int64
+			companyID string
+			payload   []byte
+			event     Event
+		)
+		if err = rows.Scan(&id, &companyID, &payload); err != nil {
+			return
+		}
+		if held[companyID] {
+			continue
+		}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			dead = append(dead, deadEvent{id: id, cause: err})
+			held[companyID] = true
+			continue
+		}
+		ids = append(ids, id)
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	rows.Close()
+
+	published := ids
+	var pubErr error
+	if len(events) > 0 {
+		if perr := r.p.Publish(ctx, events...); perr != nil {
+			published = nil
+
+			for i, event := range events {
+				if held[event.CompanyID] {
+					continue
+				}
+				perr := r.p.Publish(ctx, event)
+				if perr == nil {
+					published = append(published, ids[i])
+					continue
+				}
+				if !rejected(perr) {
+					// kafka is unavailable, this event and the later ones are tried again
+					pubErr = perr
+					break
+				}
+				held[event.CompanyID] = true
+				dead = append(dead, deadEvent{id: ids[i], cause: perr})
+			}
+		}
+	}
+
+	for _, event := range dead {
+		if err = r.deadLetter(ctx, tx, event.id, event.cause); err != nil {
+			return
+		}
+	}
+
+	if len(published) > 0 {
+		_, err = tx.ExecContext(ctx, `
+				UPDATE company_outbox
+				SET
+					attempts = attempts + 1,
+					published_at = now()
+				WHERE
+					outbox_id = ANY($1::bigint[])
+			`,
+			int64Array(published),
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	n = len(published)
+	err = pubErr
+	return
+}
+
+// deadLetter marks the outbox event id failed for cause, it is no longer relayed and holds back
+// the later events of its company
+func (r *Relay) deadLetter(ctx context.Context, tx *sql.Tx, id int64, cause error) (err error) {
+	_, err = tx.ExecContext(ctx, `
+			UPDATE company_outbox
+			SET
+				attempts = attempts + 1,
+				last_error = $2,
+				failed_at = now()
+			WHERE
+				outbox_id = $1
+		`,
+		id, cause.Error(),
+	)
+	if err != nil {
+		return
+	}
+
+	log.Printf("outbox relay: dead-lettered event %d: %v", id, cause)
+	return
+}
+
+// rejected reports whether kafka refused a message itself, so that publishing it again cannot
+// succeed, rather than failing to take it
+func rejected(err error) bool {
+	var tooLarge kafka.MessageTooLargeError
+	if errors.As(err, &tooLarge) {
+		return true
+	}
+
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, e := range writeErrs {
+			if e != nil && rejected(e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		switch kafkaErr {
+		case kafka.MessageSizeTooLarge, kafka.InvalidMessage, kafka.InvalidMessageSize, kafka.InvalidRecord:
+			return true
+		}
+	}
+	return false
+}
+
+// int64Array formats ids as a postgres array literal
+func int64Array(ids []int64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
@@ -0,0 +1,283 @@
+package kfkp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBrokerUnavailable = errors.New("broker unavailable")
+
+// testBroker is an in-process stand-in for kafka. It fails every publication while err is
+// set, and the publications including an event of failures with its error
+type testBroker struct {
+	err      error
+	failures map[string]error
+	events   []Event
+}
+
+func (b *testBroker) Publish(ctx context.Context, events ...Event) error {
+	if b.err != nil {
+		return b.err
+	}
+	for _, e := range events {
+		if err := b.failures[e.ID]; err != nil {
+			return err
+		}
+	}
+	b.events = append(b.events, events...)
+	return nil
+}
+
+func (b *testBroker) Close() error {
+	return nil
+}
+
+func newTestRelay(t *testing.T, broker *testBroker) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, *Relay) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, mockDB := dbtest.New(t)
+
+	relay := NewRelay(db, broker, config.Configurations{
+		OutboxPollInterval: time.Millisecond,
+		OutboxBatchSize:    10,
+	})
+
+	return assert, require, mockDB, relay
+}
+
+func outboxRows(require *require.Assertions, events ...Event) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"outbox_id", "company_id", "payload"})
+	for i, e := range events {
+		payload, err := json.Marshal(e)
+		require.NoError(err)
+		rows.AddRow(int64(i+1), e.CompanyID, payload)
+	}
+	return rows
+}
+
+const selectOutboxQuery = `
+	SELECT
+		outbox_id, company_id, payload
+	FROM company_outbox o
+	WHERE published_at IS NULL
+		AND failed_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM company_outbox f WHERE f.company_id = o.company_id AND f.failed_at IS NOT NULL
+		)
+	ORDER BY outbox_id
+	LIMIT $1
+`
+
+const deadLetterOutboxEventQuery = `
+	UPDATE company_outbox
+	SET
+		attempts = attempts + 1,
+		last_error = $2,
+		failed_at = now()
+	WHERE
+		outbox_id = $1
+`
+
+const publishOutboxEventsQuery = `
+	UPDATE company_outbox
+	SET
+		attempts = attempts + 1,
+		published_at = now()
+	WHERE
+		outbox_id = ANY($1::bigint[])
+`
+
+func TestRelay(t *testing.T) {
+
+	t.Run("publishes pending events in order", func(t *testing.T) {
+		broker := &testBroker{}
+		assert, require, mockDB, relay := newTestRelay(t, broker)
+
+		created := NewEvent(EventCompanyCreated, "default", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "user", nil)
+		updated := NewEvent(EventCompanyUpdated, "default", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "user", map[string]interface{}{"amountOfEmployees": 3})
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(`SELECT pg_try_advisory_xact_lock($1)`).
+			WithArgs(relayLockID).
+			WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+		mockDB.ExpectQuery(selectOutboxQuery).
+			WithArgs(10).
+			WillReturnRows(outboxRows(require, created, updated))
+		mockDB.ExpectExec(publishOutboxEventsQuery).
+			WithArgs("{1,2}").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mockDB.ExpectCommit()
+
+		n, err := relay.RelayBatch(context.Background())
+		require.NoError(err)
+
+		assert.Equal(2, n)
+		if assert.Len(broker.events, 2) {
+			assert.Equal(created.ID, broker.events[0].ID)
+			assert.Equal(updated.ID, broker.events[1].ID)
+		}
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("leaves events untouched while kafka is unavailable", func(t *testing.T) {
+		broker := &testBroker{err: errBrokerUnavailable}
+		assert, require, mockDB, relay := newTestRelay(t, broker)
+
+		created := NewEvent(EventCompanyCreated, "default", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "user", nil)
+		other := NewEvent(EventCompanyCreated, "default", "f2b1c0de-0000-4000-8000-000000000043", "user", nil)
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(`SELECT pg_try_advisory_xact_lock($1)`).
+			WithArgs(relayLockID).
+			WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+		mockDB.ExpectQuery(selectOutboxQuery).
+			WithArgs(10).
+			WillReturnRows(outboxRows(require, created, other))
+		mockDB.ExpectCommit()
+
+		n, err := relay.RelayBatch(context.Background())
+
+		assert.ErrorIs(err, errBrokerUnavailable)
+		assert.Equal(0, n)
+		assert.Empty(broker.events)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("dead-letters a rejected event and holds back its company", func(t *testing.T) {
+		broker := &testBroker{failures: map[string]error{}}
+		assert, require, mockDB, relay := newTestRelay(t, broker)
+
+		rejected := NewEvent(EventCompanyCreated, "default", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "user", nil)
+		held := NewEvent(EventCompanyUpdated, "default", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "user", map[string]interface{}{"amountOfEmployees": 3})
+		other := NewEvent(EventCompanyCreated, "default", "f2b1c0de-0000-4000-8000-000000000043", "user", nil)
+		broker.failures[rejected.ID] = kafka.MessageSizeTooLarge
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(`SELECT pg_try_advisory_xact_lock($1)`).
+			WithArgs(relayLockID).
+			WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+		mockDB.ExpectQuery(selectOutboxQuery).
+			WithArgs(10).
+			WillReturnRows(outboxRows(require, rejected, held, other))
+		mockDB.ExpectExec(deadLetterOutboxEventQuery).
+			WithArgs(int64(1), kafka.MessageSizeTooLarge.Error()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectExec(publishOutboxEventsQuery).
+			WithArgs("{3}").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectCommit()
+
+		n, err := relay.RelayBatch(context.Background())
+		require.NoError(err)
+
+		assert.Equal(1, n)
+		if assert.Len(broker.events, 1) {
+			assert.Equal(other.ID, broker.events[0].ID)
+		}
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("keeps the order when kafka fails after a rejection", func(t *testing.T) {
+		broker := &testBroker{failures: map[string]error{}}
+		assert, require, mockDB, relay := newTestRelay(t, broker)
+
+		rejected := NewEvent(EventCompanyCreated, "default", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "user", nil)
+		other := NewEvent(EventCompanyCreated, "default", "f2b1c0de-0000-4000-8000-000000000043", "user", nil)
+		rejection := kafka.WriteErrors{kafka.MessageSizeTooLarge}
+		broker.failures[rejected.ID] = rejection
+		broker.failures[other.ID] = errBrokerUnavailable
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(`SELECT pg_try_advisory_xact_lock($1)`).
+			WithArgs(relayLockID).
+			WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+		mockDB.ExpectQuery(selectOutboxQuery).
+			WithArgs(10).
+			WillReturnRows(outboxRows(require, rejected, other))
+		mockDB.ExpectExec(deadLetterOutboxEventQuery).
+			WithArgs(int64(1), rejection.Error()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectCommit()
+
+		n, err := relay.RelayBatch(context.Background())
+
+		assert.ErrorIs(err, errBrokerUnavailable)
+		assert.Equal(0, n)
+		assert.Empty(broker.events)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("dead-letters an undecodable event and holds back its company", func(t *testing.T) {
+		broker := &testBroker{}
+		assert, require, mockDB, relay := newTestRelay(t, broker)
+
+		held := NewEvent(EventCompanyUpdated, "default", "ae17b2e2-6b87-4c5b-9c94-3623dacf113b", "user", nil)
+		other := NewEvent(EventCompanyCreated, "default", "f2b1c0de-0000-4000-8000-000000000043", "user", nil)
+		heldPayload, err := json.Marshal(held)
+		require.NoError(err)
+		otherPayload, err := json.Marshal(other)
+		require.NoError(err)
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(`SELECT pg_try_advisory_xact_lock($1)`).
+			WithArgs(relayLockID).
+			WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+		mockDB.ExpectQuery(selectOutboxQuery).
+			WithArgs(10).
+			WillReturnRows(sqlmock.NewRows([]string{"outbox_id", "company_id", "payload"}).
+				AddRow(int64(1), held.CompanyID, []byte(`{"id":`)).
+				AddRow(int64(2), held.CompanyID, heldPayload).
+				AddRow(int64(3), other.CompanyID, otherPayload))
+		mockDB.ExpectExec(deadLetterOutboxEventQuery).
+			WithArgs(int64(1), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectExec(publishOutboxEventsQuery).
+			WithArgs("{3}").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectCommit()
+
+		n, err := relay.RelayBatch(context.Background())
+		require.NoError(err)
+
+		assert.Equal(1, n)
+		if assert.Len(broker.events, 1) {
+			assert.Equal(other.ID, broker.events[0].ID)
+		}
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("skips when another relay holds the lock", func(t *testing.T) {
+		broker := &testBroker{}
+		assert, require, mockDB, relay := newTestRelay(t, broker)
+
+		mockDB.ExpectBegin()
+		mockDB.ExpectQuery(`SELECT pg_try_advisory_xact_lock($1)`).
+			WithArgs(relayLockID).
+			WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+		mockDB.ExpectRollback()
+
+		n, err := relay.RelayBatch(context.Background())
+		require.NoError(err)
+
+		assert.Equal(0, n)
+		assert.Empty(broker.events)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
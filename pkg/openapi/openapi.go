@@ -0,0 +1,509 @@
+package openapi
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the version of the OpenAPI specification the documents follow
+const Version = "3.0.3"
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem holds the operations of a path by lowercase method
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+}
+
+// Schema is the subset of JSON schema generated from go types
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int64             `json:"minLength,omitempty"`
+	MaxLength            *int64             `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int64             `json:"minItems,omitempty"`
+	MaxItems             *int64             `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// Security requirements of an endpoint
+const (
+	AuthNone     = ""
+	AuthRequired = "required"
+	AuthOptional = "optional"
+)
+
+// List describes a {"data": [...]} response listing values of the type of Of
+type List struct {
+	Of interface{}
+}
+
+// File describes a response or request body of a non-json media type
+type File struct {
+	ContentTypes []string
+}
+
+// Endpoint documents a route. Params, Headers and Query are structs whose uri, header and form
+// tags are the path, header and query parameters of the route. Body and Response are values of
+// the request and response types, a nil Response documents a response without a body. Path
+// replaces the path of the route in the document, for routes such as /company:method whose
+// parameter is matched by a middleware
+type Endpoint struct {
+	Path         string
+	Summary      string
+	Description  string
+	Tag          string
+	Auth         string
+	Scope        string
+	Params       interface{}
+	Headers      interface{}
+	Query        interface{}
+	Body         interface{}
+	OptionalBody bool
+	Status       int
+	Response     interface{}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// Builder builds a Document, the named struct types it meets become component schemas
+type Builder struct {
+	doc Document
+}
+
+func NewBuilder(title, version string) *Builder {
+	return &Builder{doc: Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version},
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				"apiKey":     {Type: "apiKey", Name: "X-API-Key", In: "header"},
+			},
+		},
+	}}
+}
+
+// Document returns the document built so far
+func (b *Builder) Document() Document {
+	return b.doc
+}
+
+// Add documents the route of method on a gin path such as /api/v1/company/:company-id
+func (b *Builder) Add(method, path string, e Endpoint) {
+	op := &Operation{
+		Summary:     e.Summary,
+		Description: e.Description,
+		Responses:   map[string]Response{},
+	}
+	if e.Tag != "" {
+		op.Tags = []string{e.Tag}
+	}
+
+	switch e.Auth {
+	case AuthRequired:
+		op.Security = []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}}
+	case AuthOptional:
+		op.Security = []map[string][]string{{}, {"bearerAuth": {}}, {"apiKey": {}}}
+	}
+	switch {
+	case e.Scope != "" && e.Auth == AuthOptional:
+		op.Description = strings.TrimSpace("Authenticated callers require the " + e.Scope + " scope. " + op.Description)
+	case e.Scope != "":
+		op.Description = strings.TrimSpace("Requires the " + e.Scope + " scope. " + op.Description)
+	}
+
+	var params []Parameter
+	if e.Params != nil {
+		params = b.parameters(reflect.TypeOf(e.Params), "uri", "path")
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			continue
+		}
+		param := Parameter{Name: segment[1:], In: "path", Schema: &Schema{Type: "string"}}
+		for _, p := range params {
+			if p.Name == param.Name {
+				param = p
+			}
+		}
+		// path parameters are always required
+		param.Required = true
+		op.Parameters = append(op.Parameters, param)
+	}
+	if e.Path != "" {
+		path = e.Path
+	}
+	path = Path(path)
+
+	if e.Headers != nil {
+		op.Parameters = append(op.Parameters, b.parameters(reflect.TypeOf(e.Headers), "header", "header")...)
+	}
+	if e.Query != nil {
+		op.Parameters = append(op.Parameters, b.parameters(reflect.TypeOf(e.Query), "form", "query")...)
+	}
+
+	if e.Body != nil {
+		op.RequestBody = &RequestBody{Required: !e.OptionalBody, Content: b.content(e.Body)}
+	}
+
+	status := e.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := Response{Description: http.StatusText(status)}
+	if e.Response != nil {
+		resp.Content = b.content(e.Response)
+	}
+	op.Responses[strconv.Itoa(status)] = resp
+	op.Responses["default"] = Response{
+		Description: "Error",
+		Content: map[string]MediaType{"application/json": {Schema: &Schema{
+			Type:       "object",
+			Properties: map[string]*Schema{"error": {Type: "string"}},
+		}}},
+	}
+
+	item, ok := b.doc.Paths[path]
+	if !ok {
+		item = PathItem{}
+		b.doc.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = op
+}
+
+// Path returns the form of a gin path used in documents, /company/:company-id becomes
+// /company/{company-id}
+func Path(ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// content returns the media types of a body of the type of v
+func (b *Builder) content(v interface{}) map[string]MediaType {
+	if f, ok := v.(File); ok {
+		content := map[string]MediaType{}
+		for _, ct := range f.ContentTypes {
+			content[ct] = MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+		}
+		return content
+	}
+	return map[string]MediaType{"application/json": {Schema: b.SchemaOf(v)}}
+}
+
+// parameters returns a parameter located in in for every field of t with a tag named tag
+func (b *Builder) parameters(t reflect.Type, tag, in string) (params []Parameter) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get(tag), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		schema := b.schema(f.Type)
+		required := applyRules(schema, f.Type, f.Tag.Get("validate"))
+		params = append(params, Parameter{
+			Name:     name,
+			In:       in,
+			Required: required,
+			Schema:   schema,
+		})
+	}
+	return
+}
+
+// SchemaOf returns the schema of the type of v
+func (b *Builder) SchemaOf(v interface{}) *Schema {
+	switch v := v.(type) {
+	case *Schema:
+		return v
+	case List:
+		return &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"data": {Type: "array", Items: b.SchemaOf(v.Of)},
+			},
+		}
+	}
+	return b.schema(reflect.TypeOf(v))
+}
+
+func (b *Builder) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.doc.Components.Schemas[t.Name()]; !ok {
+			// registered before its fields so that recursive types terminate
+			b.doc.Components.Schemas[t.Name()] = &Schema{}
+			*b.doc.Components.Schemas[t.Name()] = *b.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+// object returns the schema of the json fields of the struct type t, embedded structs
+// contribute their own fields
+func (b *Builder) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner := b.object(embedded)
+				for k, v := range inner.Properties {
+					s.Properties[k] = v
+				}
+				s.Required = append(s.Required, inner.Required...)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		field := b.schema(f.Type)
+		if applyRules(field, f.Type, f.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = field
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// applyRules adds the constraints of the validate tag of a field of type t to its schema and
+// reports whether the field is required. Rules after dive apply to the items of a slice
+func applyRules(s *Schema, t reflect.Type, tag string) (required bool) {
+	if tag == "" {
+		return false
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	rules := strings.Split(tag, ",")
+	for i, rule := range rules {
+		if rule == "dive" {
+			if s.Items != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+				applyRules(s.Items, t.Elem(), strings.Join(rules[i+1:], ","))
+			}
+			return
+		}
+
+		if strings.Contains(rule, "|") {
+			// an OR of eq=A|eq=B allows any of the values
+			for _, alt := range strings.Split(rule, "|") {
+				if value := strings.TrimPrefix(alt, "eq="); value != alt {
+					s.Enum = append(s.Enum, value)
+				}
+			}
+			continue
+		}
+
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			s.Enum = strings.Fields(param)
+		case "eq":
+			s.Enum = []string{param}
+		case "uuid":
+			s.Format = "uuid"
+		case "email":
+			s.Format = "email"
+		case "datetime":
+			if param == time.RFC3339 {
+				s.Format = "date-time"
+			}
+		case "min", "gte":
+			setBound(s, t, param, true)
+		case "max", "lte":
+			setBound(s, t, param, false)
+		}
+	}
+	return
+}
+
+// setBound sets the lower or upper bound param on the length of a string or slice, or on the
+// value of a number
+func setBound(s *Schema, t reflect.Type, param string, lower bool) {
+	switch t.Kind() {
+	case reflect.String:
+		n, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return
+		}
+		if lower {
+			s.MinLength = &n
+		} else {
+			s.MaxLength = &n
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return
+		}
+		if lower {
+			s.MinItems = &n
+		} else {
+			s.MaxItems = &n
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return
+		}
+		if lower {
+			s.Minimum = &n
+		} else {
+			s.Maximum = &n
+		}
+	}
+}
+
+// UI returns a Swagger UI page rendering the document at specURL
+func UI(title, specURL string) string {
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>` + html.EscapeString(title) + `</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@4.15.5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@4.15.5/swagger-ui-bundle.js"></script>
+	<script>
+		window.ui = SwaggerUIBundle({url: ` + strconv.Quote(specURL) + `, dom_id: "#swagger-ui"});
+	</script>
+</body>
+</html>
+`
+}
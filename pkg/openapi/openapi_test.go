@@ -0,0 +1,74 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/danielboakye/go-xm/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+
+	t.Run("schema from validate tags", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		b := NewBuilder("test", "1")
+		assert.Equal(&Schema{Ref: "#/components/schemas/CompanyUpdateReq"}, b.SchemaOf(models.CompanyUpdateReq{}))
+
+		schema, err := json.Marshal(b.Document().Components.Schemas["CompanyUpdateReq"])
+		require.NoError(err)
+		assert.JSONEq(`{
+			"type": "object",
+			"properties": {
+				"name": {"type": "string", "maxLength": 15},
+				"description": {"type": "string", "maxLength": 3000},
+				"amountOfEmployees": {"type": "integer", "format": "int64", "minimum": 0},
+				"registered": {"type": "boolean"},
+				"companyType": {"type": "string", "enum": ["Corporations", "Non Profit", "Cooperative", "Sole Proprietorship"]}
+			}
+		}`, string(schema))
+	})
+
+	t.Run("rules after dive apply to items", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		b := NewBuilder("test", "1")
+		b.SchemaOf(models.APIKeyReq{})
+
+		schema, err := json.Marshal(b.Document().Components.Schemas["APIKeyReq"].Properties["scopes"])
+		require.NoError(err)
+		assert.JSONEq(`{
+			"type": "array",
+			"minItems": 1,
+			"items": {"type": "string", "enum": ["company:read", "company:write", "company:delete", "admin"]}
+		}`, string(schema))
+	})
+
+	t.Run("path and query parameters", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b := NewBuilder("test", "1")
+		b.Add("GET", "/company/:company-id/history", Endpoint{
+			Summary: "history",
+			Query:   models.CompanySearchReq{},
+		})
+
+		op := b.Document().Paths["/company/{company-id}/history"]["get"]
+		if assert.NotNil(op) {
+			max := int64(200)
+			assert.Equal([]Parameter{
+				{Name: "company-id", In: "path", Required: true, Schema: &Schema{Type: "string"}},
+				{Name: "q", In: "query", Required: true, Schema: &Schema{Type: "string", MaxLength: &max}},
+				{Name: "limit", In: "query", Schema: &Schema{Type: "integer", Minimum: float(1), Maximum: float(100)}},
+			}, op.Parameters)
+		}
+	})
+}
+
+func float(f float64) *float64 {
+	return &f
+}
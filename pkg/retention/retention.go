@@ -0,0 +1,103 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/repo"
+)
+
+const purgeBatchSize = 500
+
+// Job permanently deletes companies that have been soft-deleted for longer than the
+// configured retention, which is disabled when zero, along with expired idempotency keys,
+// tokens, finished background jobs, old webhook deliveries and relayed outbox events
+type Job struct {
+	r                 repo.IRepository
+	retention         time.Duration
+	interval          time.Duration
+	jobRetention      time.Duration
+	deliveryRetention time.Duration
+}
+
+func NewJob(r repo.IRepository, cfg config.Configurations) *Job {
+	return &Job{
+		r:                 r,
+		retention:         time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		interval:          cfg.RetentionInterval,
+		jobRetention:      cfg.JobRetention,
+		deliveryRetention: cfg.WebhookRetention,
+	}
+}
+
+// Run purges expired companies, idempotency keys, tokens, jobs, webhook deliveries and relayed outbox events every interval until ctx is cancelled
+func (j *Job) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if j.retention > 0 {
+			purged, err := j.Purge(ctx)
+			if err != nil {
+				log.Println("retention:", err)
+			} else if purged > 0 {
+				log.Printf("retention: purged %d companies", purged)
+			}
+		}
+
+		purged, err := j.r.PurgeExpiredIdempotencyKeys(ctx)
+		if err != nil {
+			log.Println("retention:", err)
+		} else if purged > 0 {
+			log.Printf("retention: purged %d idempotency keys", purged)
+		}
+
+		purged, err = j.r.PurgeExpiredTokens(ctx)
+		if err != nil {
+			log.Println("retention:", err)
+		} else if purged > 0 {
+			log.Printf("retention: purged %d expired tokens", purged)
+		}
+
+		purged, err = j.r.PurgeFinishedJobs(ctx, time.Now().Add(-j.jobRetention))
+		if err != nil {
+			log.Println("retention:", err)
+		} else if purged > 0 {
+			log.Printf("retention: purged %d finished jobs", purged)
+		}
+
+		purged, err = j.r.PurgeWebhookDeliveries(ctx, time.Now().Add(-j.deliveryRetention))
+		if err != nil {
+			log.Println("retention:", err)
+		} else if purged > 0 {
+			log.Printf("retention: purged %d webhook deliveries", purged)
+		}
+
+		purged, err = j.r.PurgeRelayedOutboxEvents(ctx)
+		if err != nil {
+			log.Println("retention:", err)
+		} else if purged > 0 {
+			log.Printf("retention: purged %d relayed outbox events", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes the companies of every tenant whose retention has expired, in batches
+func (j *Job) Purge(ctx context.Context) (purged int, err error) {
+	deletedBefore := time.Now().Add(-j.retention)
+	for {
+		n, err := j.r.PurgeDeletedCompanies(ctx, "", deletedBefore, purgeBatchSize)
+		purged += n
+		if err != nil || n < purgeBatchSize {
+			return purged, err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/repo"
+)
+
+// sweepThreshold is the number of cached tokens above which expired entries are dropped
+const sweepThreshold = 10_000
+
+// IStore decides whether a token has been revoked
+type IStore interface {
+	IsRevoked(ctx context.Context, claims *helpers.JWTClaims) (bool, error)
+	RevokeToken(ctx context.Context, claims *helpers.JWTClaims) error
+	RevokeUserTokens(ctx context.Context, userID string) error
+}
+
+type entry struct {
+	revoked bool
+	// until is when the entry must be looked up again
+	until time.Time
+}
+
+// Store records revocations in the repository and caches lookups in memory. A token found
+// revoked stays cached until it expires, one found valid is looked up again after the cache
+// ttl, which bounds how long a revocation made by another instance takes to apply
+type Store struct {
+	r        repo.IRepository
+	cacheTTL time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]entry
+	users  map[string]time.Time
+}
+
+func NewStore(r repo.IRepository, cfg config.Configurations) IStore {
+	return &Store{
+		r:        r,
+		cacheTTL: cfg.RevocationCacheTTL,
+		tokens:   make(map[string]entry),
+		users:    make(map[string]time.Time),
+	}
+}
+
+// IsRevoked reports whether the token of claims was revoked, either on its own or along with
+// every token of its user
+func (s *Store) IsRevoked(ctx context.Context, claims *helpers.JWTClaims) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if before, ok := s.users[claims.UserID]; ok && issuedBefore(claims, before) {
+		s.mu.Unlock()
+		return true, nil
+	}
+	if e, ok := s.tokens[claims.ID]; ok && now.Before(e.until) {
+		s.mu.Unlock()
+		return e.revoked, nil
+	}
+	s.mu.Unlock()
+
+	revoked, revokedBefore, err := s.r.GetTokenRevocation(ctx, claims.ID, claims.UserID)
+	if err != nil {
+		return false, err
+	}
+	if revokedBefore != nil && issuedBefore(claims, *revokedBefore) {
+		revoked = true
+	}
+
+	until := now.Add(s.cacheTTL)
+	if revoked {
+		until = expiry(claims)
+	}
+	s.cache(claims.ID, entry{revoked: revoked, until: until})
+
+	return revoked, nil
+}
+
+// RevokeToken revokes the token of claims until it expires
+func (s *Store) RevokeToken(ctx context.Context, claims *helpers.JWTClaims) error {
+	if err := s.r.RevokeToken(ctx, claims.ID, claims.UserID, expiry(claims)); err != nil {
+		return err
+	}
+
+	s.cache(claims.ID, entry{revoked: true, until: expiry(claims)})
+	return nil
+}
+
+// RevokeUserTokens revokes every token issued to userID so far. Issue times are truncated to
+// the precision of tokens, so the revocation time is rounded up to it
+func (s *Store) RevokeUserTokens(ctx context.Context, userID string) error {
+	before := time.Now().Truncate(helpers.TokenTimePrecision).Add(helpers.TokenTimePrecision)
+	if err := s.r.RevokeUserTokens(ctx, userID, before); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.users[userID] = before
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Store) cache(jti string, e entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.tokens) >= sweepThreshold {
+		now := time.Now()
+		for k, v := range s.tokens {
+			if !now.Before(v.until) {
+				delete(s.tokens, k)
+			}
+		}
+	}
+	s.tokens[jti] = e
+}
+
+// issuedBefore reports whether the token of claims was issued before t
+func issuedBefore(claims *helpers.JWTClaims, t time.Time) bool {
+	return claims.IssuedAt == nil || claims.IssuedAt.Time.Before(t)
+}
+
+func expiry(claims *helpers.JWTClaims) time.Time {
+	if claims.ExpiresAt == nil {
+		return time.Now()
+	}
+	return claims.ExpiresAt.Time
+}
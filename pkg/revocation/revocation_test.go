@@ -0,0 +1,178 @@
+package revocation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testUserID = "af7c1fe6-d669-414e-b066-e9733f0de7a8"
+
+const getTokenRevocationQuery = `
+	SELECT
+		EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1),
+		(SELECT revoked_before FROM user_token_revocations WHERE user_id = $2)
+`
+
+func newTestStore(t *testing.T) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, IStore) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, mockDB := dbtest.New(t)
+
+	store := NewStore(repo.NewRepository(db), config.Configurations{RevocationCacheTTL: time.Minute})
+
+	return assert, require, mockDB, store
+}
+
+func testClaims(jti string, issuedAt time.Time) *helpers.JWTClaims {
+	return &helpers.JWTClaims{
+		UserID: testUserID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)),
+		},
+	}
+}
+
+func TestIsRevokedCachesLookups(t *testing.T) {
+	assert, require, mockDB, store := newTestStore(t)
+
+	mockDB.ExpectQuery(getTokenRevocationQuery).
+		WithArgs("c0ffee00-0000-4000-8000-000000000001", testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists", "revoked_before"}).AddRow(false, nil))
+
+	claims := testClaims("c0ffee00-0000-4000-8000-000000000001", time.Now())
+
+	for i := 0; i < 3; i++ {
+		revoked, err := store.IsRevoked(context.Background(), claims)
+		require.NoError(err)
+		assert.False(revoked)
+	}
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestIsRevokedByUserRevocation(t *testing.T) {
+	assert, require, mockDB, store := newTestStore(t)
+
+	revokedBefore := time.Now().UTC()
+
+	mockDB.ExpectQuery(getTokenRevocationQuery).
+		WithArgs("c0ffee00-0000-4000-8000-000000000002", testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists", "revoked_before"}).AddRow(false, revokedBefore))
+
+	mockDB.ExpectQuery(getTokenRevocationQuery).
+		WithArgs("c0ffee00-0000-4000-8000-000000000003", testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists", "revoked_before"}).AddRow(false, revokedBefore))
+
+	revoked, err := store.IsRevoked(context.Background(), testClaims("c0ffee00-0000-4000-8000-000000000002", revokedBefore.Add(-time.Minute)))
+	require.NoError(err)
+	assert.True(revoked, "a token issued before the revocation must be rejected")
+
+	revoked, err = store.IsRevoked(context.Background(), testClaims("c0ffee00-0000-4000-8000-000000000003", revokedBefore.Add(time.Minute)))
+	require.NoError(err)
+	assert.False(revoked, "a token issued after the revocation must be accepted")
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestIsRevokedWithinTheSecondOfTheRevocation(t *testing.T) {
+	assert, require, mockDB, store := newTestStore(t)
+
+	revokedBefore := time.Date(2023, 3, 1, 10, 0, 0, 900*int(time.Millisecond), time.UTC)
+
+	mockDB.ExpectQuery(getTokenRevocationQuery).
+		WithArgs("c0ffee00-0000-4000-8000-000000000005", testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists", "revoked_before"}).AddRow(false, revokedBefore))
+
+	mockDB.ExpectQuery(getTokenRevocationQuery).
+		WithArgs("c0ffee00-0000-4000-8000-000000000006", testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists", "revoked_before"}).AddRow(false, revokedBefore))
+
+	revoked, err := store.IsRevoked(context.Background(), testClaims("c0ffee00-0000-4000-8000-000000000005", revokedBefore.Add(-50*time.Millisecond)))
+	require.NoError(err)
+	assert.True(revoked, "a token issued earlier in the second of the revocation must be rejected")
+
+	revoked, err = store.IsRevoked(context.Background(), testClaims("c0ffee00-0000-4000-8000-000000000006", revokedBefore.Add(50*time.Millisecond)))
+	require.NoError(err)
+	assert.False(revoked, "a token issued later in the second of the revocation must be accepted")
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestRevokeUserTokensRejectsTokenIssuedJustBefore(t *testing.T) {
+	assert, require, mockDB, store := newTestStore(t)
+
+	claims := testClaims("c0ffee00-0000-4000-8000-000000000007", time.Now())
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(`
+		INSERT INTO user_token_revocations (user_id, revoked_before)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET
+			revoked_before = EXCLUDED.revoked_before
+	`).
+		WithArgs(testUserID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(`
+		UPDATE refresh_tokens
+		SET
+			revoked_at = CURRENT_TIMESTAMP
+		WHERE
+			user_id::text = $1
+			AND revoked_at IS NULL
+	`).
+		WithArgs(testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	require.NoError(store.RevokeUserTokens(context.Background(), testUserID))
+
+	revoked, err := store.IsRevoked(context.Background(), claims)
+	require.NoError(err)
+	assert.True(revoked, "a token issued in the microsecond before the revocation must be rejected")
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestRevokeTokenAppliesImmediately(t *testing.T) {
+	assert, require, mockDB, store := newTestStore(t)
+
+	claims := testClaims("c0ffee00-0000-4000-8000-000000000004", time.Now())
+
+	mockDB.ExpectQuery(getTokenRevocationQuery).
+		WithArgs(claims.ID, testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists", "revoked_before"}).AddRow(false, nil))
+
+	mockDB.ExpectExec(`
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`).
+		WithArgs(claims.ID, testUserID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	revoked, err := store.IsRevoked(context.Background(), claims)
+	require.NoError(err)
+	assert.False(revoked)
+
+	require.NoError(store.RevokeToken(context.Background(), claims))
+
+	revoked, err = store.IsRevoked(context.Background(), claims)
+	require.NoError(err)
+	assert.True(revoked, "a cached lookup must not outlive a local revocation")
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
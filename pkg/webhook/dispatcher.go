@@ -0,0 +1,272 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/repo"
+)
+
+const (
+	// queueBatchSize is the most outbox events queued for webhooks per query
+	queueBatchSize = 100
+	// deliverBatchSize is the most deliveries sent at once
+	deliverBatchSize = 20
+	// maxErrorLength bounds the error and the response body recorded for a failed attempt
+	maxErrorLength = 500
+)
+
+// Dispatcher posts the changes of the companies to the webhooks subscribed to them. Every
+// outbox event is queued as a delivery to each matching webhook, deliveries are signed with
+// the secret of their webhook and retried with exponential backoff and jitter until they
+// succeed or run out of attempts. A webhook failing too many deliveries in a row is disabled.
+// Deliveries are only sent to public addresses and redirects are not followed, so that a
+// webhook cannot reach the internal network
+type Dispatcher struct {
+	r            repo.IRepository
+	client       *http.Client
+	interval     time.Duration
+	timeout      time.Duration
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	disableAfter int
+}
+
+func NewDispatcher(r repo.IRepository, cfg config.Configurations) *Dispatcher {
+	return &Dispatcher{
+		r:            r,
+		client:       newClient(cfg.WebhookTimeout, publicAddressOnly),
+		interval:     cfg.WebhookPollInterval,
+		timeout:      cfg.WebhookTimeout,
+		maxAttempts:  cfg.WebhookMaxAttempts,
+		backoff:      cfg.WebhookBackoff,
+		maxBackoff:   cfg.WebhookMaxBackoff,
+		disableAfter: cfg.WebhookDisableAfter,
+	}
+}
+
+// blockedNetworks are the networks that are neither private, loopback nor link-local yet
+// are not reachable on the internet either, and the IPv6 prefixes embedding an IPv4 address
+// that a gateway would reach on behalf of the dispatcher, private ones included
+var blockedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+	// NAT64
+	mustParseCIDR("64:ff9b::/96"),
+	mustParseCIDR("64:ff9b:1::/48"),
+	// Teredo
+	mustParseCIDR("2001::/32"),
+	// 6to4
+	mustParseCIDR("2002::/16"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// newClient returns the client sending deliveries, which does not follow redirects. control
+// runs on every connection once the host of the webhook is resolved, proxies are not used so
+// that it sees the address of the webhook
+func newClient(timeout time.Duration, control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: control}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicAddressOnly refuses to connect to loopback, private, link-local and other addresses
+// that are not public
+func publicAddressOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublic(ip) {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
+
+// isPublic reports whether ip is an address of the internet
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Run queues and sends deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		queued, err := d.Queue(ctx)
+		if err != nil {
+			log.Println("webhooks:", err)
+		}
+
+		sent, err := d.Deliver(ctx)
+		if err != nil {
+			log.Println("webhooks:", err)
+		}
+
+		wait := d.interval
+		if queued == queueBatchSize || sent == deliverBatchSize {
+			// there may be more pending events or deliveries, keep draining
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Queue queues a delivery of the outbox events not queued yet to every webhook subscribed to
+// them, and returns how many events were queued
+func (d *Dispatcher) Queue(ctx context.Context) (queued int, err error) {
+	return d.r.QueueWebhookDeliveries(ctx, queueBatchSize)
+}
+
+// Deliver sends the deliveries that are due concurrently and returns how many were sent.
+// Deliveries are held for twice the timeout while they are sent, a delivery interrupted by
+// a stop is sent again once that lease expires
+func (d *Dispatcher) Deliver(ctx context.Context) (sent int, err error) {
+	deliveries, err := d.r.ClaimWebhookDeliveries(ctx, deliverBatchSize, 2*d.timeout)
+	if err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery models.PendingDelivery) {
+			defer wg.Done()
+
+			attempt := d.send(ctx, delivery)
+			if ctx.Err() != nil {
+				// interrupted, left to be claimed again
+				return
+			}
+
+			disabled, err := d.r.RecordWebhookAttempt(ctx, attempt, d.disableAfter)
+			if err != nil {
+				log.Println("webhooks:", err)
+				return
+			}
+			if disabled {
+				log.Printf("webhooks: disabled webhook %s after %d failed deliveries in a row", delivery.WebhookID, d.disableAfter)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+
+	sent = len(deliveries)
+	return
+}
+
+// send posts a delivery and returns the outcome of the attempt. Any 2xx response is a success
+func (d *Dispatcher) send(ctx context.Context, delivery models.PendingDelivery) (attempt models.WebhookAttempt) {
+	attempt = models.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		WebhookID:  delivery.WebhookID,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return d.failed(attempt, delivery.Attempts, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-xm-webhooks/1")
+	req.Header.Set("X-Webhook-Id", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set(helpers.WebhookSignatureHeader, helpers.SignWebhook(delivery.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return d.failed(attempt, delivery.Attempts, err.Error())
+	}
+	defer resp.Body.Close()
+
+	status := resp.StatusCode
+	attempt.ResponseStatus = &status
+	if status >= 200 && status < 300 {
+		attempt.Succeeded = true
+		return
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+	return d.failed(attempt, delivery.Attempts, fmt.Sprintf("unexpected status %d: %s", status, body))
+}
+
+// failed records why an attempt failed and schedules the next one, unless attempts made before
+// this one plus this one exhaust the attempts
+func (d *Dispatcher) failed(attempt models.WebhookAttempt, attempts int, reason string) models.WebhookAttempt {
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+	attempt.Error = reason
+
+	if attempts+1 < d.maxAttempts {
+		next := time.Now().Add(Backoff(d.backoff, d.maxBackoff, attempts+1))
+		attempt.NextAttemptAt = &next
+	}
+	return attempt
+}
+
+// Backoff returns how long to wait before retrying after the attempt-th failed attempt: base
+// doubled for every attempt after the first and capped at max, of which a random half is
+// waited so that failed deliveries do not all retry at once
+func Backoff(base, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
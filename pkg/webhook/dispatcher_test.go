@@ -0,0 +1,305 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/config"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/pkg/dbtest"
+	"github.com/danielboakye/go-xm/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDeliveryID = "d1e2f3a4-0000-4000-8000-000000000001"
+	testWebhookID  = "a1b2c3d4-0000-4000-8000-000000000002"
+	testEventID    = "e1e2e3e4-0000-4000-8000-000000000003"
+	testSecret     = "whsec_test"
+	testPayload    = `{"id":"e1e2e3e4-0000-4000-8000-000000000003","type":"company.updated"}`
+)
+
+const claimDeliveriesQuery = `
+	UPDATE webhook_deliveries d
+	SET
+		next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+	FROM webhooks w
+	WHERE w.webhook_id = d.webhook_id
+		AND d.delivery_id IN (
+			SELECT
+				pending.delivery_id
+			FROM webhook_deliveries pending
+			JOIN webhooks hook ON hook.webhook_id = pending.webhook_id
+			WHERE pending.status = 'pending'
+				AND pending.next_attempt_at <= CURRENT_TIMESTAMP
+				AND hook.enabled
+			ORDER BY pending.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF pending SKIP LOCKED
+		)
+	RETURNING d.delivery_id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_attempt_at, d.response_status, d.last_error, d.replay_of, d.created_at, d.delivered_at, w.url, w.secret
+`
+
+const recordDeliveryQuery = `
+	UPDATE webhook_deliveries
+	SET
+		status = $2,
+		attempts = attempts + 1,
+		next_attempt_at = coalesce($3, next_attempt_at),
+		last_attempt_at = CURRENT_TIMESTAMP,
+		response_status = $4,
+		last_error = $5,
+		delivered_at = CASE WHEN $2 = 'succeeded' THEN CURRENT_TIMESTAMP END
+	WHERE
+		delivery_id::text = $1
+`
+
+const resetFailuresQuery = `
+	UPDATE webhooks
+	SET
+		consecutive_failures = 0
+	WHERE
+		webhook_id::text = $1
+`
+
+const countFailureQuery = `
+	UPDATE webhooks
+	SET
+		consecutive_failures = consecutive_failures + 1,
+		enabled = enabled AND consecutive_failures + 1 < $2,
+		disabled_at = CASE WHEN enabled AND consecutive_failures + 1 >= $2 THEN CURRENT_TIMESTAMP ELSE disabled_at END
+	WHERE
+		webhook_id::text = $1
+	RETURNING enabled
+`
+
+func newTestDispatcher(t *testing.T) (*assert.Assertions, *require.Assertions, sqlmock.Sqlmock, *Dispatcher) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, mockDB := dbtest.New(t)
+
+	dispatcher := NewDispatcher(repo.NewRepository(db), config.Configurations{
+		WebhookPollInterval: time.Second,
+		WebhookTimeout:      time.Second,
+		WebhookMaxAttempts:  3,
+		WebhookBackoff:      time.Minute,
+		WebhookMaxBackoff:   time.Hour,
+		WebhookDisableAfter: 5,
+	})
+	// the test receivers listen on loopback
+	dispatcher.client = newClient(time.Second, nil)
+
+	return assert, require, mockDB, dispatcher
+}
+
+func claimedRows(url string, attempts int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"delivery_id", "webhook_id", "event_id", "event_type", "payload", "status", "attempts", "next_attempt_at",
+		"last_attempt_at", "response_status", "last_error", "replay_of", "created_at", "delivered_at", "url", "secret",
+	}).AddRow(
+		testDeliveryID, testWebhookID, testEventID, "company.updated", []byte(testPayload), "pending", attempts, time.Now(),
+		nil, nil, nil, nil, time.Now(), nil, url, testSecret,
+	)
+}
+
+func TestDeliverSignsTheBody(t *testing.T) {
+	assert, require, mockDB, dispatcher := newTestDispatcher(t)
+
+	var received *http.Request
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	mockDB.ExpectQuery(claimDeliveriesQuery).
+		WithArgs(deliverBatchSize, 2.0).
+		WillReturnRows(claimedRows(receiver.URL, 0))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(recordDeliveryQuery).
+		WithArgs(testDeliveryID, "succeeded", nil, http.StatusNoContent, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(resetFailuresQuery).
+		WithArgs(testWebhookID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	sent, err := dispatcher.Deliver(context.Background())
+	require.NoError(err)
+	assert.Equal(1, sent)
+
+	require.NotNil(received)
+	assert.Equal(testPayload, string(body))
+	assert.Equal(testDeliveryID, received.Header.Get("X-Webhook-Id"))
+	assert.Equal("company.updated", received.Header.Get("X-Webhook-Event"))
+
+	// the receiver recomputes the signature from the timestamp it was sent with
+	signature := received.Header.Get(helpers.WebhookSignatureHeader)
+	require.True(strings.HasPrefix(signature, "t="))
+	unix := strings.SplitN(strings.TrimPrefix(signature, "t="), ",", 2)[0]
+	sentAt, err := strconv.ParseInt(unix, 10, 64)
+	require.NoError(err)
+	assert.Equal(helpers.SignWebhook(testSecret, time.Unix(sentAt, 0), body), signature)
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestDeliverRetriesFailures(t *testing.T) {
+	assert, require, mockDB, dispatcher := newTestDispatcher(t)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	mockDB.ExpectQuery(claimDeliveriesQuery).
+		WithArgs(deliverBatchSize, 2.0).
+		WillReturnRows(claimedRows(receiver.URL, 0))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(recordDeliveryQuery).
+		WithArgs(testDeliveryID, "pending", sqlmock.AnyArg(), http.StatusServiceUnavailable, "unexpected status 503: down for maintenance\n").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(countFailureQuery).
+		WithArgs(testWebhookID, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(true))
+	mockDB.ExpectCommit()
+
+	_, err := dispatcher.Deliver(context.Background())
+	require.NoError(err)
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestDeliverGivesUpAfterTheLastAttempt(t *testing.T) {
+	assert, require, mockDB, dispatcher := newTestDispatcher(t)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer receiver.Close()
+
+	mockDB.ExpectQuery(claimDeliveriesQuery).
+		WithArgs(deliverBatchSize, 2.0).
+		WillReturnRows(claimedRows(receiver.URL, 2))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(recordDeliveryQuery).
+		WithArgs(testDeliveryID, "failed", nil, http.StatusGone, "unexpected status 410: ").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(countFailureQuery).
+		WithArgs(testWebhookID, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(false))
+	mockDB.ExpectCommit()
+
+	_, err := dispatcher.Deliver(context.Background())
+	require.NoError(err)
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestDeliverRefusesPrivateAddresses(t *testing.T) {
+	assert, require, mockDB, dispatcher := newTestDispatcher(t)
+	dispatcher.client = newClient(time.Second, publicAddressOnly)
+
+	var called bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer receiver.Close()
+
+	mockDB.ExpectQuery(claimDeliveriesQuery).
+		WithArgs(deliverBatchSize, 2.0).
+		WillReturnRows(claimedRows(receiver.URL, 0))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(recordDeliveryQuery).
+		WithArgs(testDeliveryID, "pending", sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(countFailureQuery).
+		WithArgs(testWebhookID, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(true))
+	mockDB.ExpectCommit()
+
+	_, err := dispatcher.Deliver(context.Background())
+	require.NoError(err)
+
+	assert.False(called)
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestDeliverDoesNotFollowRedirects(t *testing.T) {
+	assert, require, mockDB, dispatcher := newTestDispatcher(t)
+
+	var redirected bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer internal.Close()
+
+	receiver := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusTemporaryRedirect))
+	defer receiver.Close()
+
+	mockDB.ExpectQuery(claimDeliveriesQuery).
+		WithArgs(deliverBatchSize, 2.0).
+		WillReturnRows(claimedRows(receiver.URL, 0))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(recordDeliveryQuery).
+		WithArgs(testDeliveryID, "pending", sqlmock.AnyArg(), http.StatusTemporaryRedirect, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(countFailureQuery).
+		WithArgs(testWebhookID, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(true))
+	mockDB.ExpectCommit()
+
+	_, err := dispatcher.Deliver(context.Background())
+	require.NoError(err)
+
+	assert.False(redirected)
+
+	assert.NoError(mockDB.ExpectationsWereMet())
+}
+
+func TestPublicAddressOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, address := range []string{
+		"127.0.0.1:443", "10.1.2.3:443", "172.16.0.1:443", "192.168.1.1:443", "169.254.169.254:80",
+		"100.64.0.1:443", "0.0.0.0:443", "[::1]:443", "[fd00::1]:443", "[fe80::1]:443",
+		// 127.0.0.1 through NAT64, 10.0.0.1 through 6to4 and a Teredo address
+		"[64:ff9b::7f00:1]:443", "[2002:a00:1::1]:443", "[2001:0:4136:e378:8000:63bf:3fff:fdd2]:443",
+	} {
+		assert.Error(publicAddressOnly("tcp", address, nil), address)
+	}
+
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1:248:1893:25c8:1946]:443"} {
+		assert.NoError(publicAddressOnly("tcp", address, nil), address)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	for i := 0; i < 100; i++ {
+		first := Backoff(time.Minute, time.Hour, 1)
+		assert.GreaterOrEqual(first, 30*time.Second)
+		assert.LessOrEqual(first, time.Minute)
+
+		third := Backoff(time.Minute, time.Hour, 3)
+		assert.GreaterOrEqual(third, 2*time.Minute)
+		assert.LessOrEqual(third, 4*time.Minute)
+
+		capped := Backoff(time.Minute, time.Hour, 20)
+		assert.GreaterOrEqual(capped, 30*time.Minute)
+		assert.LessOrEqual(capped, time.Hour)
+	}
+}
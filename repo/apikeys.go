@@ -0,0 +1,172 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+// apiKeyColumns are the columns scanned by scanAPIKey
+const apiKeyColumns = `key_id, prefix, name, owner_id, tenant_id, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+// apiKeyTouchInterval is how stale last_used_at may get before a use of the key updates it,
+// which spares a write on every request
+const apiKeyTouchInterval = time.Minute
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }, dest ...interface{}) (key models.APIKey, err error) {
+	var scopes []byte
+	err = row.Scan(append([]interface{}{
+		&key.ID,
+		&key.Prefix,
+		&key.Name,
+		&key.OwnerID,
+		&key.TenantID,
+		&scopes,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.CreatedAt,
+	}, dest...)...)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(scopes, &key.Scopes)
+	return
+}
+
+// CreateAPIKey stores an API key of ownerID by the hash of its secret
+func (r *Repository) CreateAPIKey(
+	ctx context.Context,
+	prefix string,
+	hash string,
+	name string,
+	ownerID string,
+	tenantID string,
+	scopes []string,
+	expiresAt *time.Time,
+) (key models.APIKey, err error) {
+
+	payload, err := json.Marshal(scopes)
+	if err != nil {
+		return
+	}
+
+	var expires *time.Time
+	if expiresAt != nil {
+		t := expiresAt.UTC()
+		expires = &t
+	}
+
+	key, err = scanAPIKey(r.db.QueryRowContext(ctx, `
+			INSERT INTO api_keys (prefix, key_hash, name, owner_id, tenant_id, scopes, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING `+apiKeyColumns+`
+		`,
+		prefix, hash, name, ownerID, tenantID, payload, expires,
+	))
+	return
+}
+
+// ListAPIKeys returns the API keys of ownerID, revoked and expired ones included
+func (r *Repository) ListAPIKeys(
+	ctx context.Context,
+	ownerID string,
+) (keys []models.APIKey, err error) {
+
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				`+apiKeyColumns+`
+			FROM api_keys
+			WHERE owner_id::text = $1
+			ORDER BY created_at, key_id
+		`,
+		ownerID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	keys = []models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		if key, err = scanAPIKey(rows); err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	err = rows.Err()
+	return
+}
+
+// RevokeAPIKey revokes the API key keyID of ownerID. Revoking a revoked key keeps the time
+// it was first revoked
+func (r *Repository) RevokeAPIKey(
+	ctx context.Context,
+	ownerID string,
+	keyID string,
+) (err error) {
+
+	res, err := r.db.ExecContext(ctx, `
+			UPDATE api_keys
+			SET
+				revoked_at = coalesce(revoked_at, CURRENT_TIMESTAMP)
+			WHERE
+				key_id::text = $1
+				AND owner_id::text = $2
+		`,
+		keyID, ownerID,
+	)
+	if err != nil {
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return
+}
+
+// GetAPIKeyByPrefix returns the API key identified by prefix along with the hash of its secret
+func (r *Repository) GetAPIKeyByPrefix(
+	ctx context.Context,
+	prefix string,
+) (key models.APIKey, hash string, err error) {
+
+	key, err = scanAPIKey(r.db.QueryRowContext(ctx, `
+			SELECT
+				`+apiKeyColumns+`, key_hash
+			FROM api_keys
+			WHERE prefix = $1
+		`,
+		prefix,
+	), &hash)
+	return
+}
+
+// TouchAPIKey records that the API key keyID has just been used
+func (r *Repository) TouchAPIKey(
+	ctx context.Context,
+	keyID string,
+) (err error) {
+
+	_, err = r.db.ExecContext(ctx, `
+			UPDATE api_keys
+			SET
+				last_used_at = CURRENT_TIMESTAMP
+			WHERE
+				key_id::text = $1
+				AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2))
+		`,
+		keyID, apiKeyTouchInterval.Seconds(),
+	)
+	return
+}
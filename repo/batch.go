@@ -0,0 +1,267 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/kfkp"
+)
+
+// ErrBatchAborted is returned when an atomic batch is rolled back because one of its changes failed
+var ErrBatchAborted = errors.New("company batch rolled back")
+
+// maxInsertRows bounds the rows of a multi-row insert, keeping the history insert well below the
+// 65535 parameters postgres accepts in a statement
+const maxInsertRows = 500
+
+// BatchCompanies applies changes in order within one transaction, consecutive creates are
+// inserted with a single statement. An atomic batch stops at the first failed change and fails
+// with ErrBatchAborted, leaving the results of the changes after it empty. A best-effort batch
+// rolls back the failed changes only, with a savepoint around each statement
+func (r *Repository) BatchCompanies(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	changes []models.CompanyChange,
+	atomic bool,
+) (results []models.CompanyChangeResult, err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	results = make([]models.CompanyChangeResult, len(changes))
+	for i := 0; i < len(changes); {
+		end := i + 1
+		if changes[i].Op == models.BatchOpCreate {
+			for end < len(changes) && end-i < maxInsertRows && changes[end].Op == models.BatchOpCreate {
+				end++
+			}
+		}
+
+		if !atomic {
+			if _, err = tx.ExecContext(ctx, `SAVEPOINT batch_change`); err != nil {
+				return
+			}
+		}
+
+		var failed error
+		if changes[i].Op == models.BatchOpCreate {
+			failed = insertCompanies(ctx, tx, tenantID, userID, changes[i:end], results[i:end])
+		} else {
+			failed = applyChange(ctx, tx, tenantID, userID, changes[i], &results[i])
+		}
+
+		if failed != nil {
+			for j := i; j < end; j++ {
+				results[j] = models.CompanyChangeResult{Err: failed}
+			}
+		}
+
+		if atomic {
+			for j := i; j < end; j++ {
+				if results[j].Err != nil {
+					return results, ErrBatchAborted
+				}
+			}
+		} else if failed != nil {
+			if _, err = tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch_change`); err != nil {
+				return
+			}
+		} else {
+			if _, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT batch_change`); err != nil {
+				return
+			}
+		}
+
+		i = end
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// applyChange applies an update or a delete within tx. A failed change leaves tx aborted
+func applyChange(
+	ctx context.Context,
+	tx *sql.Tx,
+	tenantID string,
+	userID string,
+	change models.CompanyChange,
+	result *models.CompanyChangeResult,
+) (err error) {
+
+	var version int64
+	switch change.Op {
+	case models.BatchOpUpdate:
+		version, err = updateCompany(
+			ctx, tx, tenantID, userID, change.CompanyID, change.ExpectedVersion,
+			change.Update.Name,
+			change.Update.Description,
+			change.Update.AmountOfEmployees,
+			change.Update.Registered,
+			change.Update.CompanyType,
+		)
+	case models.BatchOpDelete:
+		version, err = deleteCompany(ctx, tx, tenantID, userID, change.CompanyID, change.ExpectedVersion)
+	default:
+		err = errors.New("unknown company batch operation " + change.Op)
+	}
+	if err != nil {
+		return
+	}
+
+	*result = models.CompanyChangeResult{CompanyID: change.CompanyID, Version: version}
+	return
+}
+
+// valuesRows returns the VALUES rows of a multi-row statement binding cols parameters per row,
+// numbered from $1
+func valuesRows(rows int, cols int) string {
+	var b strings.Builder
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for j := 0; j < cols; j++ {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("$" + strconv.Itoa(i*cols+j+1))
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// insertCompanies creates the companies of changes with a multi-row insert and records their
+// history and outbox events. A change whose name is taken, by an active company or by an earlier
+// change, gets ErrDuplicateName in its result without failing the others. The returned error
+// fails every change and leaves tx aborted
+func insertCompanies(
+	ctx context.Context,
+	tx *sql.Tx,
+	tenantID string,
+	userID string,
+	changes []models.CompanyChange,
+	results []models.CompanyChangeResult,
+) (err error) {
+
+	args := make([]interface{}, 0, len(changes)*6)
+	for _, change := range changes {
+		c := change.Company
+		args = append(args, tenantID, c.Name, c.Description, c.AmountOfEmployees, c.Registered, c.CompanyType)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+			INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type)
+			VALUES `+valuesRows(len(changes), 6)+`
+			ON CONFLICT (tenant_id, company_name) WHERE deleted_at IS NULL DO NOTHING
+			RETURNING company_id, company_name
+		`,
+		args...,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	created := make(map[string]string, len(changes))
+	for rows.Next() {
+		var companyID, name string
+		if err = rows.Scan(&companyID, &name); err != nil {
+			return
+		}
+		created[name] = companyID
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	companies := make([]models.Company, 0, len(created))
+	for i, change := range changes {
+		companyID, ok := created[change.Company.Name]
+		if !ok {
+			results[i] = models.CompanyChangeResult{Err: ErrDuplicateName}
+			continue
+		}
+		delete(created, change.Company.Name)
+
+		after := change.Company
+		after.ID = companyID
+		after.Version = 1
+		companies = append(companies, after)
+		results[i] = models.CompanyChangeResult{CompanyID: companyID, Version: after.Version}
+	}
+
+	err = recordCreations(ctx, tx, tenantID, userID, companies)
+	return
+}
+
+// recordCreations writes the history entries and the outbox events of newly created companies
+// like recordChange does, with one statement each
+func recordCreations(
+	ctx context.Context,
+	tx *sql.Tx,
+	tenantID string,
+	userID string,
+	companies []models.Company,
+) (err error) {
+
+	if len(companies) == 0 {
+		return
+	}
+
+	historyArgs := make([]interface{}, 0, len(companies)*11)
+	outboxArgs := make([]interface{}, 0, len(companies)*4)
+	for _, after := range companies {
+		diff := companyDiff(nil, after)
+
+		var payload []byte
+		if payload, err = json.Marshal(diff); err != nil {
+			return
+		}
+		historyArgs = append(historyArgs,
+			tenantID, after.ID, after.Version, strings.TrimPrefix(kfkp.EventCompanyCreated, "company."),
+			after.Name, after.Description, after.AmountOfEmployees, after.Registered, after.CompanyType,
+			payload, userID,
+		)
+
+		changes := make(map[string]interface{}, len(diff))
+		for field, change := range diff {
+			changes[field] = change.To
+		}
+		event := kfkp.NewEvent(kfkp.EventCompanyCreated, tenantID, after.ID, userID, changes)
+
+		if payload, err = json.Marshal(event); err != nil {
+			return
+		}
+		outboxArgs = append(outboxArgs, event.ID, event.CompanyID, event.Type, payload)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			INSERT INTO companies_history (tenant_id, company_id, version, operation, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by)
+			VALUES `+valuesRows(len(companies), 11)+`
+		`,
+		historyArgs...,
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			INSERT INTO company_outbox (event_id, company_id, event_type, payload)
+			VALUES `+valuesRows(len(companies), 4)+`
+		`,
+		outboxArgs...,
+	)
+	return
+}
@@ -0,0 +1,304 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/kfkp"
+)
+
+// companyDiff returns the fields that differ between before and after, keyed by their
+// json name. before is nil for a newly created company
+func companyDiff(before *models.Company, after models.Company) map[string]models.FieldChange {
+	var prev models.Company
+	if before != nil {
+		prev = *before
+	}
+
+	diff := make(map[string]models.FieldChange)
+	add := func(field string, from, to interface{}) {
+		if before == nil {
+			from = nil
+		} else if from == to {
+			return
+		}
+		diff[field] = models.FieldChange{From: from, To: to}
+	}
+
+	add("name", prev.Name, after.Name)
+	add("description", prev.Description, after.Description)
+	add("amountOfEmployees", prev.AmountOfEmployees, after.AmountOfEmployees)
+	add("registered", prev.Registered, after.Registered)
+	add("companyType", prev.CompanyType, after.CompanyType)
+
+	return diff
+}
+
+// recordChange writes the history entry and the outbox event of a company mutation
+// within its transaction. after is the state of the company once the mutation is applied
+func recordChange(
+	ctx context.Context,
+	tx *sql.Tx,
+	eventType string,
+	tenantID string,
+	userID string,
+	before *models.Company,
+	after models.Company,
+) (err error) {
+
+	diff := companyDiff(before, after)
+
+	payload, err := json.Marshal(diff)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			INSERT INTO companies_history (tenant_id, company_id, version, operation, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`,
+		tenantID, after.ID, after.Version, strings.TrimPrefix(eventType, "company."),
+		after.Name, after.Description, after.AmountOfEmployees, after.Registered, after.CompanyType,
+		payload, userID,
+	)
+	if err != nil {
+		return
+	}
+
+	var changes map[string]interface{}
+	if len(diff) > 0 {
+		changes = make(map[string]interface{}, len(diff))
+		for field, change := range diff {
+			changes[field] = change.To
+		}
+	}
+
+	err = insertOutboxEvent(ctx, tx, kfkp.NewEvent(eventType, tenantID, after.ID, userID, changes))
+	return
+}
+
+// lockCompany reads a company and locks its row until the end of tx
+func lockCompany(
+	ctx context.Context,
+	tx *sql.Tx,
+	tenantID string,
+	companyID string,
+	deleted bool,
+) (company models.Company, err error) {
+
+	condition := "deleted_at IS NULL"
+	if deleted {
+		condition = "deleted_at IS NOT NULL"
+	}
+
+	err = tx.QueryRowContext(ctx, `
+			SELECT
+				company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+			FROM companies
+			WHERE company_id = $1
+				AND tenant_id = $2
+				AND `+condition+`
+			FOR UPDATE
+		`,
+		companyID, tenantID,
+	).Scan(
+		&company.ID,
+		&company.Name,
+		&company.Description,
+		&company.AmountOfEmployees,
+		&company.Registered,
+		&company.CompanyType,
+		&company.Version,
+	)
+	return
+}
+
+// deleteCompanyHistory removes every recorded version of a company
+func deleteCompanyHistory(ctx context.Context, tx *sql.Tx, companyID string) (err error) {
+	_, err = tx.ExecContext(ctx, `
+			DELETE FROM companies_history
+			WHERE
+				company_id = $1
+		`,
+		companyID,
+	)
+	return
+}
+
+func (r *Repository) GetCompanyHistory(
+	ctx context.Context,
+	tenantID string,
+	companyID string,
+) (versions []models.CompanyVersion, err error) {
+
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				history_id, version, operation, company_id, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by, changed_at
+			FROM companies_history
+			WHERE tenant_id = $1
+				AND company_id = $2
+			ORDER BY history_id
+		`,
+		tenantID, companyID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	versions = []models.CompanyVersion{}
+	for rows.Next() {
+		var (
+			version models.CompanyVersion
+			diff    []byte
+		)
+		err = rows.Scan(
+			&version.HistoryID,
+			&version.Version,
+			&version.Operation,
+			&version.Company.ID,
+			&version.Company.Name,
+			&version.Company.Description,
+			&version.Company.AmountOfEmployees,
+			&version.Company.Registered,
+			&version.Company.CompanyType,
+			&diff,
+			&version.ChangedBy,
+			&version.ChangedAt,
+		)
+		if err != nil {
+			return
+		}
+		if err = json.Unmarshal(diff, &version.Diff); err != nil {
+			return
+		}
+		versions = append(versions, version)
+	}
+	err = rows.Err()
+	return
+}
+
+// GetCompanyAsOf reconstructs a company as it was at asOf. It returns sql.ErrNoRows
+// when the company did not exist or was deleted at that time
+func (r *Repository) GetCompanyAsOf(
+	ctx context.Context,
+	tenantID string,
+	companyID string,
+	asOf time.Time,
+) (company models.Company, err error) {
+
+	var operation string
+	err = r.db.QueryRowContext(ctx, `
+			SELECT
+				operation, company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+			FROM companies_history
+			WHERE tenant_id = $1
+				AND company_id = $2
+				AND changed_at <= $3
+			ORDER BY history_id DESC
+			LIMIT 1
+		`,
+		tenantID, companyID, asOf.UTC(),
+	).Scan(
+		&operation,
+		&company.ID,
+		&company.Name,
+		&company.Description,
+		&company.AmountOfEmployees,
+		&company.Registered,
+		&company.CompanyType,
+		&company.Version,
+	)
+	if err == nil && operation == "deleted" {
+		err = sql.ErrNoRows
+	}
+	return
+}
+
+// ListCompanyChanges returns up to limit changes made to the companies of a tenant after the
+// change afterID. Changes are ordered by the transaction that made them and only read once
+// every older transaction has finished, since history ids are taken before commit and a slow
+// transaction would otherwise commit a change behind one already read. The horizon is the
+// oldest transaction holding a transaction id in the whole database, so a long write
+// transaction of any tenant, or left open by a client, holds back every feed until it ends. A
+// change afterID that has since been purged falls back to the order of history ids
+func (r *Repository) ListCompanyChanges(
+	ctx context.Context,
+	tenantID string,
+	afterID int64,
+	limit int,
+) (changes []models.CompanyVersion, err error) {
+
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				history_id, version, operation, company_id, company_name, description, amount_of_employees, is_registered, company_type, diff, changed_by, changed_at
+			FROM companies_history
+			WHERE tenant_id = $1
+				AND tx_id < pg_snapshot_xmin(pg_current_snapshot())
+				AND (
+					(tx_id, history_id) > ((SELECT tx_id FROM companies_history WHERE history_id = $2), $2)
+					OR (history_id > $2 AND NOT EXISTS (SELECT 1 FROM companies_history WHERE history_id = $2))
+				)
+			ORDER BY tx_id, history_id
+			LIMIT $3
+		`,
+		tenantID, afterID, limit,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			change models.CompanyVersion
+			diff   []byte
+		)
+		err = rows.Scan(
+			&change.HistoryID,
+			&change.Version,
+			&change.Operation,
+			&change.Company.ID,
+			&change.Company.Name,
+			&change.Company.Description,
+			&change.Company.AmountOfEmployees,
+			&change.Company.Registered,
+			&change.Company.CompanyType,
+			&diff,
+			&change.ChangedBy,
+			&change.ChangedAt,
+		)
+		if err != nil {
+			return
+		}
+		if err = json.Unmarshal(diff, &change.Diff); err != nil {
+			return
+		}
+		changes = append(changes, change)
+	}
+	err = rows.Err()
+	return
+}
+
+// GetLatestCompanyChangeID returns the id of the last change made to the companies of a
+// tenant in the order of ListCompanyChanges, zero when there is none
+func (r *Repository) GetLatestCompanyChangeID(ctx context.Context, tenantID string) (changeID int64, err error) {
+	err = r.db.QueryRowContext(ctx, `
+			SELECT
+				COALESCE((
+					SELECT history_id
+					FROM companies_history
+					WHERE tenant_id = $1
+						AND tx_id < pg_snapshot_xmin(pg_current_snapshot())
+					ORDER BY tx_id DESC, history_id DESC
+					LIMIT 1
+				), 0)
+		`,
+		tenantID,
+	).Scan(&changeID)
+	return
+}
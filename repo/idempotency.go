@@ -0,0 +1,128 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+// ReserveIdempotencyKey claims key for userID until ttl elapses. The request holding it has
+// lease to answer, after which a retry takes the key over from a request that never did, such
+// as one whose replica crashed. When the key is held, reserved is false and record is the
+// stored one
+func (r *Repository) ReserveIdempotencyKey(
+	ctx context.Context,
+	userID string,
+	key string,
+	requestHash string,
+	ttl time.Duration,
+	lease time.Duration,
+) (record models.IdempotencyRecord, reserved bool, err error) {
+
+	err = r.db.QueryRowContext(ctx, `
+			INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at, locked_until)
+			VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4), CURRENT_TIMESTAMP + make_interval(secs => $5))
+			ON CONFLICT (user_id, idempotency_key) DO UPDATE
+			SET
+				request_hash = EXCLUDED.request_hash,
+				status_code = NULL,
+				content_type = NULL,
+				response_body = NULL,
+				created_at = CURRENT_TIMESTAMP,
+				expires_at = EXCLUDED.expires_at,
+				locked_until = EXCLUDED.locked_until
+			WHERE
+				idempotency_keys.expires_at <= CURRENT_TIMESTAMP
+				OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until <= CURRENT_TIMESTAMP)
+			RETURNING true
+		`,
+		userID, key, requestHash, ttl.Seconds(), lease.Seconds(),
+	).Scan(&reserved)
+	if err != sql.ErrNoRows {
+		return
+	}
+
+	var (
+		statusCode  sql.NullInt64
+		contentType sql.NullString
+	)
+	err = r.db.QueryRowContext(ctx, `
+			SELECT
+				request_hash, status_code, content_type, response_body
+			FROM idempotency_keys
+			WHERE user_id = $1
+				AND idempotency_key = $2
+		`,
+		userID, key,
+	).Scan(
+		&record.RequestHash,
+		&statusCode,
+		&contentType,
+		&record.Body,
+	)
+	record.StatusCode = int(statusCode.Int64)
+	record.ContentType = contentType.String
+	return
+}
+
+// SaveIdempotencyResponse stores the response of the request holding key so that retries replay it
+func (r *Repository) SaveIdempotencyResponse(
+	ctx context.Context,
+	userID string,
+	key string,
+	statusCode int,
+	contentType string,
+	body []byte,
+) (err error) {
+
+	_, err = r.db.ExecContext(ctx, `
+			UPDATE idempotency_keys
+			SET
+				status_code = $3,
+				content_type = $4,
+				response_body = $5
+			WHERE
+				user_id = $1
+				AND idempotency_key = $2
+		`,
+		userID, key, statusCode, contentType, body,
+	)
+	return
+}
+
+// ReleaseIdempotencyKey forgets key so that the request can be retried from scratch
+func (r *Repository) ReleaseIdempotencyKey(
+	ctx context.Context,
+	userID string,
+	key string,
+) (err error) {
+
+	_, err = r.db.ExecContext(ctx, `
+			DELETE FROM idempotency_keys
+			WHERE
+				user_id = $1
+				AND idempotency_key = $2
+		`,
+		userID, key,
+	)
+	return
+}
+
+// PurgeExpiredIdempotencyKeys deletes the keys whose ttl has elapsed
+func (r *Repository) PurgeExpiredIdempotencyKeys(ctx context.Context) (purged int, err error) {
+	res, err := r.db.ExecContext(ctx, `
+			DELETE FROM idempotency_keys
+			WHERE
+				expires_at <= CURRENT_TIMESTAMP
+		`,
+	)
+	if err != nil {
+		return
+	}
+
+	n, err := res.RowsAffected()
+	purged = int(n)
+	return
+}
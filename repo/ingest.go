@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/kfkp"
+)
+
+// UpsertCompany creates or updates the company of a tenant matching a record of the master data
+// system: the active company with its external ID, or else the active company with its name,
+// which is given the external ID. A company matched by name that already has another external
+// ID fails with ErrDuplicateName, a record breaking a foreign key or check constraint with
+// ErrRejectedCompany. A record equal to its company changes nothing, so applying a
+// record twice is harmless
+func (r *Repository) UpsertCompany(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	externalID string,
+	company models.Company,
+) (companyID string, outcome string, err error) {
+
+	defer func() {
+		if isForeignKeyViolation(err) || isCheckViolation(err) {
+			err = fmt.Errorf("%w: %v", ErrRejectedCompany, err)
+		}
+	}()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	var (
+		current           models.Company
+		currentExternalID sql.NullString
+	)
+	err = tx.QueryRowContext(ctx, `
+			SELECT
+				company_id, company_name, description, amount_of_employees, is_registered, company_type, version, external_id
+			FROM companies
+			WHERE tenant_id = $1
+				AND deleted_at IS NULL
+				AND (external_id = $2 OR company_name = $3)
+			ORDER BY external_id = $2 DESC NULLS LAST
+			LIMIT 1
+			FOR UPDATE
+		`,
+		tenantID, externalID, company.Name,
+	).Scan(
+		&current.ID,
+		&current.Name,
+		&current.Description,
+		&current.AmountOfEmployees,
+		&current.Registered,
+		&current.CompanyType,
+		&current.Version,
+		&currentExternalID,
+	)
+	if err == sql.ErrNoRows {
+		companyID, err = insertExternalCompany(ctx, tx, tenantID, userID, externalID, company)
+		if err != nil {
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			return
+		}
+		return companyID, models.UpsertCreated, nil
+	}
+	if err != nil {
+		return
+	}
+
+	if externalID != "" && currentExternalID.Valid && currentExternalID.String != externalID {
+		return "", "", ErrDuplicateName
+	}
+
+	companyID = current.ID
+	outcome = models.UpsertUnchanged
+
+	after := company
+	after.ID = current.ID
+	after.Version = current.Version
+	if len(companyDiff(&current, after)) > 0 {
+		_, err = updateCompany(
+			ctx, tx, tenantID, userID, companyID, nil,
+			&company.Name, &company.Description, &company.AmountOfEmployees, &company.Registered, &company.CompanyType,
+		)
+		if err != nil {
+			return
+		}
+		outcome = models.UpsertUpdated
+	}
+
+	if externalID != "" && !currentExternalID.Valid {
+		_, err = tx.ExecContext(ctx, `
+				UPDATE companies
+				SET
+					external_id = $2
+				WHERE
+					company_id = $1
+			`,
+			companyID, externalID,
+		)
+		if isUniqueViolation(err) {
+			return "", "", ErrDuplicateName
+		}
+		if err != nil {
+			return
+		}
+		outcome = models.UpsertUpdated
+	}
+
+	if outcome == models.UpsertUnchanged {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// insertExternalCompany creates a company of the master data system within tx
+func insertExternalCompany(
+	ctx context.Context,
+	tx *sql.Tx,
+	tenantID string,
+	userID string,
+	externalID string,
+	company models.Company,
+) (companyID string, err error) {
+
+	err = tx.QueryRowContext(ctx, `
+			INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type, external_id)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+			RETURNING company_id
+		`,
+		tenantID, company.Name, company.Description, company.AmountOfEmployees, company.Registered, company.CompanyType, externalID,
+	).Scan(&companyID)
+	if isUniqueViolation(err) {
+		return "", ErrDuplicateName
+	}
+	if err != nil {
+		return
+	}
+
+	after := company
+	after.ID = companyID
+	after.Version = 1
+	err = recordChange(ctx, tx, kfkp.EventCompanyCreated, tenantID, userID, nil, after)
+	return
+}
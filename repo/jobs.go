@@ -0,0 +1,391 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+// ErrJobFinished is returned when cancelling a job that has already finished
+var ErrJobFinished = errors.New("job already finished")
+
+// jobColumns are the columns scanned by scanJob
+const jobColumns = `job_id, job_type, status, progress, total, result, output_type IS NOT NULL, error, created_at, started_at, finished_at, tenant_id, owner_id, params, attempts, cancel_requested`
+
+func scanJob(row interface{ Scan(...interface{}) error }, dest ...interface{}) (job models.Job, err error) {
+	var (
+		result []byte
+		params []byte
+		errMsg sql.NullString
+		total  sql.NullInt64
+	)
+	err = row.Scan(append([]interface{}{
+		&job.ID,
+		&job.Type,
+		&job.Status,
+		&job.Progress,
+		&total,
+		&result,
+		&job.HasOutput,
+		&errMsg,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.TenantID,
+		&job.OwnerID,
+		&params,
+		&job.Attempts,
+		&job.CancelRequested,
+	}, dest...)...)
+	if err != nil {
+		return
+	}
+
+	if total.Valid {
+		n := int(total.Int64)
+		job.Total = &n
+	}
+	job.Result = result
+	job.Params = params
+	job.Error = errMsg.String
+	return
+}
+
+// CreateJob queues a job of ownerID. input is the file the job processes, if any
+func (r *Repository) CreateJob(
+	ctx context.Context,
+	tenantID string,
+	ownerID string,
+	jobType string,
+	params []byte,
+	input []byte,
+) (job models.Job, err error) {
+
+	job, err = scanJob(r.db.QueryRowContext(ctx, `
+			INSERT INTO jobs (tenant_id, owner_id, job_type, params, input)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING `+jobColumns+`
+		`,
+		tenantID, ownerID, jobType, params, input,
+	))
+	return
+}
+
+// GetJob returns the job jobID of ownerID
+func (r *Repository) GetJob(
+	ctx context.Context,
+	tenantID string,
+	ownerID string,
+	jobID string,
+) (job models.Job, err error) {
+
+	job, err = scanJob(r.db.QueryRowContext(ctx, `
+			SELECT
+				`+jobColumns+`
+			FROM jobs
+			WHERE job_id::text = $1
+				AND tenant_id = $2
+				AND owner_id = $3
+		`,
+		jobID, tenantID, ownerID,
+	))
+	return
+}
+
+// GetJobOutputType returns the media type of the file produced by the job jobID of ownerID. It
+// fails with sql.ErrNoRows when the job has no file
+func (r *Repository) GetJobOutputType(
+	ctx context.Context,
+	tenantID string,
+	ownerID string,
+	jobID string,
+) (contentType string, err error) {
+
+	err = r.db.QueryRowContext(ctx, `
+			SELECT
+				output_type
+			FROM jobs
+			WHERE job_id::text = $1
+				AND tenant_id = $2
+				AND owner_id = $3
+				AND output_type IS NOT NULL
+		`,
+		jobID, tenantID, ownerID,
+	).Scan(&contentType)
+	return
+}
+
+// ReadJobOutput calls fn with the chunks of the file produced by the job jobID in order, so
+// that the file is never held in memory as a whole
+func (r *Repository) ReadJobOutput(
+	ctx context.Context,
+	jobID string,
+	fn func(chunk []byte) error,
+) (err error) {
+
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				data
+			FROM job_output_chunks
+			WHERE job_id::text = $1
+			ORDER BY chunk
+		`,
+		jobID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chunk []byte
+		if err = rows.Scan(&chunk); err != nil {
+			return
+		}
+
+		if err = fn(chunk); err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// WriteJobOutput saves the chunk numbered chunk of the file produced by a job run by workerID,
+// replacing the one written by an earlier attempt. It fails with sql.ErrNoRows when the worker
+// lost its lease to another one
+func (r *Repository) WriteJobOutput(
+	ctx context.Context,
+	jobID string,
+	workerID string,
+	chunk int,
+	data []byte,
+) (err error) {
+
+	res, err := r.db.ExecContext(ctx, `
+			INSERT INTO job_output_chunks (job_id, chunk, data)
+			SELECT
+				job_id, $3, $4
+			FROM jobs
+			WHERE job_id = $1
+				AND locked_by = $2
+				AND status = 'running'
+			ON CONFLICT (job_id, chunk) DO UPDATE SET data = EXCLUDED.data
+		`,
+		jobID, workerID, chunk, data,
+	)
+	if err != nil {
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return
+}
+
+// CancelJob cancels the job jobID of ownerID. A queued job is cancelled right away, a running
+// one once its worker notices. It fails with ErrJobFinished when the job has already finished
+func (r *Repository) CancelJob(
+	ctx context.Context,
+	tenantID string,
+	ownerID string,
+	jobID string,
+) (job models.Job, err error) {
+
+	job, err = scanJob(r.db.QueryRowContext(ctx, `
+			UPDATE jobs
+			SET
+				cancel_requested = true,
+				status = CASE WHEN status = 'queued' THEN 'cancelled' ELSE status END,
+				finished_at = CASE WHEN status = 'queued' THEN CURRENT_TIMESTAMP ELSE finished_at END,
+				input = CASE WHEN status = 'queued' THEN NULL ELSE input END
+			WHERE
+				job_id::text = $1
+				AND tenant_id = $2
+				AND owner_id = $3
+				AND status IN ('queued', 'running')
+			RETURNING `+jobColumns+`
+		`,
+		jobID, tenantID, ownerID,
+	))
+	if err != sql.ErrNoRows {
+		return
+	}
+
+	job, err = r.GetJob(ctx, tenantID, ownerID, jobID)
+	if err == nil {
+		err = ErrJobFinished
+	}
+	return
+}
+
+// ClaimJob starts the oldest queued job on behalf of workerID, along with its input, and leases
+// it for lease. A running job whose lease expired, because its worker stopped, is claimed again.
+// Jobs locked by another replica claiming at the same time are skipped, so no two workers ever
+// run the same job. It fails with sql.ErrNoRows when there is no job to run
+func (r *Repository) ClaimJob(
+	ctx context.Context,
+	workerID string,
+	lease time.Duration,
+) (job models.Job, err error) {
+
+	var input []byte
+	job, err = scanJob(r.db.QueryRowContext(ctx, `
+			UPDATE jobs
+			SET
+				status = 'running',
+				attempts = attempts + 1,
+				locked_by = $1,
+				locked_until = CURRENT_TIMESTAMP + make_interval(secs => $2),
+				started_at = coalesce(started_at, CURRENT_TIMESTAMP)
+			WHERE job_id = (
+				SELECT
+					job_id
+				FROM jobs
+				WHERE status = 'queued'
+					OR (status = 'running' AND locked_until < CURRENT_TIMESTAMP)
+				ORDER BY created_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING `+jobColumns+`, input
+		`,
+		workerID, lease.Seconds(),
+	), &input)
+	job.Input = input
+	return
+}
+
+// RenewJobLease extends the lease of workerID on a running job and saves its progress. It
+// reports whether the job was cancelled meanwhile, and fails with sql.ErrNoRows when the
+// worker lost its lease to another one
+func (r *Repository) RenewJobLease(
+	ctx context.Context,
+	jobID string,
+	workerID string,
+	lease time.Duration,
+	progress int,
+	total *int,
+) (cancelRequested bool, err error) {
+
+	err = r.db.QueryRowContext(ctx, `
+			UPDATE jobs
+			SET
+				locked_until = CURRENT_TIMESTAMP + make_interval(secs => $3),
+				progress = $4,
+				total = $5
+			WHERE
+				job_id = $1
+				AND locked_by = $2
+				AND status = 'running'
+			RETURNING cancel_requested
+		`,
+		jobID, workerID, lease.Seconds(), progress, total,
+	).Scan(&cancelRequested)
+	return
+}
+
+// FinishJob records the outcome of a job run by workerID and releases it. The status, progress,
+// result and error are taken from job. outputType is the media type of the file the job wrote
+// in chunks chunks with WriteJobOutput, empty when it produced none. Chunks left over from an
+// earlier attempt are deleted
+func (r *Repository) FinishJob(
+	ctx context.Context,
+	workerID string,
+	job models.Job,
+	outputType string,
+	chunks int,
+) (err error) {
+
+	var result, contentType interface{}
+	if len(job.Result) > 0 {
+		result = []byte(job.Result)
+	}
+	if outputType != "" {
+		contentType = outputType
+	} else {
+		chunks = 0
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+			UPDATE jobs
+			SET
+				status = $3,
+				progress = $4,
+				total = $5,
+				result = $6,
+				output_type = $7,
+				error = NULLIF($8, ''),
+				finished_at = CURRENT_TIMESTAMP,
+				locked_by = NULL,
+				locked_until = NULL,
+				input = NULL
+			WHERE
+				job_id = $1
+				AND locked_by = $2
+		`,
+		job.ID, workerID, job.Status, job.Progress, job.Total, result, contentType, job.Error,
+	)
+	if err != nil {
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			DELETE FROM job_output_chunks
+			WHERE
+				job_id = $1
+				AND chunk >= $2
+		`,
+		job.ID, chunks,
+	)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// PurgeFinishedJobs deletes the jobs that finished before finishedBefore along with their files
+func (r *Repository) PurgeFinishedJobs(
+	ctx context.Context,
+	finishedBefore time.Time,
+) (purged int, err error) {
+
+	res, err := r.db.ExecContext(ctx, `
+			DELETE FROM jobs
+			WHERE
+				finished_at < $1
+		`,
+		finishedBefore.UTC(),
+	)
+	if err != nil {
+		return
+	}
+
+	n, err := res.RowsAffected()
+	purged = int(n)
+	return
+}
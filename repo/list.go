@@ -0,0 +1,202 @@
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+const (
+	defaultListLimit = 20
+	defaultListSort  = "createdAt"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort")
+)
+
+// sortColumn maps a sortable field to its SQL expression and the type its cursor value
+// is cast back to. Only fields in companySortColumns can ever reach the ORDER BY clause
+type sortColumn struct {
+	expr string
+	cast string
+}
+
+var companySortColumns = map[string]sortColumn{
+	"name":              {expr: "company_name", cast: "varchar"},
+	"description":       {expr: "coalesce(description, '')", cast: "varchar"},
+	"amountOfEmployees": {expr: "coalesce(amount_of_employees, 0)", cast: "int"},
+	"registered":        {expr: "coalesce(is_registered, false)", cast: "boolean"},
+	"companyType":       {expr: "coalesce(company_type, '')", cast: "varchar"},
+	"createdAt":         {expr: "created_at", cast: "timestamp"},
+	"modifiedAt":        {expr: "coalesce(modified_at, created_at)", cast: "timestamp"},
+}
+
+// listCursor is the position after the last row of a page. It is handed to clients
+// as an opaque base64 token
+type listCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"id"`
+}
+
+func encodeCursor(c listCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(token string) (c listCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return c, ErrInvalidCursor
+	}
+	return
+}
+
+// queryBuilder accumulates WHERE conditions with their positional arguments so that
+// user input is only ever passed as a query parameter
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg registers v as a query argument and returns its placeholder
+func (b *queryBuilder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+func (b *queryBuilder) where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+func (b *queryBuilder) whereClause() string {
+	return strings.Join(b.conditions, "\n\t\t\t\tAND ")
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (r *Repository) ListCompanies(
+	ctx context.Context,
+	tenantID string,
+	filter models.CompanyListReq,
+) (companies []models.Company, nextCursor string, err error) {
+
+	sortName := filter.Sort
+	if sortName == "" {
+		sortName = defaultListSort
+	}
+	desc := strings.HasPrefix(sortName, "-")
+	sort, ok := companySortColumns[strings.TrimPrefix(sortName, "-")]
+	if !ok {
+		return nil, "", ErrInvalidSort
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	b := &queryBuilder{}
+	b.where("tenant_id = " + b.arg(tenantID))
+	b.where("deleted_at IS NULL")
+
+	if filter.CompanyType != nil {
+		b.where("company_type = " + b.arg(*filter.CompanyType))
+	}
+	if filter.Registered != nil {
+		b.where("is_registered = " + b.arg(*filter.Registered))
+	}
+	if filter.MinEmployees != nil {
+		b.where("amount_of_employees >= " + b.arg(*filter.MinEmployees))
+	}
+	if filter.MaxEmployees != nil {
+		b.where("amount_of_employees <= " + b.arg(*filter.MaxEmployees))
+	}
+	if filter.NamePrefix != nil {
+		b.where("company_name ILIKE " + b.arg(escapeLike(*filter.NamePrefix)+"%") + ` ESCAPE '\'`)
+	}
+	if filter.CreatedFrom != nil {
+		b.where("created_at >= " + b.arg(filter.CreatedFrom.UTC()))
+	}
+	if filter.CreatedTo != nil {
+		b.where("created_at < " + b.arg(filter.CreatedTo.UTC()))
+	}
+	if filter.ModifiedFrom != nil {
+		b.where(companySortColumns["modifiedAt"].expr + " >= " + b.arg(filter.ModifiedFrom.UTC()))
+	}
+	if filter.ModifiedTo != nil {
+		b.where(companySortColumns["modifiedAt"].expr + " < " + b.arg(filter.ModifiedTo.UTC()))
+	}
+
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if cursor.Sort != sortName {
+			return nil, "", ErrInvalidCursor
+		}
+		b.where("(" + sort.expr + ", company_id) " + comparison +
+			" (" + b.arg(cursor.Key) + "::" + sort.cast + ", " + b.arg(cursor.ID) + "::uuid)")
+	}
+
+	query := `
+			SELECT
+				company_id, company_name, description, amount_of_employees, is_registered, company_type, (` + sort.expr + `)::text
+			FROM companies
+			WHERE ` + b.whereClause() + `
+			ORDER BY ` + sort.expr + ` ` + direction + `, company_id ` + direction + `
+			LIMIT ` + b.arg(limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	companies = make([]models.Company, 0, limit)
+	var lastKey string
+	for rows.Next() {
+		if len(companies) == limit {
+			// the extra row only tells us that there is a next page
+			last := companies[len(companies)-1]
+			nextCursor = encodeCursor(listCursor{Sort: sortName, Key: lastKey, ID: last.ID})
+			break
+		}
+
+		var company models.Company
+		err = rows.Scan(
+			&company.ID,
+			&company.Name,
+			&company.Description,
+			&company.AmountOfEmployees,
+			&company.Registered,
+			&company.CompanyType,
+			&lastKey,
+		)
+		if err != nil {
+			return
+		}
+		companies = append(companies, company)
+	}
+	err = rows.Err()
+	return
+}
@@ -3,8 +3,24 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
 
+	"github.com/danielboakye/go-xm/helpers/consts"
 	"github.com/danielboakye/go-xm/models"
+	"github.com/danielboakye/go-xm/pkg/kfkp"
+)
+
+var (
+	// ErrDuplicateName is returned when a write would give two active companies of a tenant
+	// the same name
+	ErrDuplicateName = errors.New("duplicate company name")
+	// ErrVersionMismatch is returned when a write expects another version than the current one
+	ErrVersionMismatch = errors.New("company version mismatch")
+	// ErrRejectedCompany is returned when the database refuses a company for good, because it
+	// breaks a foreign key or check constraint
+	ErrRejectedCompany = errors.New("company rejected by the database")
 )
 
 type Repository struct {
@@ -12,18 +28,129 @@ type Repository struct {
 }
 
 type IRepository interface {
-	CreateCompany(context.Context, string, string, int64, bool, string) (string, error)
-	UpdateCompany(context.Context, string, *string, *string, *int64, *bool, *string) error
-	DeleteCompany(context.Context, string) error
-	GetCompanyByID(context.Context, string) (company models.Company, err error)
-	GetCompanyByName(context.Context, string) (company models.Company, err error)
+	CreateCompany(context.Context, string, string, string, string, int64, bool, string) (string, error)
+	UpdateCompany(context.Context, string, string, string, *int64, *string, *string, *int64, *bool, *string) (int64, error)
+	DeleteCompany(context.Context, string, string, string, *int64) error
+	GetCompanyByID(context.Context, string, string) (company models.Company, err error)
+	GetCompanyByName(context.Context, string, string) (company models.Company, err error)
+	ListCompanies(context.Context, string, models.CompanyListReq) (companies []models.Company, nextCursor string, err error)
+	SearchCompanies(context.Context, string, string, int) (results []models.CompanySearchResult, err error)
+	GetCompanyByIDWithDeleted(context.Context, string, string) (company models.Company, err error)
+	RestoreCompany(context.Context, string, string, string) error
+	PurgeCompany(context.Context, string, string, string) error
+	PurgeDeletedCompanies(context.Context, string, time.Time, int) (purged int, err error)
+	GetCompanyHistory(context.Context, string, string) (versions []models.CompanyVersion, err error)
+	GetCompanyAsOf(context.Context, string, string, time.Time) (company models.Company, err error)
+	ListCompanyChanges(context.Context, string, int64, int) (changes []models.CompanyVersion, err error)
+	GetLatestCompanyChangeID(context.Context, string) (changeID int64, err error)
+	BatchCompanies(context.Context, string, string, []models.CompanyChange, bool) (results []models.CompanyChangeResult, err error)
+	ExportCompanies(context.Context, string, func(models.Company) error) error
+	ImportCompanies(context.Context, string, string, []models.Company, bool) (results []models.CompanyChangeResult, err error)
+	UpsertCompany(context.Context, string, string, string, models.Company) (companyID string, outcome string, err error)
+	CreateJob(context.Context, string, string, string, []byte, []byte) (job models.Job, err error)
+	GetJob(context.Context, string, string, string) (job models.Job, err error)
+	GetJobOutputType(context.Context, string, string, string) (contentType string, err error)
+	ReadJobOutput(context.Context, string, func([]byte) error) error
+	WriteJobOutput(context.Context, string, string, int, []byte) error
+	CancelJob(context.Context, string, string, string) (job models.Job, err error)
+	ClaimJob(context.Context, string, time.Duration) (job models.Job, err error)
+	RenewJobLease(context.Context, string, string, time.Duration, int, *int) (cancelRequested bool, err error)
+	FinishJob(context.Context, string, models.Job, string, int) error
+	PurgeFinishedJobs(context.Context, time.Time) (purged int, err error)
+	ReserveIdempotencyKey(context.Context, string, string, string, time.Duration, time.Duration) (record models.IdempotencyRecord, reserved bool, err error)
+	SaveIdempotencyResponse(context.Context, string, string, int, string, []byte) error
+	ReleaseIdempotencyKey(context.Context, string, string) error
+	PurgeExpiredIdempotencyKeys(context.Context) (purged int, err error)
+	CreateUser(context.Context, string, string, string, []string) (user models.User, err error)
+	GetUserByEmail(context.Context, string) (user models.User, err error)
+	GetUserByID(context.Context, string) (user models.User, err error)
+	TenantExists(context.Context, string) (exists bool, err error)
+	CreateRefreshToken(context.Context, string, string, string, time.Time) error
+	RotateRefreshToken(context.Context, string, string, time.Time) (userID string, err error)
+	RevokeToken(context.Context, string, string, time.Time) error
+	RevokeUserTokens(context.Context, string, time.Time) error
+	RevokeRefreshTokenFamily(context.Context, string) error
+	GetTokenRevocation(context.Context, string, string) (revoked bool, revokedBefore *time.Time, err error)
+	PurgeExpiredTokens(context.Context) (purged int, err error)
+	ListRoles(context.Context) (roles []models.Role, err error)
+	UpsertRole(context.Context, string, []string) error
+	DeleteRole(context.Context, string) error
+	GetUserRoles(context.Context, string) (roles []string, scopes []string, err error)
+	SetUserRoles(context.Context, string, string, []string) error
+	CreateAPIKey(context.Context, string, string, string, string, string, []string, *time.Time) (key models.APIKey, err error)
+	ListAPIKeys(context.Context, string) (keys []models.APIKey, err error)
+	RevokeAPIKey(context.Context, string, string) error
+	GetAPIKeyByPrefix(context.Context, string) (key models.APIKey, hash string, err error)
+	TouchAPIKey(context.Context, string) error
+	CreateWebhook(context.Context, string, string, string, string, []string, string) (webhook models.Webhook, err error)
+	ListWebhooks(context.Context, string) (webhooks []models.Webhook, err error)
+	GetWebhook(context.Context, string, string) (webhook models.Webhook, err error)
+	UpdateWebhook(context.Context, string, string, models.WebhookUpdateReq) (webhook models.Webhook, err error)
+	DeleteWebhook(context.Context, string, string) error
+	ListWebhookDeliveries(context.Context, string, string, string, int) (deliveries []models.WebhookDelivery, err error)
+	ReplayWebhookDelivery(context.Context, string, string, string) (delivery models.WebhookDelivery, err error)
+	QueueWebhookDeliveries(context.Context, int) (queued int, err error)
+	ClaimWebhookDeliveries(context.Context, int, time.Duration) (deliveries []models.PendingDelivery, err error)
+	RecordWebhookAttempt(context.Context, models.WebhookAttempt, int) (disabled bool, err error)
+	PurgeWebhookDeliveries(context.Context, time.Time) (purged int, err error)
+	PurgeRelayedOutboxEvents(context.Context) (purged int, err error)
 }
 
 func NewRepository(db *sql.DB) IRepository {
 	return &Repository{db: db}
 }
+
+// insertOutboxEvent records an event in the outbox within the transaction of the mutation it
+// describes, kfkp.Relay publishes it once the transaction commits
+func insertOutboxEvent(ctx context.Context, tx *sql.Tx, event kfkp.Event) (err error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			INSERT INTO company_outbox (event_id, company_id, event_type, payload)
+			VALUES ($1, $2, $3, $4)
+		`,
+		event.ID, event.CompanyID, event.Type, payload,
+	)
+	return
+}
+
+// PurgeRelayedOutboxEvents deletes the outbox events that were both published and queued for
+// delivery to the webhooks. Dead-lettered events are kept
+func (r *Repository) PurgeRelayedOutboxEvents(ctx context.Context) (purged int, err error) {
+	res, err := r.db.ExecContext(ctx, `
+			DELETE FROM company_outbox
+			WHERE
+				published_at IS NOT NULL
+				AND webhooks_queued_at IS NOT NULL
+		`,
+	)
+	if err != nil {
+		return
+	}
+
+	n, err := res.RowsAffected()
+	purged = int(n)
+	return
+}
+
+// isUniqueViolation reports whether err is a postgres unique_violation
+func isUniqueViolation(err error) bool {
+	var e interface{ SQLState() string }
+	return errors.As(err, &e) && e.SQLState() == "23505"
+}
+
+func isCheckViolation(err error) bool {
+	var e interface{ SQLState() string }
+	return errors.As(err, &e) && e.SQLState() == "23514"
+}
+
 func (r *Repository) CreateCompany(
 	ctx context.Context,
+	tenantID string,
+	userID string,
 	name string,
 	description string,
 	amountOfEmployees int64,
@@ -31,27 +158,102 @@ func (r *Repository) CreateCompany(
 	companyType string,
 ) (companyID string, err error) {
 
-	err = r.db.QueryRowContext(ctx, `
-			INSERT INTO companies (company_name, description, amount_of_employees, is_registered, company_type)
-			VALUES ($1, $2, $3, $4, $5)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+			INSERT INTO companies (tenant_id, company_name, description, amount_of_employees, is_registered, company_type)
+			VALUES ($1, $2, $3, $4, $5, $6)
 			RETURNING company_id
 		`,
-		name, description, amountOfEmployees, registered, companyType,
+		tenantID, name, description, amountOfEmployees, registered, companyType,
 	).Scan(&companyID)
+	if isUniqueViolation(err) {
+		return "", ErrDuplicateName
+	}
+	if err != nil {
+		return
+	}
+
+	after := models.Company{
+		ID:                companyID,
+		Name:              name,
+		Description:       description,
+		AmountOfEmployees: amountOfEmployees,
+		Registered:        registered,
+		CompanyType:       companyType,
+		Version:           1,
+	}
+	err = recordChange(ctx, tx, kfkp.EventCompanyCreated, tenantID, userID, nil, after)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
 	return
 }
 
+// UpdateCompany applies the non-nil fields to a company and returns its new version. When
+// expectedVersion is set the update fails with ErrVersionMismatch unless it is the current version
 func (r *Repository) UpdateCompany(
 	ctx context.Context,
+	tenantID string,
+	userID string,
 	companyID string,
+	expectedVersion *int64,
 	name *string,
 	description *string,
 	amountOfEmployees *int64,
 	registered *bool,
 	companyType *string,
-) (err error) {
+) (version int64, err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	version, err = updateCompany(
+		ctx, tx, tenantID, userID, companyID, expectedVersion,
+		name, description, amountOfEmployees, registered, companyType,
+	)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
 
-	_, err = r.db.ExecContext(ctx, `
+// updateCompany applies the non-nil fields to a company within tx and returns its new version
+func updateCompany(
+	ctx context.Context,
+	tx *sql.Tx,
+	tenantID string,
+	userID string,
+	companyID string,
+	expectedVersion *int64,
+	name *string,
+	description *string,
+	amountOfEmployees *int64,
+	registered *bool,
+	companyType *string,
+) (version int64, err error) {
+
+	before, err := lockCompany(ctx, tx, tenantID, companyID, false)
+	if err != nil {
+		return
+	}
+
+	if expectedVersion != nil && *expectedVersion != before.Version {
+		return 0, ErrVersionMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, `
 			UPDATE companies 
 			SET 
 				company_name = coalesce($2, company_name), 
@@ -59,45 +261,137 @@ func (r *Repository) UpdateCompany(
 				amount_of_employees = coalesce($4, amount_of_employees), 
 				is_registered = coalesce($5, is_registered), 
 				company_type = coalesce($6, company_type),
-				modified_at = now()
+				modified_at = now(),
+				version = version + 1
 			WHERE
 				company_id = $1
+				AND tenant_id = $7
+				AND deleted_at IS NULL
 		`,
-		companyID, name, description, amountOfEmployees, registered, companyType,
+		companyID, name, description, amountOfEmployees, registered, companyType, tenantID,
 	)
+	if isUniqueViolation(err) {
+		return 0, ErrDuplicateName
+	}
+	if err != nil {
+		return
+	}
+
+	after := before
+	after.Version++
+	if name != nil {
+		after.Name = *name
+	}
+	if description != nil {
+		after.Description = *description
+	}
+	if amountOfEmployees != nil {
+		after.AmountOfEmployees = *amountOfEmployees
+	}
+	if registered != nil {
+		after.Registered = *registered
+	}
+	if companyType != nil {
+		after.CompanyType = *companyType
+	}
+
+	err = recordChange(ctx, tx, kfkp.EventCompanyUpdated, tenantID, userID, &before, after)
+	if err != nil {
+		return
+	}
+
+	version = after.Version
 	return
 }
 
+// DeleteCompany soft-deletes a company, it fails with sql.ErrNoRows when there is none. When
+// expectedVersion is set the delete fails with ErrVersionMismatch unless it is the current version
 func (r *Repository) DeleteCompany(
 	ctx context.Context,
+	tenantID string,
+	userID string,
 	companyID string,
+	expectedVersion *int64,
 ) (err error) {
 
-	_, err = r.db.ExecContext(ctx, `
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = deleteCompany(ctx, tx, tenantID, userID, companyID, expectedVersion)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// deleteCompany soft-deletes a company within tx and returns its new version, it fails with
+// sql.ErrNoRows when there is no company to delete
+func deleteCompany(
+	ctx context.Context,
+	tx *sql.Tx,
+	tenantID string,
+	userID string,
+	companyID string,
+	expectedVersion *int64,
+) (version int64, err error) {
+
+	before, err := lockCompany(ctx, tx, tenantID, companyID, false)
+	if err != nil {
+		return
+	}
+
+	if expectedVersion != nil && *expectedVersion != before.Version {
+		return 0, ErrVersionMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, `
 			UPDATE companies 
 			SET 
-				deleted_at = now()
+				deleted_at = now(),
+				version = version + 1
 			WHERE
 				company_id = $1
+				AND tenant_id = $2
+				AND deleted_at IS NULL
 		`,
-		companyID,
+		companyID, tenantID,
 	)
+	if err != nil {
+		return
+	}
+
+	after := before
+	after.Version++
+
+	err = recordChange(ctx, tx, kfkp.EventCompanyDeleted, tenantID, userID, &before, after)
+	if err != nil {
+		return
+	}
+
+	version = after.Version
 	return
 }
 
 func (r *Repository) GetCompanyByID(
 	ctx context.Context,
+	tenantID string,
 	companyID string,
 ) (company models.Company, err error) {
 
 	err = r.db.QueryRowContext(ctx, `
 			SELECT
-				company_id, company_name, description, amount_of_employees, is_registered, company_type
+				company_id, company_name, description, amount_of_employees, is_registered, company_type, version
 			FROM companies
 			WHERE company_id = $1
+				AND tenant_id = $2
 				AND deleted_at IS NULL
 		`,
-		companyID,
+		companyID, tenantID,
 	).Scan(
 		&company.ID,
 		&company.Name,
@@ -105,12 +399,14 @@ func (r *Repository) GetCompanyByID(
 		&company.AmountOfEmployees,
 		&company.Registered,
 		&company.CompanyType,
+		&company.Version,
 	)
 	return
 }
 
 func (r *Repository) GetCompanyByName(
 	ctx context.Context,
+	tenantID string,
 	companyName string,
 ) (company models.Company, err error) {
 
@@ -119,9 +415,10 @@ func (r *Repository) GetCompanyByName(
 				company_id, company_name, description, amount_of_employees, is_registered, company_type
 			FROM companies
 			WHERE company_name = $1
+				AND tenant_id = $2
 				AND deleted_at IS NULL
 		`,
-		companyName,
+		companyName, tenantID,
 	).Scan(
 		&company.ID,
 		&company.Name,
@@ -132,3 +429,201 @@ func (r *Repository) GetCompanyByName(
 	)
 	return
 }
+
+// GetCompanyByIDWithDeleted is GetCompanyByID including soft-deleted companies
+func (r *Repository) GetCompanyByIDWithDeleted(
+	ctx context.Context,
+	tenantID string,
+	companyID string,
+) (company models.Company, err error) {
+
+	err = r.db.QueryRowContext(ctx, `
+			SELECT
+				company_id, company_name, description, amount_of_employees, is_registered, company_type, deleted_at, version
+			FROM companies
+			WHERE company_id = $1
+				AND tenant_id = $2
+		`,
+		companyID, tenantID,
+	).Scan(
+		&company.ID,
+		&company.Name,
+		&company.Description,
+		&company.AmountOfEmployees,
+		&company.Registered,
+		&company.CompanyType,
+		&company.DeletedAt,
+		&company.Version,
+	)
+	return
+}
+
+// RestoreCompany undoes the soft delete of a company. It fails with ErrDuplicateName
+// when the name of the company has been taken since it was deleted
+func (r *Repository) RestoreCompany(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	companyID string,
+) (err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := lockCompany(ctx, tx, tenantID, companyID, true)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			UPDATE companies 
+			SET 
+				deleted_at = NULL,
+				modified_at = now(),
+				version = version + 1
+			WHERE
+				company_id = $1
+				AND tenant_id = $2
+				AND deleted_at IS NOT NULL
+		`,
+		companyID, tenantID,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	if err != nil {
+		return
+	}
+
+	after := before
+	after.Version++
+
+	err = recordChange(ctx, tx, kfkp.EventCompanyRestored, tenantID, userID, &before, after)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// PurgeCompany permanently deletes a company, active or soft-deleted, and its history
+func (r *Repository) PurgeCompany(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	companyID string,
+) (err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+			DELETE FROM companies
+			WHERE
+				company_id = $1
+				AND tenant_id = $2
+		`,
+		companyID, tenantID,
+	)
+	if err != nil {
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = deleteCompanyHistory(ctx, tx, companyID)
+	if err != nil {
+		return
+	}
+
+	err = insertOutboxEvent(ctx, tx, kfkp.NewEvent(kfkp.EventCompanyPurged, tenantID, companyID, userID, nil))
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// PurgeDeletedCompanies permanently deletes up to limit companies of a tenant soft-deleted
+// before deletedBefore along with their history. An empty tenantID purges every tenant, for
+// the retention job only
+func (r *Repository) PurgeDeletedCompanies(
+	ctx context.Context,
+	tenantID string,
+	deletedBefore time.Time,
+	limit int,
+) (purged int, err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+			DELETE FROM companies
+			WHERE company_id IN (
+				SELECT company_id
+				FROM companies
+				WHERE deleted_at < $1
+					AND ($3 = '' OR tenant_id = $3)
+				ORDER BY deleted_at
+				LIMIT $2
+			)
+			RETURNING tenant_id, company_id
+		`,
+		deletedBefore.UTC(), limit, tenantID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var deleted []struct{ tenantID, companyID string }
+	for rows.Next() {
+		var d struct{ tenantID, companyID string }
+		if err = rows.Scan(&d.tenantID, &d.companyID); err != nil {
+			return
+		}
+		deleted = append(deleted, d)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	for _, d := range deleted {
+		err = deleteCompanyHistory(ctx, tx, d.companyID)
+		if err != nil {
+			return
+		}
+
+		err = insertOutboxEvent(ctx, tx, kfkp.NewEvent(kfkp.EventCompanyPurged, d.tenantID, d.companyID, consts.SYSTEM_USER, nil))
+		if err != nil {
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	purged = len(deleted)
+	return
+}
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RevokeToken records that the token jti of userID must no longer be accepted. The record
+// is kept until the token expires
+func (r *Repository) RevokeToken(
+	ctx context.Context,
+	jti string,
+	userID string,
+	expiresAt time.Time,
+) (err error) {
+
+	_, err = r.db.ExecContext(ctx, `
+			INSERT INTO revoked_tokens (jti, user_id, expires_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (jti) DO NOTHING
+		`,
+		jti, userID, expiresAt.UTC(),
+	)
+	return
+}
+
+// RevokeUserTokens revokes every token issued to userID up to revokedBefore, refresh tokens included
+func (r *Repository) RevokeUserTokens(
+	ctx context.Context,
+	userID string,
+	revokedBefore time.Time,
+) (err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+			INSERT INTO user_token_revocations (user_id, revoked_before)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE
+			SET
+				revoked_before = EXCLUDED.revoked_before
+		`,
+		userID, revokedBefore.UTC(),
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			UPDATE refresh_tokens
+			SET
+				revoked_at = CURRENT_TIMESTAMP
+			WHERE
+				user_id::text = $1
+				AND revoked_at IS NULL
+		`,
+		userID,
+	)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// RevokeRefreshTokenFamily revokes the refresh token tokenID along with every token rotated from
+// the same login
+func (r *Repository) RevokeRefreshTokenFamily(
+	ctx context.Context,
+	tokenID string,
+) (err error) {
+
+	_, err = r.db.ExecContext(ctx, `
+			UPDATE refresh_tokens
+			SET
+				revoked_at = CURRENT_TIMESTAMP
+			WHERE
+				family_id = (SELECT family_id FROM refresh_tokens WHERE token_id = $1)
+				AND revoked_at IS NULL
+		`,
+		tokenID,
+	)
+	return
+}
+
+// GetTokenRevocation reports whether the token jti is revoked and since when the tokens of
+// userID are revoked, revokedBefore is nil when they never were
+func (r *Repository) GetTokenRevocation(
+	ctx context.Context,
+	jti string,
+	userID string,
+) (revoked bool, revokedBefore *time.Time, err error) {
+
+	var before sql.NullTime
+	err = r.db.QueryRowContext(ctx, `
+			SELECT
+				EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1),
+				(SELECT revoked_before FROM user_token_revocations WHERE user_id = $2)
+		`,
+		jti, userID,
+	).Scan(
+		&revoked,
+		&before,
+	)
+	if before.Valid {
+		revokedBefore = &before.Time
+	}
+	return
+}
+
+// PurgeExpiredTokens deletes the revoked and refresh tokens that have expired
+func (r *Repository) PurgeExpiredTokens(ctx context.Context) (purged int, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{`
+			DELETE FROM revoked_tokens
+			WHERE
+				expires_at <= CURRENT_TIMESTAMP
+		`, `
+			DELETE FROM refresh_tokens
+			WHERE
+				expires_at <= CURRENT_TIMESTAMP
+		`,
+	} {
+		res, err := tx.ExecContext(ctx, query)
+		if err != nil {
+			return purged, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return purged, err
+		}
+		purged += int(n)
+	}
+
+	err = tx.Commit()
+	return
+}
@@ -0,0 +1,208 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+// ErrUnknownRole is returned when a user is given a role that does not exist
+var ErrUnknownRole = errors.New("unknown role")
+
+// isForeignKeyViolation reports whether err is a postgres foreign_key_violation
+func isForeignKeyViolation(err error) bool {
+	var e interface{ SQLState() string }
+	return errors.As(err, &e) && e.SQLState() == "23503"
+}
+
+func (r *Repository) ListRoles(ctx context.Context) (roles []models.Role, err error) {
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				role_name, scopes
+			FROM roles
+			ORDER BY role_name
+		`,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	roles = []models.Role{}
+	for rows.Next() {
+		var (
+			role   models.Role
+			scopes []byte
+		)
+		if err = rows.Scan(&role.Name, &scopes); err != nil {
+			return
+		}
+		if err = json.Unmarshal(scopes, &role.Scopes); err != nil {
+			return
+		}
+		roles = append(roles, role)
+	}
+	err = rows.Err()
+	return
+}
+
+// UpsertRole creates the role name or replaces its scopes
+func (r *Repository) UpsertRole(
+	ctx context.Context,
+	name string,
+	scopes []string,
+) (err error) {
+
+	payload, err := json.Marshal(scopes)
+	if err != nil {
+		return
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+			INSERT INTO roles (role_name, scopes)
+			VALUES ($1, $2)
+			ON CONFLICT (role_name) DO UPDATE
+			SET
+				scopes = EXCLUDED.scopes,
+				modified_at = now()
+		`,
+		name, payload,
+	)
+	return
+}
+
+// DeleteRole deletes the role name and takes it away from its users. It returns
+// sql.ErrNoRows when the role does not exist
+func (r *Repository) DeleteRole(ctx context.Context, name string) (err error) {
+	res, err := r.db.ExecContext(ctx, `
+			DELETE FROM roles
+			WHERE
+				role_name = $1
+		`,
+		name,
+	)
+	if err != nil {
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return
+}
+
+// GetUserRoles returns the roles of a user and the union of their scopes
+func (r *Repository) GetUserRoles(
+	ctx context.Context,
+	userID string,
+) (roles []string, scopes []string, err error) {
+
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				r.role_name, r.scopes
+			FROM user_roles ur
+			JOIN roles r ON r.role_name = ur.role_name
+			WHERE ur.user_id::text = $1
+			ORDER BY r.role_name
+		`,
+		userID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	roles, scopes = []string{}, []string{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var (
+			role       string
+			roleScopes []string
+			payload    []byte
+		)
+		if err = rows.Scan(&role, &payload); err != nil {
+			return
+		}
+		if err = json.Unmarshal(payload, &roleScopes); err != nil {
+			return
+		}
+
+		roles = append(roles, role)
+		for _, scope := range roleScopes {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	sort.Strings(scopes)
+	err = rows.Err()
+	return
+}
+
+// SetUserRoles replaces the roles of a user of a tenant. It fails with ErrUnknownRole when
+// one of roles does not exist and with sql.ErrNoRows when the user does not
+func (r *Repository) SetUserRoles(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	roles []string,
+) (err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM users WHERE user_id::text = $1 AND tenant_id = $2)
+		`,
+		userID, tenantID,
+	).Scan(&exists)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			DELETE FROM user_roles
+			WHERE
+				user_id::text = $1
+		`,
+		userID,
+	)
+	if err != nil {
+		return
+	}
+
+	for _, role := range roles {
+		_, err = tx.ExecContext(ctx, `
+				INSERT INTO user_roles (user_id, role_name)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING
+			`,
+			userID, role,
+		)
+		if isForeignKeyViolation(err) {
+			return ErrUnknownRole
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	err = tx.Commit()
+	return
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+const defaultSearchLimit = 20
+
+// ts_headline marks the matched terms with these private use characters, which are turned
+// into <mark> tags once the rest of the snippet is escaped for html
+const (
+	snippetStartSel = "\uE000"
+	snippetStopSel  = "\uE001"
+)
+
+var snippetMarks = strings.NewReplacer(snippetStartSel, "<mark>", snippetStopSel, "</mark>")
+
+// highlight returns the html of a snippet whose matched terms are marked with
+// snippetStartSel and snippetStopSel
+func highlight(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+// prefixTSQuery turns free text into a tsquery matching every term as a prefix,
+// so "acme corp" finds "ACME Corporation". Anything but letters and digits is dropped
+// which keeps tsquery operators in user input from reaching postgres
+func prefixTSQuery(text string) string {
+	terms := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+func (r *Repository) SearchCompanies(
+	ctx context.Context,
+	tenantID string,
+	text string,
+	limit int,
+) (results []models.CompanySearchResult, err error) {
+
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				company_id, company_name, description, amount_of_employees, is_registered, company_type,
+				ts_rank(search_vector, q) + similarity(company_name, $1) AS score,
+				similarity(company_name, $1) AS similarity,
+				ts_headline('simple', company_name || ' ' || coalesce(description, ''), q, 'StartSel=' || $5 || ', StopSel=' || $6 || ', MaxFragments=2')
+			FROM companies, to_tsquery('simple', $2) q
+			WHERE tenant_id = $4
+				AND deleted_at IS NULL
+				AND (search_vector @@ q OR company_name % $1)
+			ORDER BY score DESC, company_id
+			LIMIT $3
+		`,
+		text, prefixTSQuery(text), limit, tenantID, snippetStartSel, snippetStopSel,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	results = make([]models.CompanySearchResult, 0, limit)
+	for rows.Next() {
+		var result models.CompanySearchResult
+		err = rows.Scan(
+			&result.ID,
+			&result.Name,
+			&result.Description,
+			&result.AmountOfEmployees,
+			&result.Registered,
+			&result.CompanyType,
+			&result.Score,
+			&result.Similarity,
+			&result.Snippet,
+		)
+		if err != nil {
+			return
+		}
+		result.Snippet = highlight(result.Snippet)
+		results = append(results, result)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+// ExportCompanies calls fn with every active company of a tenant, oldest first. Rows are
+// streamed from the database as fn consumes them, the first error fn returns stops the export
+func (r *Repository) ExportCompanies(
+	ctx context.Context,
+	tenantID string,
+	fn func(company models.Company) error,
+) (err error) {
+
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				company_id, company_name, description, amount_of_employees, is_registered, company_type, version
+			FROM companies
+			WHERE tenant_id = $1
+				AND deleted_at IS NULL
+			ORDER BY created_at, company_id
+		`,
+		tenantID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var company models.Company
+		err = rows.Scan(
+			&company.ID,
+			&company.Name,
+			&company.Description,
+			&company.AmountOfEmployees,
+			&company.Registered,
+			&company.CompanyType,
+			&company.Version,
+		)
+		if err != nil {
+			return
+		}
+
+		if err = fn(company); err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// ImportCompanies creates companies within one transaction like a best-effort batch of
+// creates, a company whose name is taken gets ErrDuplicateName in its result. A dry run
+// rolls the transaction back, reporting what an import would do
+func (r *Repository) ImportCompanies(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	companies []models.Company,
+	dryRun bool,
+) (results []models.CompanyChangeResult, err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	changes := make([]models.CompanyChange, len(companies))
+	for i, company := range companies {
+		changes[i] = models.CompanyChange{Op: models.BatchOpCreate, Company: company}
+	}
+
+	results = make([]models.CompanyChangeResult, len(changes))
+	for i := 0; i < len(changes); i += maxInsertRows {
+		end := i + maxInsertRows
+		if end > len(changes) {
+			end = len(changes)
+		}
+
+		err = insertCompanies(ctx, tx, tenantID, userID, changes[i:end], results[i:end])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
@@ -0,0 +1,245 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+var (
+	// ErrRefreshTokenReused is returned when a refresh token that has already been exchanged
+	// is presented again. The whole token family is revoked when it happens
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrRefreshTokenRevoked is returned when a refresh token has been revoked or has expired
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	// ErrDuplicateEmail is returned when a user is created with the email of another user
+	ErrDuplicateEmail = errors.New("duplicate email")
+)
+
+// CreateUser creates a user of a tenant with roles. It fails with ErrDuplicateEmail when
+// another user has the email and with ErrUnknownRole when one of roles does not exist
+func (r *Repository) CreateUser(
+	ctx context.Context,
+	tenantID string,
+	email string,
+	passwordHash string,
+	roles []string,
+) (user models.User, err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+			INSERT INTO users (tenant_id, email, password_hash)
+			VALUES ($1, $2, $3)
+			RETURNING user_id, tenant_id, email, password_hash, created_at
+		`,
+		tenantID, email, passwordHash,
+	).Scan(
+		&user.ID,
+		&user.TenantID,
+		&user.Email,
+		&user.PasswordHash,
+		&user.CreatedAt,
+	)
+	if isUniqueViolation(err) {
+		return models.User{}, ErrDuplicateEmail
+	}
+	if err != nil {
+		return
+	}
+
+	for _, role := range roles {
+		_, err = tx.ExecContext(ctx, `
+				INSERT INTO user_roles (user_id, role_name)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING
+			`,
+			user.ID, role,
+		)
+		if isForeignKeyViolation(err) {
+			return models.User{}, ErrUnknownRole
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	err = tx.Commit()
+	return
+}
+
+func (r *Repository) GetUserByEmail(
+	ctx context.Context,
+	email string,
+) (user models.User, err error) {
+
+	err = r.db.QueryRowContext(ctx, `
+			SELECT
+				user_id, tenant_id, email, password_hash, created_at
+			FROM users
+			WHERE lower(email) = lower($1)
+		`,
+		email,
+	).Scan(
+		&user.ID,
+		&user.TenantID,
+		&user.Email,
+		&user.PasswordHash,
+		&user.CreatedAt,
+	)
+	return
+}
+
+// TenantExists tells whether a tenant is known, that is has at least one user
+func (r *Repository) TenantExists(
+	ctx context.Context,
+	tenantID string,
+) (exists bool, err error) {
+
+	err = r.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM users WHERE tenant_id = $1)
+		`,
+		tenantID,
+	).Scan(&exists)
+	return
+}
+
+func (r *Repository) GetUserByID(
+	ctx context.Context,
+	userID string,
+) (user models.User, err error) {
+
+	err = r.db.QueryRowContext(ctx, `
+			SELECT
+				user_id, tenant_id, email, password_hash, created_at
+			FROM users
+			WHERE user_id::text = $1
+		`,
+		userID,
+	).Scan(
+		&user.ID,
+		&user.TenantID,
+		&user.Email,
+		&user.PasswordHash,
+		&user.CreatedAt,
+	)
+	return
+}
+
+// CreateRefreshToken records a refresh token of the family familyID, which a login starts
+func (r *Repository) CreateRefreshToken(
+	ctx context.Context,
+	tokenID string,
+	familyID string,
+	userID string,
+	expiresAt time.Time,
+) (err error) {
+
+	_, err = r.db.ExecContext(ctx, `
+			INSERT INTO refresh_tokens (token_id, family_id, user_id, expires_at)
+			VALUES ($1, $2, $3, $4)
+		`,
+		tokenID, familyID, userID, expiresAt.UTC(),
+	)
+	return
+}
+
+// RotateRefreshToken exchanges the refresh token tokenID for newTokenID in the same family.
+// A token can be exchanged once, presenting it again revokes its family and fails with
+// ErrRefreshTokenReused since either the client or an attacker holds a stolen copy
+func (r *Repository) RotateRefreshToken(
+	ctx context.Context,
+	tokenID string,
+	newTokenID string,
+	expiresAt time.Time,
+) (userID string, err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	var (
+		familyID  string
+		expired   bool
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `
+			SELECT
+				family_id, user_id, expires_at <= CURRENT_TIMESTAMP, used_at, revoked_at
+			FROM refresh_tokens
+			WHERE token_id = $1
+			FOR UPDATE
+		`,
+		tokenID,
+	).Scan(
+		&familyID,
+		&userID,
+		&expired,
+		&usedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return
+	}
+
+	if revokedAt.Valid || expired {
+		return "", ErrRefreshTokenRevoked
+	}
+
+	if usedAt.Valid {
+		_, err = tx.ExecContext(ctx, `
+				UPDATE refresh_tokens
+				SET
+					revoked_at = CURRENT_TIMESTAMP
+				WHERE
+					family_id = $1
+					AND revoked_at IS NULL
+			`,
+			familyID,
+		)
+		if err != nil {
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			return
+		}
+		return "", ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			UPDATE refresh_tokens
+			SET
+				used_at = CURRENT_TIMESTAMP
+			WHERE
+				token_id = $1
+		`,
+		tokenID,
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			INSERT INTO refresh_tokens (token_id, family_id, user_id, expires_at)
+			VALUES ($1, $2, $3, $4)
+		`,
+		newTokenID, familyID, userID, expiresAt.UTC(),
+	)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
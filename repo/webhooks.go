@@ -0,0 +1,513 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/danielboakye/go-xm/models"
+)
+
+// webhookColumns are the columns scanned by scanWebhook
+const webhookColumns = `webhook_id, url, description, event_types, enabled, consecutive_failures, disabled_at, created_by, created_at, updated_at`
+
+// deliveryColumns are the columns scanned by scanDelivery
+const deliveryColumns = `delivery_id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_attempt_at, response_status, last_error, replay_of, created_at, delivered_at`
+
+// claimedDeliveryColumns are deliveryColumns of the deliveries d joined with their webhook
+const claimedDeliveryColumns = `d.delivery_id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_attempt_at, d.response_status, d.last_error, d.replay_of, d.created_at, d.delivered_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (webhook models.Webhook, err error) {
+	var eventTypes []byte
+	err = row.Scan(
+		&webhook.ID,
+		&webhook.URL,
+		&webhook.Description,
+		&eventTypes,
+		&webhook.Enabled,
+		&webhook.ConsecutiveFailures,
+		&webhook.DisabledAt,
+		&webhook.CreatedBy,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+	)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(eventTypes, &webhook.EventTypes)
+	return
+}
+
+func scanDelivery(row interface{ Scan(...interface{}) error }, dest ...interface{}) (delivery models.WebhookDelivery, err error) {
+	var (
+		payload   []byte
+		lastError sql.NullString
+	)
+	err = row.Scan(append([]interface{}{
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&delivery.ResponseStatus,
+		&lastError,
+		&delivery.ReplayOf,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+	}, dest...)...)
+	if err != nil {
+		return
+	}
+
+	delivery.Payload = payload
+	delivery.LastError = lastError.String
+	if delivery.Status != models.DeliveryPending {
+		delivery.NextAttemptAt = nil
+	}
+	return
+}
+
+// CreateWebhook registers a webhook of a tenant signing its deliveries with secret
+func (r *Repository) CreateWebhook(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	url string,
+	description string,
+	eventTypes []string,
+	secret string,
+) (webhook models.Webhook, err error) {
+
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	payload, err := json.Marshal(eventTypes)
+	if err != nil {
+		return
+	}
+
+	webhook, err = scanWebhook(r.db.QueryRowContext(ctx, `
+			INSERT INTO webhooks (tenant_id, url, description, event_types, secret, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING `+webhookColumns+`
+		`,
+		tenantID, url, description, payload, secret, userID,
+	))
+	return
+}
+
+// ListWebhooks returns the webhooks of a tenant, disabled ones included
+func (r *Repository) ListWebhooks(ctx context.Context, tenantID string) (webhooks []models.Webhook, err error) {
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				`+webhookColumns+`
+			FROM webhooks
+			WHERE tenant_id = $1
+			ORDER BY created_at, webhook_id
+		`,
+		tenantID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	webhooks = []models.Webhook{}
+	for rows.Next() {
+		var webhook models.Webhook
+		if webhook, err = scanWebhook(rows); err != nil {
+			return
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	err = rows.Err()
+	return
+}
+
+func (r *Repository) GetWebhook(ctx context.Context, tenantID string, webhookID string) (webhook models.Webhook, err error) {
+	webhook, err = scanWebhook(r.db.QueryRowContext(ctx, `
+			SELECT
+				`+webhookColumns+`
+			FROM webhooks
+			WHERE webhook_id::text = $1
+				AND tenant_id = $2
+		`,
+		webhookID, tenantID,
+	))
+	return
+}
+
+// UpdateWebhook changes the fields of a webhook that are set, it fails with sql.ErrNoRows when
+// the tenant has no such webhook. Enabling a webhook resets its failures
+func (r *Repository) UpdateWebhook(
+	ctx context.Context,
+	tenantID string,
+	webhookID string,
+	update models.WebhookUpdateReq,
+) (webhook models.Webhook, err error) {
+
+	var eventTypes []byte
+	if update.EventTypes != nil {
+		types := *update.EventTypes
+		if types == nil {
+			types = []string{}
+		}
+		if eventTypes, err = json.Marshal(types); err != nil {
+			return
+		}
+	}
+
+	webhook, err = scanWebhook(r.db.QueryRowContext(ctx, `
+			UPDATE webhooks
+			SET
+				url = coalesce($3, url),
+				description = coalesce($4, description),
+				event_types = coalesce($5, event_types),
+				enabled = coalesce($6, enabled),
+				consecutive_failures = CASE WHEN $6 THEN 0 ELSE consecutive_failures END,
+				disabled_at = CASE WHEN $6 THEN NULL WHEN NOT $6 THEN coalesce(disabled_at, CURRENT_TIMESTAMP) ELSE disabled_at END,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE
+				webhook_id::text = $1
+				AND tenant_id = $2
+			RETURNING `+webhookColumns+`
+		`,
+		webhookID, tenantID, update.URL, update.Description, eventTypes, update.Enabled,
+	))
+	return
+}
+
+// DeleteWebhook removes a webhook and its delivery log, it fails with sql.ErrNoRows when the
+// tenant has no such webhook
+func (r *Repository) DeleteWebhook(ctx context.Context, tenantID string, webhookID string) (err error) {
+	res, err := r.db.ExecContext(ctx, `
+			DELETE FROM webhooks
+			WHERE
+				webhook_id::text = $1
+				AND tenant_id = $2
+		`,
+		webhookID, tenantID,
+	)
+	if err != nil {
+		return
+	}
+
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = sql.ErrNoRows
+	}
+	return
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a webhook, of a status when it is set
+func (r *Repository) ListWebhookDeliveries(
+	ctx context.Context,
+	tenantID string,
+	webhookID string,
+	status string,
+	limit int,
+) (deliveries []models.WebhookDelivery, err error) {
+
+	rows, err := r.db.QueryContext(ctx, `
+			SELECT
+				`+deliveryColumns+`
+			FROM webhook_deliveries
+			WHERE webhook_id::text = $1
+				AND tenant_id = $2
+				AND ($3 = '' OR status = $3)
+			ORDER BY created_at DESC, delivery_id
+			LIMIT $4
+		`,
+		webhookID, tenantID, status, limit,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	deliveries = []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if delivery, err = scanDelivery(rows); err != nil {
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	err = rows.Err()
+	return
+}
+
+// ReplayWebhookDelivery queues the event of a delivery to be posted again as a new delivery,
+// it fails with sql.ErrNoRows when the webhook of the tenant has no such delivery
+func (r *Repository) ReplayWebhookDelivery(
+	ctx context.Context,
+	tenantID string,
+	webhookID string,
+	deliveryID string,
+) (delivery models.WebhookDelivery, err error) {
+
+	delivery, err = scanDelivery(r.db.QueryRowContext(ctx, `
+			INSERT INTO webhook_deliveries (webhook_id, tenant_id, event_id, event_type, payload, replay_of)
+			SELECT
+				webhook_id, tenant_id, event_id, event_type, payload, delivery_id
+			FROM webhook_deliveries
+			WHERE delivery_id::text = $1
+				AND webhook_id::text = $2
+				AND tenant_id = $3
+			RETURNING `+deliveryColumns+`
+		`,
+		deliveryID, webhookID, tenantID,
+	))
+	return
+}
+
+// QueueWebhookDeliveries queues a delivery of the oldest outbox events not queued yet to every
+// enabled webhook of their tenant subscribed to their type, and returns how many events were
+// queued
+func (r *Repository) QueueWebhookDeliveries(ctx context.Context, batchSize int) (queued int, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+			SELECT
+				outbox_id, event_id, event_type, payload
+			FROM company_outbox
+			WHERE webhooks_queued_at IS NULL
+			ORDER BY outbox_id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		`,
+		batchSize,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	type outboxEvent struct {
+		id        int64
+		eventID   string
+		eventType string
+		payload   []byte
+	}
+	var events []outboxEvent
+	for rows.Next() {
+		var event outboxEvent
+		if err = rows.Scan(&event.id, &event.eventID, &event.eventType, &event.payload); err != nil {
+			return
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	rows.Close()
+	if len(events) == 0 {
+		return
+	}
+
+	for _, event := range events {
+		_, err = tx.ExecContext(ctx, `
+				INSERT INTO webhook_deliveries (webhook_id, tenant_id, event_id, event_type, payload)
+				SELECT
+					webhook_id, tenant_id, $1, $2, $3
+				FROM webhooks
+				WHERE tenant_id = $3::jsonb->>'tenantId'
+					AND enabled
+					AND (event_types = '[]' OR event_types @> jsonb_build_array($2::text))
+			`,
+			event.eventID, event.eventType, event.payload,
+		)
+		if err != nil {
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, `
+				UPDATE company_outbox
+				SET
+					webhooks_queued_at = CURRENT_TIMESTAMP
+				WHERE
+					outbox_id = $1
+			`,
+			event.id,
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	queued = len(events)
+	return
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries of enabled webhooks that are
+// due, oldest first, and holds them for lease so that other replicas do not send them too
+func (r *Repository) ClaimWebhookDeliveries(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+) (deliveries []models.PendingDelivery, err error) {
+
+	rows, err := r.db.QueryContext(ctx, `
+			UPDATE webhook_deliveries d
+			SET
+				next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+			FROM webhooks w
+			WHERE w.webhook_id = d.webhook_id
+				AND d.delivery_id IN (
+					SELECT
+						pending.delivery_id
+					FROM webhook_deliveries pending
+					JOIN webhooks hook ON hook.webhook_id = pending.webhook_id
+					WHERE pending.status = 'pending'
+						AND pending.next_attempt_at <= CURRENT_TIMESTAMP
+						AND hook.enabled
+					ORDER BY pending.next_attempt_at
+					LIMIT $1
+					FOR UPDATE OF pending SKIP LOCKED
+				)
+			RETURNING `+claimedDeliveryColumns+`, w.url, w.secret
+		`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery models.PendingDelivery
+		delivery.WebhookDelivery, err = scanDelivery(rows, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	err = rows.Err()
+	return
+}
+
+// RecordWebhookAttempt saves the outcome of an attempt to deliver. A success resets the
+// failures of the webhook, a failure counts towards the disableAfter failures in a row that
+// disable it. It reports whether the webhook is disabled after a failure
+func (r *Repository) RecordWebhookAttempt(
+	ctx context.Context,
+	attempt models.WebhookAttempt,
+	disableAfter int,
+) (disabled bool, err error) {
+
+	status := models.DeliverySucceeded
+	if !attempt.Succeeded {
+		status = models.DeliveryFailed
+		if attempt.NextAttemptAt != nil {
+			status = models.DeliveryPending
+		}
+	}
+
+	var (
+		nextAttemptAt *time.Time
+		lastError     *string
+	)
+	if attempt.NextAttemptAt != nil {
+		t := attempt.NextAttemptAt.UTC()
+		nextAttemptAt = &t
+	}
+	if attempt.Error != "" {
+		lastError = &attempt.Error
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET
+				status = $2,
+				attempts = attempts + 1,
+				next_attempt_at = coalesce($3, next_attempt_at),
+				last_attempt_at = CURRENT_TIMESTAMP,
+				response_status = $4,
+				last_error = $5,
+				delivered_at = CASE WHEN $2 = 'succeeded' THEN CURRENT_TIMESTAMP END
+			WHERE
+				delivery_id::text = $1
+		`,
+		attempt.DeliveryID, status, nextAttemptAt, attempt.ResponseStatus, lastError,
+	)
+	if err != nil {
+		return
+	}
+
+	if attempt.Succeeded {
+		_, err = tx.ExecContext(ctx, `
+				UPDATE webhooks
+				SET
+					consecutive_failures = 0
+				WHERE
+					webhook_id::text = $1
+			`,
+			attempt.WebhookID,
+		)
+	} else {
+		var enabled bool
+		err = tx.QueryRowContext(ctx, `
+				UPDATE webhooks
+				SET
+					consecutive_failures = consecutive_failures + 1,
+					enabled = enabled AND consecutive_failures + 1 < $2,
+					disabled_at = CASE WHEN enabled AND consecutive_failures + 1 >= $2 THEN CURRENT_TIMESTAMP ELSE disabled_at END
+				WHERE
+					webhook_id::text = $1
+				RETURNING enabled
+			`,
+			attempt.WebhookID, disableAfter,
+		).Scan(&enabled)
+		if err == sql.ErrNoRows {
+			// the webhook was deleted meanwhile
+			err = nil
+			enabled = true
+		}
+		disabled = !enabled
+	}
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// PurgeWebhookDeliveries deletes the deliveries that succeeded or failed before finishedBefore
+func (r *Repository) PurgeWebhookDeliveries(ctx context.Context, finishedBefore time.Time) (purged int, err error) {
+	res, err := r.db.ExecContext(ctx, `
+			DELETE FROM webhook_deliveries
+			WHERE
+				status <> 'pending'
+				AND last_attempt_at < $1
+		`,
+		finishedBefore.UTC(),
+	)
+	if err != nil {
+		return
+	}
+
+	n, err := res.RowsAffected()
+	purged = int(n)
+	return
+}
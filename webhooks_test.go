@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/models"
+)
+
+func TestWebhooks(t *testing.T) {
+
+	t.Run("Create webhook", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		createdAt := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+		mockDB.ExpectQuery(`
+			INSERT INTO webhooks (tenant_id, url, description, event_types, secret, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING webhook_id, url, description, event_types, enabled, consecutive_failures, disabled_at, created_by, created_at, updated_at
+		`).
+			WithArgs(testTenantID, "https://hooks.example.com/xm", "crm sync", []byte(`["company.updated"]`), sqlmock.AnyArg(), testAdminUUID).
+			WillReturnRows(sqlmock.NewRows([]string{"webhook_id", "url", "description", "event_types", "enabled", "consecutive_failures", "disabled_at", "created_by", "created_at", "updated_at"}).
+				AddRow(testWebhookID, "https://hooks.example.com/xm", "crm sync", []byte(`["company.updated"]`), true, 0, nil, testAdminUUID, createdAt, createdAt))
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http:/api/v1/webhooks", strings.NewReader(`{"url":"https://hooks.example.com/xm","description":"crm sync","eventTypes":["company.updated"]}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		var webhook models.NewWebhookResp
+		require.NoError(json.NewDecoder(resp.Body).Decode(&webhook))
+		assert.Equal(testWebhookID, webhook.ID)
+		assert.Equal([]string{"company.updated"}, webhook.EventTypes)
+		assert.True(webhook.Enabled)
+		assert.True(strings.HasPrefix(webhook.Secret, "whsec_"))
+
+		assert.Equal(200, resp.StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Webhook URL must be https", func(t *testing.T) {
+		for _, url := range []string{"ftp://hooks.example.com/xm", "http://hooks.example.com/xm"} {
+			assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+			accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+			require.NoError(err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http:/api/v1/webhooks", strings.NewReader(`{"url":"`+url+`"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+			testHTTPHandler.ServeHTTP(w, r)
+
+			assert.Equal(422, w.Result().StatusCode, url)
+
+			assert.NoError(mockDB.ExpectationsWereMet())
+		}
+	})
+
+	t.Run("Webhooks require admin", func(t *testing.T) {
+		assert, require, _, testHTTPHandler := newTestHTTPHandler(t)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http:/api/v1/webhooks", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(403, w.Result().StatusCode)
+	})
+
+	t.Run("Replay unknown delivery", func(t *testing.T) {
+		assert, require, mockDB, testHTTPHandler := newTestHTTPHandler(t)
+
+		deliveryID := "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		mockDB.ExpectQuery(`
+			INSERT INTO webhook_deliveries (webhook_id, tenant_id, event_id, event_type, payload, replay_of)
+			SELECT
+				webhook_id, tenant_id, event_id, event_type, payload, delivery_id
+			FROM webhook_deliveries
+			WHERE delivery_id::text = $1
+				AND webhook_id::text = $2
+				AND tenant_id = $3
+			RETURNING delivery_id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_attempt_at, response_status, last_error, replay_of, created_at, delivered_at
+		`).
+			WithArgs(deliveryID, testWebhookID, testTenantID).
+			WillReturnError(sql.ErrNoRows)
+
+		accessToken, err := helpers.GenerateAccessToken(cfg, testAdminIdentity)
+		require.NoError(err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http:/api/v1/webhooks/"+testWebhookID+"/deliveries/"+deliveryID+"/replay", nil)
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		testHTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(404, w.Result().StatusCode)
+
+		assert.NoError(mockDB.ExpectationsWereMet())
+	})
+}
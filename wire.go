@@ -9,7 +9,14 @@ import (
 	"github.com/danielboakye/go-xm/config"
 	"github.com/danielboakye/go-xm/handlers"
 	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/pkg/companyrpc"
+	"github.com/danielboakye/go-xm/pkg/ingest"
+	"github.com/danielboakye/go-xm/pkg/jobs"
+	"github.com/danielboakye/go-xm/pkg/kfkp"
 	"github.com/danielboakye/go-xm/pkg/postgres"
+	"github.com/danielboakye/go-xm/pkg/retention"
+	"github.com/danielboakye/go-xm/pkg/revocation"
+	"github.com/danielboakye/go-xm/pkg/webhook"
 	"github.com/danielboakye/go-xm/repo"
 	"github.com/google/wire"
 )
@@ -19,11 +26,22 @@ func buildCompileTime(ctx context.Context) (httpServer HTTPServer, err error) {
 		config.NewConfigurations,
 		helpers.NewValidation,
 		postgres.NewConnection,
+		kfkp.NewPublisher,
+		kfkp.NewRelay,
 
 		repo.NewRepository,
+		revocation.NewStore,
+		retention.NewJob,
 		handlers.NewHandler,
+		handlers.NewJobRunners,
+		jobs.NewPool,
+		companyrpc.NewServer,
+		webhook.NewDispatcher,
+		ingest.NewConsumer,
 
 		newHTTPHandler,
+		newWorkers,
+		newClosers,
 		newHTTPServer,
 	)
 
@@ -11,7 +11,14 @@ import (
 	"github.com/danielboakye/go-xm/config"
 	"github.com/danielboakye/go-xm/handlers"
 	"github.com/danielboakye/go-xm/helpers"
+	"github.com/danielboakye/go-xm/pkg/companyrpc"
+	"github.com/danielboakye/go-xm/pkg/ingest"
+	"github.com/danielboakye/go-xm/pkg/jobs"
+	"github.com/danielboakye/go-xm/pkg/kfkp"
 	"github.com/danielboakye/go-xm/pkg/postgres"
+	"github.com/danielboakye/go-xm/pkg/retention"
+	"github.com/danielboakye/go-xm/pkg/revocation"
+	"github.com/danielboakye/go-xm/pkg/webhook"
 	"github.com/danielboakye/go-xm/repo"
 )
 
@@ -35,8 +42,19 @@ func buildCompileTime(ctx context.Context) (HTTPServer, error) {
 	if err != nil {
 		return HTTPServer{}, err
 	}
-	handler := handlers.NewHandler(iRepository, validation, configurations)
-	ihttpHandler := newHTTPHandler(handler, configurations)
-	httpServer := newHTTPServer(ihttpHandler, configurations)
+	iStore := revocation.NewStore(iRepository, configurations)
+	handler := handlers.NewHandler(iRepository, validation, iStore, configurations)
+	ihttpHandler := newHTTPHandler(handler, iRepository, validation, iStore, configurations)
+	iPublisher := kfkp.NewPublisher(configurations)
+	relay := kfkp.NewRelay(db, iPublisher, configurations)
+	job := retention.NewJob(iRepository, configurations)
+	runners := handlers.NewJobRunners(handler)
+	pool := jobs.NewPool(iRepository, runners, configurations)
+	server := companyrpc.NewServer(iRepository, validation, iStore, configurations)
+	dispatcher := webhook.NewDispatcher(iRepository, configurations)
+	consumer := ingest.NewConsumer(iRepository, validation, configurations)
+	v := newWorkers(relay, job, pool, server, dispatcher, consumer)
+	v2 := newClosers(iPublisher, db)
+	httpServer := newHTTPServer(ihttpHandler, handler, configurations, v, v2)
 	return httpServer, nil
 }